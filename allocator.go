@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+var (
+	// defaultMem holds the allocator that was installed before the counting
+	// allocator, which every call is delegated to.
+	defaultMem sqlite3.Tsqlite3_mem_methods
+
+	allocBytes [repro.PhaseCount]atomic.Int64
+	allocCount [repro.PhaseCount]atomic.Int64
+
+	// pageSizedAllocs counts allocations that look like a page cache line:
+	// a page plus less than pageAllocSlack of headers. Exactly a page is left
+	// out, every connection allocates one such scratch buffer.
+	// With SQLITE_CONFIG_PAGECACHE in effect these only happen once the
+	// preallocated slots run out. allocPageSize is the page size of the
+	// databases.
+	pageSizedAllocs atomic.Int64
+	allocPageSize   int32
+
+	// allocSizes and freeSizes count the allocations and frees by size
+	// bucket, see sizeBucket. A realloc counts as an allocation of its new
+	// size and not as a free, and a free goes by the size xSize reports,
+	// which the allocator may have rounded up.
+	allocSizes [sizeBuckets]atomic.Int64
+	freeSizes  [sizeBuckets]atomic.Int64
+)
+
+// sizeBuckets is the number of power of two buckets sizeBucket sorts sizes
+// into, enough for any int32.
+const sizeBuckets = 33
+
+// sizeBucket returns the bucket of an allocation of n bytes: bucket b holds
+// the sizes from 2^(b-1)+1 to 2^b, bucket 0 the empty ones.
+func sizeBucket(n int32) int {
+	if n <= 0 {
+		return 0
+	}
+	return bits.Len32(uint32(n - 1))
+}
+
+// pageAllocSlack bounds the per-page header overhead pageSizedAllocs allows.
+const pageAllocSlack = 512
+
+// installCountingAllocator wraps SQLite's allocator so that every malloc and
+// realloc is counted against the phase of the connection making it, and every
+// malloc, realloc and free by size, and pageSize-sized ones in pageSizedAllocs.
+// It must run before SQLite is initialized.
+func installCountingAllocator(pageSize int) error {
+	allocPageSize = int32(pageSize)
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	methods := libc.Xmalloc(tls, types.Size_t(unsafe.Sizeof(sqlite3.Tsqlite3_mem_methods{})))
+	if methods == 0 {
+		return fmt.Errorf("sqlite: install counting allocator: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, methods)
+
+	list := libc.NewVaList(methods)
+	if list == 0 {
+		return fmt.Errorf("sqlite: install counting allocator: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_GETMALLOC, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to get SQLITE_CONFIG_GETMALLOC: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	defaultMem = *(*sqlite3.Tsqlite3_mem_methods)(unsafe.Pointer(methods))
+
+	counting := defaultMem
+	counting.FxMalloc = cFuncPointer(countingMalloc)
+	counting.FxRealloc = cFuncPointer(countingRealloc)
+	counting.FxFree = cFuncPointer(countingFree)
+	*(*sqlite3.Tsqlite3_mem_methods)(unsafe.Pointer(methods)) = counting
+
+	// SQLite copies the struct, so methods can be freed on return.
+	list2 := libc.NewVaList(methods)
+	if list2 == 0 {
+		return fmt.Errorf("sqlite: install counting allocator: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list2)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_MALLOC, list2); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_MALLOC: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
+
+func countingMalloc(tls *libc.TLS, n int32) uintptr {
+	p := (*(*func(*libc.TLS, int32) uintptr)(unsafe.Pointer(&struct{ uintptr }{defaultMem.FxMalloc})))(tls, n)
+	if p != 0 {
+		countAlloc(tls, n)
+	}
+	return p
+}
+
+func countingRealloc(tls *libc.TLS, prior uintptr, n int32) uintptr {
+	p := (*(*func(*libc.TLS, uintptr, int32) uintptr)(unsafe.Pointer(&struct{ uintptr }{defaultMem.FxRealloc})))(tls, prior, n)
+	if p != 0 {
+		countAlloc(tls, n)
+	}
+	return p
+}
+
+func countingFree(tls *libc.TLS, p uintptr) {
+	if p != 0 {
+		n := (*(*func(*libc.TLS, uintptr) int32)(unsafe.Pointer(&struct{ uintptr }{defaultMem.FxSize})))(tls, p)
+		freeSizes[sizeBucket(n)].Add(1)
+	}
+	(*(*func(*libc.TLS, uintptr))(unsafe.Pointer(&struct{ uintptr }{defaultMem.FxFree})))(tls, p)
+}
+
+func countAlloc(tls *libc.TLS, n int32) {
+	phase := repro.TLSAllocPhase(tls)
+	allocBytes[phase].Add(int64(n))
+	allocCount[phase].Add(1)
+	allocSizes[sizeBucket(n)].Add(1)
+	if n > allocPageSize && n < allocPageSize+pageAllocSlack {
+		pageSizedAllocs.Add(1)
+	}
+}
+
+func printAllocPhases() {
+	fmt.Println("sqlite: allocations by phase:")
+	for phase := repro.PhaseOther; phase < repro.PhaseCount; phase++ {
+		fmt.Printf("%v: %v bytes in %v allocations\n", phase, allocBytes[phase].Load(), allocCount[phase].Load())
+	}
+}
+
+// printAllocSizes prints the allocations and frees counted in every non-empty
+// size bucket.
+func printAllocSizes() {
+	fmt.Println("sqlite: allocations by size (mallocs and reallocs/frees):")
+	for b := range sizeBuckets {
+		allocs, frees := allocSizes[b].Load(), freeSizes[b].Load()
+		if allocs == 0 && frees == 0 {
+			continue
+		}
+		upper := uint64(0)
+		if b > 0 {
+			upper = 1 << b
+		}
+		fmt.Printf("<=%d: %d/%d\n", upper, allocs, frees)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// allocatorStat is the memory.allocator expvar, the counters of the libc
+// allocator, next to SQLite's MEMORY_USED read at the same time.
+type allocatorStat struct {
+	Allocs int64 `json:"allocs"`
+	// Bytes is what the allocator currently holds from the OS.
+	Bytes int64 `json:"bytes"`
+	Mmaps int64 `json:"mmaps"`
+	// MemoryUsed is SQLite's MEMORY_USED read alongside and Gap Bytes minus
+	// it: the allocator's own overhead and what libc allocated for others
+	// than SQLite.
+	MemoryUsed int64 `json:"memory_used"`
+	Gap        int64 `json:"gap"`
+}
+
+// parseAllocatorExpvar returns the counters of the memory.allocator expvar.
+// The expvar only exists with -tags libc.memexpvar, and its counters only
+// move with the default allocator built with -tags memory.counters.
+func parseAllocatorExpvar() (allocatorStat, error) {
+	v := expvar.Get("memory.allocator")
+	if v == nil {
+		return allocatorStat{}, errors.New("the memory.allocator expvar is not published, build with -tags libc.memexpvar,memory.counters")
+	}
+	var stat allocatorStat
+	// The fields of the expvar are the capitalized names of the tags, which
+	// json matches without regard to case.
+	if err := json.Unmarshal([]byte(v.String()), &stat); err != nil {
+		return allocatorStat{}, err
+	}
+	return stat, nil
+}
+
+// allocatorBytes returns the Bytes counter of the memory.allocator expvar.
+func allocatorBytes() (int64, error) {
+	stat, err := parseAllocatorExpvar()
+	return stat.Bytes, err
+}
+
+// readAllocatorStat returns the memory.allocator expvar along with SQLite's
+// MEMORY_USED.
+func readAllocatorStat(tls *libc.TLS) (allocatorStat, error) {
+	stat, err := parseAllocatorExpvar()
+	if err != nil {
+		return stat, err
+	}
+	if stat.MemoryUsed, _, err = repro.Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED); err != nil {
+		return stat, err
+	}
+	stat.Gap = stat.Bytes - stat.MemoryUsed
+	return stat, nil
+}
+
+// mismatch reports whether the allocator holds less than SQLite counts as in
+// use, which one of them must be miscounting: every byte of MEMORY_USED is in
+// an allocation of the allocator. Counters that never moved don't count.
+func (s allocatorStat) mismatch() bool {
+	return s.Bytes > 0 && s.Bytes < s.MemoryUsed
+}
+
+func (s allocatorStat) String() string {
+	return fmt.Sprintf("allocator: allocs=%d bytes=%d mmaps=%d MEMORY_USED=%d gap=%d", s.Allocs, s.Bytes, s.Mmaps, s.MemoryUsed, s.Gap)
+}
+
+// printAllocatorStat writes the memory.allocator expvar and MEMORY_USED, if
+// the expvar is published, and warns when they don't add up.
+func printAllocatorStat(w io.Writer, tls *libc.TLS) {
+	stat, err := readAllocatorStat(tls)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(w, stat)
+	switch {
+	case stat.Allocs == 0 && stat.Bytes == 0:
+		fmt.Fprintf(os.Stderr, "warning: allocator: the %s allocator reported nothing, its counters need the memory allocator built with -tags memory.counters\n", libcAllocator)
+	case stat.mismatch():
+		warnAllocatorMismatch("allocator", stat)
+	}
+}
+
+// warnAllocatorMismatch logs a stat whose mismatch is set.
+func warnAllocatorMismatch(label string, stat allocatorStat) {
+	fmt.Fprintf(os.Stderr, "warning: %s: the libc allocator holds %d bytes, %d less than SQLite's MEMORY_USED of %d, one of the two miscounts\n",
+		label, stat.Bytes, -stat.Gap, stat.MemoryUsed)
+}
+
+// sampleAllocatorPeak polls allocatorBytes each interval until stop is closed
+// and keeps the largest value in peak. libc keeps no highwater of its own, so
+// a peak between two samples is missed.
+func sampleAllocatorPeak(stop <-chan struct{}, interval time.Duration, peak *atomic.Int64) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if b, err := allocatorBytes(); err == nil && b > peak.Load() {
+			peak.Store(b)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
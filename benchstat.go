@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// printBenchstat writes the run's MEMORY_USED highwater, that highwater per
+// 1000 rows inserted and MALLOC_COUNT as one line of Go benchmark output,
+// named after -inserts and -db-count so that benchstat only compares runs of
+// the same size unless told to. benchstat skips every other line of the
+// output.
+func printBenchstat(w io.Writer, memUsedHighwater, memUsedPer1kRows, mallocCount int64, cfg *Config) {
+	fmt.Fprintf(w, "BenchmarkSQLiteRepro/inserts=%d/dbs=%d 1 %d memused-hw-bytes %d bytes/1k-rows %d mallocs\n",
+		cfg.Inserts, cfg.DBCount, memUsedHighwater, memUsedPer1kRows, mallocCount)
+}
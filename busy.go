@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// busyMaxRetries bounds how many times countingBusyHandler lets SQLite retry
+// one lock, roughly a 5s busy_timeout.
+const busyMaxRetries = 5000
+
+// busyInvocations counts the calls to countingBusyHandler across every
+// connection, each one a lock SQLite found held.
+var busyInvocations atomic.Int64
+
+// countingBusyHandler counts the call, yields for a millisecond and asks SQLite
+// to retry, giving up with SQLITE_BUSY after busyMaxRetries attempts.
+func countingBusyHandler(tls *libc.TLS, arg uintptr, n int32) int32 {
+	busyInvocations.Add(1)
+	if n >= busyMaxRetries {
+		return 0
+	}
+	time.Sleep(time.Millisecond)
+	return 1
+}
+
+// installBusyHandler makes db call countingBusyHandler whenever it hits a
+// lock, replacing any busy_timeout.
+func installBusyHandler(tls *libc.TLS, db uintptr) error {
+	if rc := sqlite3.Xsqlite3_busy_handler(tls, db, cFuncPointer(countingBusyHandler), 0); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: busy_handler: %v", rc)
+	}
+	return nil
+}
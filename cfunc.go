@@ -0,0 +1,11 @@
+package main
+
+import "unsafe"
+
+// cFuncPointer converts a top-level Go function to the uintptr form the
+// transpiled SQLite expects for C function pointers, the same way
+// modernc.org/sqlite registers its own callbacks. Passing a closure is
+// undefined behavior.
+func cFuncPointer[T any](f T) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&struct{ f T }{f}))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// chromeTrace collects events in the Chrome Trace Event format, viewable in
+// chrome://tracing or Perfetto. A nil *chromeTrace drops everything, so call
+// sites don't need to check whether -chrome-trace is set.
+type chromeTrace struct {
+	start time.Time
+
+	mu     sync.Mutex
+	events []traceEvent
+	// nextTid numbers the timeline rows handed out by NewTrack.
+	nextTid atomic.Int64
+}
+
+// traceEvent is one entry of the traceEvents array. Ts and Dur are in
+// microseconds since the trace started.
+type traceEvent struct {
+	Name string           `json:"name"`
+	Ph   string           `json:"ph"`
+	Ts   float64          `json:"ts"`
+	Dur  float64          `json:"dur,omitempty"`
+	Pid  int              `json:"pid"`
+	Tid  int64            `json:"tid"`
+	Args map[string]int64 `json:"args,omitempty"`
+}
+
+// timeline is the -chrome-trace trace, nil when the flag isn't set.
+var timeline *chromeTrace
+
+func newChromeTrace() *chromeTrace {
+	return &chromeTrace{start: time.Now()}
+}
+
+// NewTrack returns a fresh tid, so phases running concurrently, one database
+// each, get a row of their own. tid 0 is the process-wide row.
+func (t *chromeTrace) NewTrack() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.nextTid.Add(1)
+}
+
+// Phase starts a duration event on row tid and returns the function ending it.
+func (t *chromeTrace) Phase(name string, tid int64) (end func()) {
+	if t == nil {
+		return func() {}
+	}
+	begin := time.Now()
+	return func() {
+		t.add(traceEvent{Name: name, Ph: "X", Ts: t.micros(begin), Dur: float64(time.Since(begin).Microseconds()), Tid: tid})
+	}
+}
+
+// counter records a counter event, drawn as one stacked series per key.
+func (t *chromeTrace) counter(name string, values map[string]int64) {
+	if t == nil {
+		return
+	}
+	t.add(traceEvent{Name: name, Ph: "C", Ts: t.micros(time.Now()), Args: values})
+}
+
+func (t *chromeTrace) micros(at time.Time) float64 {
+	return float64(at.Sub(t.start).Nanoseconds()) / 1e3
+}
+
+func (t *chromeTrace) add(e traceEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+func (t *chromeTrace) write(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, err := json.Marshal(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{t.events})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o644)
+}
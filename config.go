@@ -0,0 +1,115 @@
+package main
+
+import (
+	"time"
+
+	"sqlite-repro/repro"
+)
+
+// Config is what one run of the command does: the workload of repro.Config
+// and everything measured and reported around it. main binds the flags into
+// one, see newFlagSet, and logs it, so every run's output records what it ran
+// with.
+type Config struct {
+	repro.Config
+
+	// The report. DiffThreshold also applies to ComparePath.
+	Summary       bool
+	PerConn       bool
+	SortConns     bool
+	OutputFormat  string
+	Quiet         bool
+	Verbose       bool
+	ReportPath    string
+	ComparePath   string
+	Diff          bool
+	DiffThreshold float64
+	StreamJSON    bool
+	CSVOut        string
+
+	// The sampler and what reads it, ResetInterval 0 runs no sampler.
+	SampleInterval  time.Duration
+	ResetInterval   time.Duration
+	AlignSamples    bool
+	StatsdAddr      string
+	MaxRSS          int64
+	ChromeTracePath string
+
+	// The checks that fail the run.
+	CheckROWrites      bool
+	VerifyMmapMaxCache int
+	CheckAggregate     bool
+	CheckBaseline      bool
+	BaselineSlack      int64
+	ExpectConns        int
+	ExpectConnsSlack   int
+	AssertMaxMem       int64
+	VerifyPrealloc     bool
+	ValidatePrealloc   bool
+	FailOnTmpfs        bool
+
+	// How SQLite is configured before it is initialized. LookasideCount
+	// and DBLookasideCount -1 keep the defaults.
+	PreallocateBytes int
+	PageCacheBacking string
+	SoftHeapLimit    int64
+	HardHeapLimit    int64
+	LookasideSize    int
+	LookasideCount   int
+	DBLookasideSize  int
+	DBLookasideCount int
+	HeapBytes        int
+	HeapMinAlloc     int
+	Allocator        string
+
+	// What the connection hook and the allocator count besides db_status.
+	MaxTrackedConns  int
+	VMStats          bool
+	TraceSQL         bool
+	BusyHandler      bool
+	AllocHistogram   bool
+	LibMutexStats    bool
+	AllocatorGap     bool
+	ReportAllocator  bool
+	ClassifyRetained bool
+
+	// What runs instead of, or around, the workload.
+	Minimal        bool
+	Repeat         int
+	ShortLived     int
+	Duration       time.Duration
+	WarmupDBs      int
+	ErrorPolicy    string
+	ShareDir       bool
+	BalloonBytes   int
+	DDLChurnCycles int
+	HookCost       int
+	OpenPath       string
+	SelectSQL      string
+	DryRun         bool
+	Wait           bool
+	Targets        []string
+	CacheSizeSweep []int
+
+	// The process.
+	PprofAddr  string
+	CPUProfile string
+	MemProfile string
+}
+
+// resolve settles the settings that depend on others, once the flags are
+// validated.
+func (c *Config) resolve() {
+	if c.WALShm > 0 {
+		c.JournalMode, c.WALAutocheckpoint = "wal", c.WALShm
+	}
+	if c.SQLFile != "" {
+		// The selects read table t, which the file needn't create.
+		c.ParallelSelects = 0
+	}
+	if c.InMemory && c.JournalMode == "delete" {
+		// An in-memory database always keeps its journal in memory, SQLite
+		// answers pragma journal_mode=delete with memory.
+		c.JournalMode = "memory"
+	}
+}
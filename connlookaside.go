@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"modernc.org/libc"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// connLookasideConfigured counts the connections configureConnLookaside set
+// up, and connLookasideConfirmed those whose probe statement then used
+// lookaside exactly when it was enabled.
+var connLookasideConfigured, connLookasideConfirmed atomic.Int64
+
+// configureConnLookaside gives the new connection db, conn's handle, a
+// lookaside of -conn-lookaside-count slots of -conn-lookaside-slot-size bytes
+// with SQLITE_DBCONFIG_LOOKASIDE, in a buffer SQLite allocates itself, then
+// prepares a statement on it and reads the LOOKASIDE_USED highwater back.
+// It must run before the connection takes any lookaside, which the connection
+// hook does.
+func configureConnLookaside(tls *libc.TLS, conn sqlite.ExecQuerierContext, db uintptr, cfg *Config) error {
+	list := libc.NewVaList(uintptr(0), int32(cfg.DBLookasideSize), int32(cfg.DBLookasideCount))
+	if list == 0 {
+		return fmt.Errorf("sqlite: configure connection lookaside: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_db_config(tls, db, sqlite3.SQLITE_DBCONFIG_LOOKASIDE, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_DBCONFIG_LOOKASIDE: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	connLookasideConfigured.Add(1)
+
+	if _, err := conn.ExecContext(context.Background(), "select 1", nil); err != nil {
+		return err
+	}
+	// Only reset the highwater of what the probe took, the slots are all
+	// back by now.
+	_, highwater, err := repro.DBStatus(tls, db, sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED, 1)
+	if err != nil {
+		return err
+	}
+	if (highwater > 0) == (cfg.DBLookasideCount > 0) {
+		connLookasideConfirmed.Add(1)
+	}
+	return nil
+}
+
+// printConnLookaside writes how many connections got their lookaside from
+// -conn-lookaside-count and how many of them the probe confirmed.
+func printConnLookaside(w io.Writer, cfg *Config) {
+	fmt.Fprintf(w, "conn-lookaside: slot_size=%d count=%d connections configured=%d confirmed=%d\n",
+		cfg.DBLookasideSize, cfg.DBLookasideCount, connLookasideConfigured.Load(), connLookasideConfirmed.Load())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+	"time"
+)
+
+// csvSink appends the -duration samples to a CSV file, flushing every row so
+// that a run that crashes still leaves the samples taken until then.
+type csvSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// newCSVSink creates path, truncating it if it exists, and writes the header.
+func newCSVSink(path string) (*csvSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{f: f, w: csv.NewWriter(f)}
+	if err = s.writeRow("timestamp", "memory_used", "memory_used_highwater", "cache_used", "lookaside_used", "go_heap_alloc"); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// write appends sample as one row.
+func (s *csvSink) write(sample durationSample) error {
+	var heapAlloc uint64
+	if sample.DB.GoHeap != nil {
+		heapAlloc = sample.DB.GoHeap.HeapAlloc
+	}
+	return s.writeRow(
+		sample.Time.Format(time.RFC3339Nano),
+		strconv.FormatInt(sample.Global.Current["MEMORY_USED"], 10),
+		strconv.FormatInt(sample.Global.Highwater["MEMORY_USED"], 10),
+		strconv.FormatInt(sample.DB.CacheUsed, 10),
+		strconv.FormatInt(sample.DB.LookasideUsed, 10),
+		strconv.FormatUint(heapAlloc, 10),
+	)
+}
+
+func (s *csvSink) writeRow(fields ...string) error {
+	if err := s.w.Write(fields); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Close() error {
+	return s.f.Close()
+}
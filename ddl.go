@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// ddlChurnTables is how many tables every -ddl-churn cycle creates before
+// dropping them all again.
+const ddlChurnTables = 10
+
+// ddlChurn creates and drops ddlChurnTables tables cycles times on a dedicated
+// connection to its own database, sampling that connection's SCHEMA_USED, and
+// prints whether the schema cache shrinks back after the drops. The
+// connection stays open, it is registered like any other, until close is
+// called.
+func ddlChurn(tls *libc.TLS, cycles int, cfg *Config) (err error, close func() error) {
+	dir, err := os.MkdirTemp(cfg.TempDir, "test-*")
+	if err != nil {
+		return err, nil
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite2", filepath.Join(dir, "db"))
+	if err != nil {
+		return err, nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return err, nil
+	}
+	close = func() error {
+		conn.Close()
+		return db.Close()
+	}
+
+	handle, err := repro.SQLConnHandle(conn)
+	if err != nil {
+		close()
+		return err, nil
+	}
+	// statusErr keeps the first failed read of schemaUsed, which then counts
+	// as 0.
+	var statusErr error
+	schemaUsed := func() int32 {
+		current, _, err := repro.DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, 0)
+		if err != nil && statusErr == nil {
+			statusErr = err
+		}
+		return current
+	}
+
+	// Load the (empty) schema first so the baseline includes sqlite_schema.
+	if _, err = conn.ExecContext(ctx, "select count(*) from sqlite_schema"); err != nil {
+		close()
+		return err, nil
+	}
+	baseline := schemaUsed()
+
+	var peak, afterFirstDrops, afterDrops int32
+	for c := 0; c < cycles; c++ {
+		for t := 0; t < ddlChurnTables; t++ {
+			if _, err = conn.ExecContext(ctx, fmt.Sprintf("create table churn%d(i int, str text, b blob)", t)); err != nil {
+				close()
+				return err, nil
+			}
+		}
+		peak = max(peak, schemaUsed())
+		for t := 0; t < ddlChurnTables; t++ {
+			if _, err = conn.ExecContext(ctx, fmt.Sprintf("drop table churn%d", t)); err != nil {
+				close()
+				return err, nil
+			}
+		}
+		afterDrops = schemaUsed()
+		if c == 0 {
+			afterFirstDrops = afterDrops
+		}
+	}
+
+	if statusErr != nil {
+		close()
+		return fmt.Errorf("ddl-churn: %w", statusErr), nil
+	}
+	fmt.Printf("ddl-churn: cycles=%d tables_per_cycle=%d SCHEMA_USED baseline=%d peak=%d after_first_drops=%d after_last_drops=%d\n",
+		cycles, ddlChurnTables, baseline, peak, afterFirstDrops, afterDrops)
+	// A constant residue after the first cycle is the schema hash tables
+	// keeping the size they grew to. Growth from cycle to cycle is a leak.
+	switch {
+	case afterDrops > afterFirstDrops:
+		fmt.Printf("ddl-churn: SCHEMA_USED grew by %d bytes across cycles after every table was dropped\n", afterDrops-afterFirstDrops)
+	case afterDrops > baseline:
+		fmt.Printf("ddl-churn: SCHEMA_USED retains %d bytes after the drops, but does not grow across cycles\n", afterDrops-baseline)
+	}
+	return nil, close
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"modernc.org/libc"
+	"sqlite-repro/repro"
+)
+
+// dryRunConfig is what -dry-run prints: the Config and the values derived from
+// it that the run would otherwise only show as it goes.
+type dryRunConfig struct {
+	Config
+	SelectBound int
+	// PageCacheSlots and PageCacheSlotSize are those of -preallocate-bytes.
+	PageCacheSlots    int32 `json:",omitempty"`
+	PageCacheSlotSize int32 `json:",omitempty"`
+	SQLStatements     int   `json:",omitempty"`
+}
+
+// printDryRun writes cfg and what derives from it as indented JSON. It opens
+// no database, SQLite is only asked for its page cache header size.
+func printDryRun(w io.Writer, cfg *Config) error {
+	d := dryRunConfig{
+		Config:      *cfg,
+		SelectBound: cfg.SelectBound(),
+	}
+	if cfg.SQLFile != "" {
+		stmts, err := repro.LoadSQLFile(cfg.SQLFile)
+		if err != nil {
+			return err
+		}
+		d.SQLStatements = len(stmts)
+	}
+	if cfg.MaxRetries == 0 {
+		d.RetryOn = nil
+	}
+	if cfg.PreallocateBytes > 0 {
+		var err error
+		repro.WithTLS(func(tls *libc.TLS) {
+			d.PageCacheSlots, d.PageCacheSlotSize, err = pageCacheSlots(tls, int32(cfg.PreallocateBytes), int32(cfg.PageSize))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
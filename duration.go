@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/libc"
+	"sqlite-repro/repro"
+)
+
+// durationSample is one -duration reading of the registry and the global
+// status.
+type durationSample struct {
+	Time        time.Time        `json:"time"`
+	Connections int              `json:"connections"`
+	Goroutines  int              `json:"goroutines"`
+	DB          dbStatusJSON     `json:"db"`
+	Global      globalStatusJSON `json:"global"`
+}
+
+// startSampling runs sampleUntil on the registry's open connections in a
+// goroutine of its own and returns the function stopping it, which returns
+// the largest aggregated CACHE_USED of the samples.
+func startSampling(samples *[]durationSample, csv *csvSink, stream bool, cfg *Config) (stop func() int64) {
+	stopSampling := make(chan struct{})
+	sampling := sync.WaitGroup{}
+	sampling.Add(1)
+	go func() {
+		defer sampling.Done()
+		sampleUntil(stopSampling, cfg.SampleInterval, readLiveConns, samples, csv, stream, cfg)
+	}()
+	return func() int64 {
+		close(stopSampling)
+		sampling.Wait()
+		return peakCacheUsed(*samples)
+	}
+}
+
+// sampleUntil appends a durationSample to *samples every interval until stop is
+// closed. The handles are queried within the callback readConns passes the
+// connections to, so none of them is closed while it is being queried.
+//
+// If csv is not nil every sample is also appended to it, and with stream set
+// written to stdout as one line of JSON. The first write error is logged and
+// stops the writes, the samples are still collected.
+func sampleUntil(stop <-chan struct{}, interval time.Duration, readConns func(read func([]registeredConn)), samples *[]durationSample, csv *csvSink, stream bool, cfg *Config) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			var conns int
+			var stats []repro.OpStat
+			var errs []error
+			readConns(func(registered []registeredConn) {
+				conns = len(registered)
+				stats, errs = repro.CollectDBStatus(tls, handles(registered))
+			})
+			warnStatusErrors("duration", errs)
+			db := newDBStatusJSON(stats, conns)
+			db.GoHeap = repro.ReadGoHeap(cfg.GCBeforeSample)
+			if cfg.ReportAllocator {
+				if stat, err := readAllocatorStat(tls); err == nil {
+					db.Allocator = &stat
+					if stat.mismatch() {
+						warnAllocatorMismatch("duration: "+now.Format(time.RFC3339Nano), stat)
+					}
+				}
+			}
+			sample := durationSample{
+				Time:        now,
+				Connections: conns,
+				Goroutines:  runtime.NumGoroutine(),
+				DB:          db,
+				Global:      newGlobalStatusJSON(collectGlobalStatus(tls, "duration")),
+			}
+			*samples = append(*samples, sample)
+			if csv != nil {
+				if err := csv.write(sample); err != nil {
+					fmt.Fprintf(os.Stderr, "csv-out: %v, no more samples will be written\n", err)
+					csv = nil
+				}
+			}
+			if stream {
+				// os.Stdout is unbuffered, the encoder's single write
+				// of the line is all there is to flush.
+				if err := json.NewEncoder(os.Stdout).Encode(sample); err != nil {
+					fmt.Fprintf(os.Stderr, "stream-json: %v, no more samples will be written\n", err)
+					stream = false
+				}
+			}
+		}
+	}
+}
+
+// printDurationSamples writes the samples as one JSON array if asJSON is set,
+// or one line per sample otherwise.
+func printDurationSamples(w io.Writer, samples []durationSample, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(samples)
+	}
+	for _, s := range samples {
+		var b strings.Builder
+		fmt.Fprintf(&b, "duration: time=%s conns=%d goroutines=%d", s.Time.Format(time.RFC3339Nano), s.Connections, s.Goroutines)
+		fmt.Fprintf(&b, " MEMORY_USED=%d", s.Global.Current["MEMORY_USED"])
+		fmt.Fprintf(&b, " CACHE_USED=%d LOOKASIDE_USED=%d SCHEMA_USED=%d STMT_USED=%d CACHE_SPILL=%d",
+			s.DB.CacheUsed, s.DB.LookasideUsed, s.DB.SchemaUsed, s.DB.StmtUsed, s.DB.CacheSpill)
+		fmt.Fprintf(&b, " %s", s.DB.GoHeap)
+		if a := s.DB.Allocator; a != nil {
+			fmt.Fprintf(&b, " allocator_bytes=%d allocator_gap=%d", a.Bytes, a.Gap)
+		}
+		if _, err := fmt.Fprintln(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
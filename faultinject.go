@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"unsafe"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+var (
+	// faultNextMem holds the allocator installed before the fault injector,
+	// the counting one with -alloc-phases, which every call that isn't
+	// failed is delegated to.
+	faultNextMem sqlite3.Tsqlite3_mem_methods
+
+	// faultsArmed is set while the workload runs, SQLite's initialization
+	// and the databases' closing are spared.
+	faultsArmed atomic.Bool
+
+	// faultAllocs counts the mallocs and reallocs made while armed and
+	// injectedFaults the ones failed, every faultRate'th of them.
+	faultAllocs, injectedFaults atomic.Int64
+	faultRate                   int64
+)
+
+// installFaultInjector wraps SQLite's allocator so that, once faultsArmed is
+// set, every rate'th malloc or realloc fails as if memory ran
+// out, SQLite then failing the call that needed it with SQLITE_NOMEM. Which
+// allocations fail only depends on the order they are made in, which a
+// single database with one reader makes repeatable. It must run before SQLite
+// is initialized, after installCountingAllocator if that runs, so that the
+// failed allocations aren't counted.
+func installFaultInjector(rate int) error {
+	faultRate = int64(rate)
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	methods := libc.Xmalloc(tls, types.Size_t(unsafe.Sizeof(sqlite3.Tsqlite3_mem_methods{})))
+	if methods == 0 {
+		return fmt.Errorf("sqlite: install fault injector: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, methods)
+
+	list := libc.NewVaList(methods)
+	if list == 0 {
+		return fmt.Errorf("sqlite: install fault injector: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_GETMALLOC, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to get SQLITE_CONFIG_GETMALLOC: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	faultNextMem = *(*sqlite3.Tsqlite3_mem_methods)(unsafe.Pointer(methods))
+
+	failing := faultNextMem
+	failing.FxMalloc = cFuncPointer(faultingMalloc)
+	failing.FxRealloc = cFuncPointer(faultingRealloc)
+	*(*sqlite3.Tsqlite3_mem_methods)(unsafe.Pointer(methods)) = failing
+
+	// SQLite copies the struct, so methods can be freed on return.
+	list2 := libc.NewVaList(methods)
+	if list2 == 0 {
+		return fmt.Errorf("sqlite: install fault injector: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list2)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_MALLOC, list2); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_MALLOC: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
+
+func faultingMalloc(tls *libc.TLS, n int32) uintptr {
+	if injectFault(tls) {
+		return 0
+	}
+	return (*(*func(*libc.TLS, int32) uintptr)(unsafe.Pointer(&struct{ uintptr }{faultNextMem.FxMalloc})))(tls, n)
+}
+
+func faultingRealloc(tls *libc.TLS, prior uintptr, n int32) uintptr {
+	// A failed realloc leaves prior allocated, SQLite still frees it.
+	if injectFault(tls) {
+		return 0
+	}
+	return (*(*func(*libc.TLS, uintptr, int32) uintptr)(unsafe.Pointer(&struct{ uintptr }{faultNextMem.FxRealloc})))(tls, prior, n)
+}
+
+// injectFault reports whether the allocation tls is making is to fail.
+func injectFault(tls *libc.TLS) bool {
+	if !faultsArmed.Load() {
+		return false
+	}
+	if repro.FaultExempt(tls) {
+		return false
+	}
+	if faultAllocs.Add(1)%faultRate != 0 {
+		return false
+	}
+	injectedFaults.Add(1)
+	return true
+}
+
+// printFaults writes how many allocations the fault injector failed and how
+// many SQLITE_NOMEM errors the workload tolerated.
+func printFaults(w io.Writer, cfg *Config) {
+	fmt.Fprintf(w, "fault-inject: %d of %d allocations failed (-fault-inject-rate %d), SQLITE_NOMEM errors tolerated=%d\n",
+		injectedFaults.Load(), faultAllocs.Load(), cfg.FaultInjectRate, repro.NomemErrors())
+}
@@ -0,0 +1,16 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+const tmpfsMagic = 0x01021994
+
+// isTmpfs reports whether dir is on a tmpfs filesystem.
+func isTmpfs(dir string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, err
+	}
+	return st.Type == tmpfsMagic, nil
+}
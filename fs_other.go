@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// isTmpfs reports whether dir is on a tmpfs filesystem.
+func isTmpfs(dir string) (bool, error) {
+	return false, errors.New("filesystem type detection is only supported on linux")
+}
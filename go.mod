@@ -3,6 +3,7 @@ module sqlite-repro
 go 1.23.3
 
 require (
+	golang.org/x/sys v0.30.0
 	modernc.org/libc v1.61.14-0.20250318182109-5257f95acdf2
 	modernc.org/sqlite v1.36.1
 )
@@ -14,7 +15,6 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/exp v0.0.0-20230315142452-642cacee5cc0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.8.2 // indirect
 )
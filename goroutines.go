@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// goroutineLeakMargin is how many goroutines above the baseline the run may be
+// left with once everything is closed before checkGoroutines reports a leak,
+// the runtime and net/http start a few of their own on demand.
+const goroutineLeakMargin = 5
+
+// goroutineSettle bounds how long checkGoroutines waits for goroutines on
+// their way out, such as a closed sql.DB's connection opener, to exit.
+const goroutineSettle = time.Second
+
+// printGoroutines prints the number of goroutines at label next to baseline,
+// the number the run started with.
+func printGoroutines(label string, baseline int) {
+	fmt.Printf("goroutines: %s=%d baseline=%d\n", label, runtime.NumGoroutine(), baseline)
+}
+
+// checkGoroutines is printGoroutines for a point where every goroutine the run
+// started should be gone. If more than goroutineLeakMargin are left above
+// baseline it warns and dumps the stacks of all goroutines to stderr.
+func checkGoroutines(label string, baseline int) {
+	deadline := time.Now().Add(goroutineSettle)
+	for runtime.NumGoroutine() > baseline+goroutineLeakMargin && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	n := runtime.NumGoroutine()
+	fmt.Printf("goroutines: %s=%d baseline=%d\n", label, n, baseline)
+	if n <= baseline+goroutineLeakMargin {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: goroutines: %d goroutines %s, %d more than at the start, dumping them\n", n, label, n-baseline)
+	pprof.Lookup("goroutine").WriteTo(os.Stderr, 1)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+
+	"modernc.org/libc"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// checkHandleLayout opens a throwaway in-memory connection, takes its handle
+// with repro.ConnDBHandle from a connection hook and checks that SQLite agrees
+// it is a read-write connection with a main database. A handle read from the
+// wrong field would be garbage every db_status call then dereferences, rather
+// than an error.
+func checkHandleLayout(tls *libc.TLS) error {
+	var handle uintptr
+	var hookErr error
+	d := &sqlite.Driver{}
+	d.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+		handle, hookErr = repro.ConnDBHandle(conn)
+		return nil
+	})
+	conn, err := d.Open(":memory:")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if hookErr != nil {
+		return hookErr
+	}
+
+	name, err := libc.CString("main")
+	if err != nil {
+		return err
+	}
+	defer libc.Xfree(tls, name)
+	// sqlite3_db_readonly is 0 for a read-write database and -1 for one
+	// the connection doesn't have.
+	if rc := sqlite3.Xsqlite3_db_readonly(tls, handle, name); rc != 0 {
+		return fmt.Errorf("sqlite3_db_readonly(%#x, main) = %d, want 0", handle, rc)
+	}
+	return nil
+}
+
+// driverVersion returns the modernc.org/sqlite version the binary is built
+// with, as recorded in its build info.
+func driverVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "modernc.org/sqlite" {
+				return dep.Version
+			}
+		}
+	}
+	return "(unknown version)"
+}
+
+// connClosed reports whether conn has been closed, which the driver records by
+// zeroing the db field under the mutex its conn struct embeds.
+func connClosed(conn sqlite.ExecQuerierContext) bool {
+	if l, ok := conn.(sync.Locker); ok {
+		l.Lock()
+		defer l.Unlock()
+	}
+	_, err := repro.ConnDBHandle(conn)
+	return err != nil
+}
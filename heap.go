@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// configureHeap confines SQLite to a sizeBytes heap allocated up front, with
+// SQLITE_CONFIG_HEAP and allocations rounded up to at least minAlloc bytes, 0
+// letting SQLite choose. SQLite only has the MEMSYS5 allocator serving it when
+// built with SQLITE_ENABLE_MEMSYS5, which modernc.org/sqlite isn't by default.
+// It must run before SQLite is initialized.
+func configureHeap(tls *libc.TLS, sizeBytes, minAlloc int32) error {
+	memsys5, err := compileOptionUsed(tls, "ENABLE_MEMSYS5")
+	if err != nil {
+		return err
+	}
+	memsys3, err := compileOptionUsed(tls, "ENABLE_MEMSYS3")
+	if err != nil {
+		return err
+	}
+	if !memsys5 && !memsys3 {
+		return fmt.Errorf("sqlite: SQLITE_CONFIG_HEAP needs SQLite built with SQLITE_ENABLE_MEMSYS5, this build isn't, see its compile options")
+	}
+
+	buf := libc.Xmalloc(tls, types.Size_t(sizeBytes))
+	if buf == 0 {
+		return fmt.Errorf("sqlite: configure heap: cannot allocate %d bytes", sizeBytes)
+	}
+	list := libc.NewVaList(buf, sizeBytes, minAlloc)
+	if list == 0 {
+		libc.Xfree(tls, buf)
+		return fmt.Errorf("sqlite: configure heap: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	// On success SQLite owns buf for the life of the process.
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_HEAP, list); rc != sqlite3.SQLITE_OK {
+		libc.Xfree(tls, buf)
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_HEAP: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
+
+// compileOptionUsed reports whether SQLite was built with the named option,
+// given without its SQLITE_ prefix.
+func compileOptionUsed(tls *libc.TLS, name string) (bool, error) {
+	p, err := libc.CString(name)
+	if err != nil {
+		return false, err
+	}
+	defer libc.Xfree(tls, p)
+	return sqlite3.Xsqlite3_compileoption_used(tls, p) != 0, nil
+}
+
+// checkHeapCeiling returns an error if MEMORY_USED ever went above the
+// sizeBytes heap of configureHeap, which would mean allocations got around it.
+func checkHeapCeiling(tls *libc.TLS, sizeBytes int64) error {
+	memUsed, memUsedHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	fmt.Printf("heap-bytes: heap=%d MEMORY_USED=%d highwater=%d SQLITE_NOMEM errors=%d\n",
+		sizeBytes, memUsed, memUsedHighwater, repro.NomemErrors())
+	if memUsedHighwater > sizeBytes {
+		return fmt.Errorf("heap-bytes: MEMORY_USED highwater %d exceeds the %d byte heap", memUsedHighwater, sizeBytes)
+	}
+	return nil
+}
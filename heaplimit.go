@@ -0,0 +1,18 @@
+package main
+
+import (
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// setHeapLimits sets sqlite3_soft_heap_limit64 and sqlite3_hard_heap_limit64
+// to soft and hard bytes, leaving either alone when it is 0. Both initialize
+// SQLite, so they must run after everything that configures it.
+func setHeapLimits(tls *libc.TLS, soft, hard int64) {
+	if soft > 0 {
+		sqlite3.Xsqlite3_soft_heap_limit64(tls, soft)
+	}
+	if hard > 0 {
+		sqlite3.Xsqlite3_hard_heap_limit64(tls, hard)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// connCost is the average cost of opening and closing one connection.
+type connCost struct {
+	duration time.Duration
+	allocs   float64
+	bytes    float64
+}
+
+// measureHookCost opens and closes n connections to one database through a
+// driver without a connection hook and then through hooked, and prints the
+// difference per connection. Only Go heap allocations are counted, whatever
+// the hook makes SQLite allocate shows up in MEMORY_USED instead.
+//
+// Every hooked connection is registered, so the registry is left holding
+// closed handles and the workload must not run afterwards.
+func measureHookCost(hooked driver.Driver, n int, cfg *Config) error {
+	dir, err := os.MkdirTemp(cfg.TempDir, "test-*")
+	if err != nil {
+		return err
+	}
+
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "db")
+	// Create the file and warm up both drivers so neither pays for it.
+	for _, d := range []driver.Driver{&sqlite.Driver{}, hooked} {
+		if _, err := openClose(d, fn, 10); err != nil {
+			return err
+		}
+	}
+
+	plain, err := openClose(&sqlite.Driver{}, fn, n)
+	if err != nil {
+		return err
+	}
+	withHook, err := openClose(hooked, fn, n)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("hook-cost: conns=%d without_hook=%v/conn %.1f allocs/conn %.0f B/conn\n", n, plain.duration, plain.allocs, plain.bytes)
+	fmt.Printf("hook-cost: conns=%d with_hook=%v/conn %.1f allocs/conn %.0f B/conn\n", n, withHook.duration, withHook.allocs, withHook.bytes)
+	fmt.Printf("hook-cost: hook=%v/conn %.1f allocs/conn %.0f B/conn (%.1f%% of the connection time)\n",
+		withHook.duration-plain.duration, withHook.allocs-plain.allocs, withHook.bytes-plain.bytes,
+		100*float64(withHook.duration-plain.duration)/float64(max(plain.duration, 1)))
+	return nil
+}
+
+// openClose opens and immediately closes n connections to fn through d and
+// returns the average cost of one.
+func openClose(d driver.Driver, fn string, n int) (connCost, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		conn, err := d.Open(fn)
+		if err != nil {
+			return connCost{}, err
+		}
+		if err = conn.Close(); err != nil {
+			return connCost{}, err
+		}
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return connCost{
+		duration: elapsed / time.Duration(n),
+		allocs:   float64(after.Mallocs-before.Mallocs) / float64(n),
+		bytes:    float64(after.TotalAlloc-before.TotalAlloc) / float64(n),
+	}, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// libcAllocators lists the allocators modernc.org/libc can be built with and
+// the build tags selecting them. The choice is made at compile time, the
+// libcalloc_*.go files record which one this binary got as libcAllocator.
+var libcAllocators = []struct{ name, tags string }{
+	{"memory", "default, modernc.org/memory"},
+	{"membrk", "-tags libc.membrk"},
+	{"memgrind", "-tags libc.memgrind"},
+}
+
+// printLibcAllocator reports the allocator this binary was built with, the
+// alternatives and whether the memory.allocator expvar is published.
+func printLibcAllocator(w io.Writer) {
+	var available []string
+	for _, a := range libcAllocators {
+		available = append(available, fmt.Sprintf("%s (%s)", a.name, a.tags))
+	}
+	expvarState := "published"
+	if expvar.Get("memory.allocator") == nil {
+		expvarState = "not published, build with -tags libc.memexpvar"
+	}
+	fmt.Fprintf(w, "libc allocator: %s; available: %s; memory.allocator expvar: %s\n",
+		libcAllocator, strings.Join(available, ", "), expvarState)
+}
+
+// checkLibcAllocator returns an error telling how to rebuild if want names a
+// different allocator than the one compiled in.
+func checkLibcAllocator(want string) error {
+	if want == "" || want == libcAllocator {
+		return nil
+	}
+	for _, a := range libcAllocators {
+		if a.name == want {
+			return fmt.Errorf("the libc allocator is chosen at build time: this binary uses %s, rebuild with %s to use %s", libcAllocator, a.tags, want)
+		}
+	}
+	return fmt.Errorf("unknown libc allocator %q", want)
+}
@@ -0,0 +1,5 @@
+//go:build libc.membrk && !libc.memgrind
+
+package main
+
+const libcAllocator = "membrk"
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
-	"expvar"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"maps"
+	"math"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"reflect"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"modernc.org/libc"
@@ -20,272 +30,1865 @@ import (
 	"modernc.org/sqlite"
 	_ "modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
 )
 
-func runPPROF() {
-	http.ListenAndServe("localhost:6060", nil)
+// newFlagSet returns the command's flags, bound into cfg. The workload's flags
+// default to repro.DefaultConfig.
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	def := repro.DefaultConfig()
+	fs.BoolVar(&cfg.Summary, "summary", false, "print a compact single-line summary instead of the full report")
+	fs.IntVar(&cfg.WALShm, "wal-shm", def.WALShm, "run in WAL mode with this wal_autocheckpoint in pages, which bounds how large the WAL and with it the -shm WAL index grow, and report every connection's CACHE_USED next to the mapped -shm and the -wal and -shm sizes; run at several values to compare WAL sizes; 0 keeps -journal-mode")
+
+	fs.StringVar(&cfg.JournalMode, "journal-mode", def.JournalMode, "journal_mode of the databases: delete, wal, memory or off")
+	fs.IntVar(&cfg.WALAutocheckpoint, "wal-autocheckpoint", def.WALAutocheckpoint, "wal_autocheckpoint in pages under -journal-mode wal")
+
+	fs.DurationVar(&cfg.SampleInterval, "sample-interval", 100*time.Millisecond, "how often the sampler reads db_status")
+	fs.DurationVar(&cfg.ResetInterval, "reset-interval", 0, "run the sampler and reset highwater marks at this interval, printing per-window peaks; 0 disables the sampler")
+
+	fs.BoolVar(&cfg.AlignSamples, "align-samples", false, "take samples on wall-clock multiples of -sample-interval and print window bounds as wall-clock times")
+
+	fs.BoolVar(&cfg.CheckROWrites, "check-ro-writes", false, "fail if any read-only connection wrote to its page cache during the read phase")
+
+	fs.BoolVar(&cfg.VerifyMmap, "verify-mmap", def.VerifyMmap, "open the read-only connections with a large mmap_size and fail if their CACHE_USED exceeds -verify-mmap-max-cache")
+	fs.IntVar(&cfg.VerifyMmapMaxCache, "verify-mmap-max-cache", 1<<20, "largest CACHE_USED in bytes a read-only connection may hold under -verify-mmap")
+
+	fs.BoolVar(&cfg.VMStats, "vm-stats", false, "count the VDBE instructions executed by every connection and report the total")
+
+	fs.BoolVar(&cfg.FailOnTmpfs, "fail-on-tmpfs", false, "abort instead of warning when the databases would be created on tmpfs")
+
+	// -race-check is meant to be run as `go run -race . -race-check`.
+	// Without -race it is just a heavier run, it only makes the harness's
+	// own bookkeeping more likely to trip the race detector.
+	fs.BoolVar(&cfg.RaceCheck, "race-check", def.RaceCheck, "stress the harness bookkeeping for the race detector: 2x databases and readers, readers share the writer db, concurrent registry reads")
+
+	fs.IntVar(&cfg.MaxTrackedConns, "max-tracked-conns", 0, "stop registering connections for stats collection after this many; 0 tracks all")
+
+	fs.StringVar(&cfg.ReportPath, "report", "", "write the run, its config, timings, aggregated db_status and global status, as a JSON report to this file")
+	fs.BoolVar(&cfg.Diff, "diff", false, "compare two JSON reports given as arguments (-diff a.json b.json) instead of running the workload")
+	fs.Float64Var(&cfg.DiffThreshold, "diff-threshold", 0, "with -diff or -compare, exit non-zero if any op grew by more than this percentage")
+	fs.StringVar(&cfg.ComparePath, "compare", "", "compare this run's stats with a JSON report written by -report and print the per-op change")
+
+	fs.IntVar(&cfg.InsertRate, "rate", def.InsertRate, "limit inserts to this many rows per second per database; 0 is unlimited")
+
+	fs.BoolVar(&cfg.TraceSQL, "trace-sql", false, "log every SQL statement executed by every connection to stderr (verbose)")
+
+	fs.BoolVar(&cfg.ShareDir, "share-dir", false, "create all databases in one temp directory, removed after the handles are closed")
+
+	fs.BoolVar(&cfg.AllocPhases, "alloc-phases", def.AllocPhases, "count SQLite allocations made while preparing vs executing the inserts")
+
+	fs.BoolVar(&cfg.Minimal, "minimal", false, "run one database with one writer and one reader, sequentially and with a fixed seed, and print the MEMORY_USED delta")
+
+	fs.BoolVar(&cfg.CheckAggregate, "check-aggregate", false, "fail if the aggregated stats differ from the sum of the per-connection stats")
+
+	fs.IntVar(&cfg.DDLChurnCycles, "ddl-churn", 0, "after the workload, create and drop tables this many times on a dedicated connection and report its SCHEMA_USED")
+
+	fs.StringVar(&cfg.Allocator, "allocator", "", "require this modernc.org/libc allocator (memory, membrk or memgrind); the active one is always reported on stderr")
+
+	fs.IntVar(&cfg.PinCPUs, "pin-cpus", def.PinCPUs, "lock writer and reader goroutines to OS threads pinned round robin to the first N logical CPUs (linux only) and report memory and throughput; 0 leaves scheduling to Go")
+
+	fs.StringVar(&cfg.StatsdAddr, "statsd", "", "send the sampled memory gauges to this StatsD host:port over UDP every -sample-interval")
+
+	fs.IntVar(&cfg.HookCost, "hook-cost", 0, "instead of the workload, open and close this many connections with and without the connection hook and report the hook's cost per connection")
+
+	fs.BoolVar(&cfg.ManualTx, "manual-tx", def.ManualTx, "insert on one pinned *sql.Conn with BEGIN/COMMIT issued through Exec instead of db.Begin; compare STMT_USED and CACHE_USED against a run without it using -report and -diff")
+
+	fs.BoolVar(&cfg.CheckBaseline, "check-baseline", false, "fail if MEMORY_USED does not return to within -baseline-slack of its post-initialization value once every handle is closed, or of its value before the iteration after every -repeat, -short-lived, -duration or -cache-size-sweep iteration")
+	fs.Int64Var(&cfg.BaselineSlack, "baseline-slack", 0, "bytes of residual MEMORY_USED tolerated by -check-baseline")
+
+	fs.IntVar(&cfg.BalloonBytes, "balloon", 0, "allocate and hold a Go heap balloon of this many bytes during the workload to put the process under memory pressure")
+
+	fs.IntVar(&cfg.OpenCursors, "open-cursors", def.OpenCursors, "after its selects, have every reader hold this many cursors open mid-iteration on one connection and report CACHE_USED with none and all of them open")
+
+	fs.BoolVar(&cfg.AllocatorGap, "allocator-gap", false, "report the libc allocator's sampled peak minus SQLite's MEMORY_USED highwater, memory the allocator holds that SQLite doesn't account for")
+
+	fs.IntVar(&cfg.Repeat, "repeat", 0, "run the workload this many times in one process, closing everything after each run, and report a table of every run's MEMORY_USED residual and highwater, with their trends, instead of the usual report")
+
+	fs.BoolVar(&cfg.ClassifyRetained, "classify-retained", false, "after closing every handle, release all memory SQLite will give back and report MEMORY_USED split into reclaimable and retained")
+
+	fs.IntVar(&cfg.ShortLived, "short-lived", 0, "instead of the workload, create, fill, read and close this many small databases one after another and report the MEMORY_USED trend across them")
+
+	fs.StringVar(&cfg.ChromeTracePath, "chrome-trace", "", "write the workload phases and the sampler's memory readings to this file in the Chrome Trace Event format")
+
+	fs.BoolVar(&cfg.BusyHandler, "busy-handler", false, "install a busy handler on every connection that counts its invocations and yields, and report the total")
+
+	fs.StringVar(&cfg.OpenPath, "open", "", "instead of the workload, run continuous selects on this existing database from read-only connections and report their CACHE_USED")
+	fs.StringVar(&cfg.SelectSQL, "select-sql", "select * from t", "query the -open readers run")
+
+	fs.BoolVar(&cfg.VerifyPrealloc, "verify-prealloc", false, "with -preallocate-bytes, count page-sized SQLite mallocs during the workload and fail if any happened")
+
+	fs.IntVar(&cfg.Inserts, "inserts", def.Inserts, "rows inserted into every database")
+	fs.IntVar(&cfg.CommitEvery, "commit-every", def.CommitEvery, "rows per insert transaction; 0 inserts all of -inserts in one transaction, whose cache growth the sampler shows")
+	fs.IntVar(&cfg.MinStrSize, "min-str", def.MinStrSize, "shortest random string inserted, in bytes")
+	fs.IntVar(&cfg.MaxStrSize, "max-str", def.MaxStrSize, "longest random string inserted, in bytes (exclusive)")
+	fs.IntVar(&cfg.ParallelSelects, "parallel-selects", def.ParallelSelects, "read-only connections reading every database concurrently")
+	fs.IntVar(&cfg.DBCount, "db-count", def.DBCount, "databases created and tested")
+	fs.IntVar(&cfg.DBWorkers, "db-workers", def.DBWorkers, "databases created and tested concurrently, one worker goroutine each; 0 runs all of -db-count at once")
+	fs.DurationVar(&cfg.Duration, "duration", 0, "keep creating, testing and closing databases until this much time has passed, sampling status every -sample-interval, then print the samples")
+	fs.Int64Var(&cfg.Seed, "seed", def.Seed, "seed of the inserted data, database i uses seed+i so fixed parameters give byte-identical databases; 0 seeds from the clock")
+	fs.StringVar(&cfg.PprofAddr, "pprof-addr", "localhost:6060", "address net/http/pprof, /sqlite/status and /metrics listen on; empty disables them")
+	fs.BoolVar(&cfg.PerConn, "per-conn", false, "also print every connection's db_status current and highwater, in the order the connections were opened, or by -sort-conns")
+	fs.StringVar(&cfg.OutputFormat, "format", "text", "format of the aggregated db_status: text, json or benchstat, which adds a line of the run's MEMORY_USED highwater, per 1000 rows inserted too, for benchstat to compare")
+	fs.StringVar(&cfg.PageCacheBacking, "pagecache-backing", "libc", "where the -preallocate-bytes arena comes from: libc, libc.Xmalloc as SQLite's own allocations, or go, a pinned Go []byte that SQLite's and libc's allocator counters leave out and the Go heap counts")
+	fs.IntVar(&cfg.PreallocateBytes, "preallocate-bytes", 0, "hand SQLite a page cache arena of this many bytes with SQLITE_CONFIG_PAGECACHE; 0 leaves page allocation to the heap")
+	fs.Int64Var(&cfg.SoftHeapLimit, "soft-heap-limit", 0, "set sqlite3_soft_heap_limit64 to this many bytes; 0 leaves it unset")
+	fs.Int64Var(&cfg.HardHeapLimit, "hard-heap-limit", 0, "set sqlite3_hard_heap_limit64 to this many bytes and count the SQLITE_NOMEM errors it causes instead of failing; 0 leaves it unset")
+	fs.IntVar(&cfg.LookasideSize, "lookaside-slot-size", 1200, "size of a lookaside slot in bytes with -lookaside-count")
+	fs.IntVar(&cfg.LookasideCount, "lookaside-count", -1, "lookaside slots per connection, set with SQLITE_CONFIG_LOOKASIDE; 0 disables lookaside, -1 keeps SQLite's default")
+	fs.IntVar(&cfg.DBLookasideSize, "conn-lookaside-slot-size", 1200, "size of a lookaside slot in bytes with -conn-lookaside-count")
+	fs.IntVar(&cfg.DBLookasideCount, "conn-lookaside-count", -1, "lookaside slots of every connection, set on it with SQLITE_DBCONFIG_LOOKASIDE as it opens and confirmed by LOOKASIDE_USED after a probe statement; 0 disables lookaside, -1 keeps the -lookaside-count one")
+	fs.IntVar(&cfg.BlobSize, "blob-size", def.BlobSize, "add a blob column and insert a random blob of this many bytes per row; 0 keeps the two-column schema")
+	fs.BoolVar(&cfg.AllocHistogram, "alloc-histogram", false, "count SQLite's mallocs, reallocs and frees by power of two size through a wrapping allocator and print the histogram at shutdown")
+	fs.BoolVar(&cfg.GCBeforeSample, "gc-before-sample", def.GCBeforeSample, "run a garbage collection before every read of the Go heap printed next to the SQLite numbers, so it only counts live memory")
+	fs.Var((*stringList)(&cfg.DSNParams), "dsn-param", "key=value appended to the query string of every read-write and read-only connection's DSN, e.g. _pragma=cache_size(-2000); repeatable")
+	fs.DurationVar(&cfg.BusyTimeout, "busy-timeout", def.BusyTimeout, "set busy_timeout on the read-write and read-only connections; SQLITE_BUSY and SQLITE_LOCKED errors are counted instead of failing the run either way")
+	fs.DurationVar(&cfg.ROHold, "ro-hold", def.ROHold, "keep every database's read-only connections re-running the selects for this long while another goroutine keeps inserting")
+	fs.IntVar(&cfg.AttachCount, "attach-count", def.AttachCount, "attach this many more databases to every database's writer connection and insert a tenth of -inserts rows into the first")
+	fs.BoolVar(&cfg.VacuumAfter, "vacuum-after", def.VacuumAfter, "vacuum every database after its inserts and print CACHE_USED and the file size before and after")
+	fs.Float64Var(&cfg.SelectSelectivity, "select-selectivity", def.SelectSelectivity, "fraction of the rows, in (0, 1], the selects' WHERE i < ? bound matches; 1 is a full scan")
+	fs.IntVar(&cfg.SelectIterations, "select-iterations", def.SelectIterations, "times every read-only connection runs the selects; with this or -select-selectivity set, every reader's rows and CACHE_USED are printed")
+	fs.IntVar(&cfg.Tables, "tables", def.Tables, "tables of the same shape in every database, t0 to tN-1, each with its own insert statement; the inserts go round robin and every reader queries one at random; 1 keeps the single table t")
+	fs.BoolVar(&cfg.Analyze, "analyze", def.Analyze, "run ANALYZE on every database after its inserts and print SCHEMA_USED before and once the statistics are loaded, and whether the selects' plan then uses the -create-index index")
+	fs.BoolVar(&cfg.CreateIndex, "create-index", def.CreateIndex, "create an index on t(i), which the selects' WHERE i < ? then uses, and print SCHEMA_USED before and after")
+	fs.IntVar(&cfg.PageSize, "page-size", def.PageSize, "page size of every database, set with pragma page_size before it is created; -preallocate-bytes sizes its slots for it")
+	fs.StringVar(&cfg.CSVOut, "csv-out", "", "with -duration, also append every sample to this CSV file as it is taken: timestamp, MEMORY_USED current and highwater, CACHE_USED, LOOKASIDE_USED and Go HeapAlloc")
+	fs.BoolVar(&cfg.InMemory, "in-memory", def.InMemory, "create every database in memory with a shared cache the read-only connections also open, instead of as a file in a temp directory; the journal mode becomes memory")
+	fs.IntVar(&cfg.HeapBytes, "heap-bytes", 0, "confine SQLite to a preallocated heap of this many bytes with SQLITE_CONFIG_HEAP, counting the SQLITE_NOMEM errors it causes, and fail if MEMORY_USED goes above it; needs SQLite built with SQLITE_ENABLE_MEMSYS5; 0 leaves allocation to the heap")
+	fs.IntVar(&cfg.ScratchBytes, "scratch-bytes", def.ScratchBytes, "hand SQLite a scratch memory buffer of this many bytes with SQLITE_CONFIG_SCRATCH, sort the selects with ORDER BY so they need it and report SCRATCH_USED; only SQLite before 3.22.0 has scratch memory; 0 leaves it unset")
+	fs.IntVar(&cfg.HeapMinAlloc, "heap-min-alloc", 0, "smallest allocation in bytes from the -heap-bytes heap, a power of two; 0 lets SQLite choose")
+	fs.BoolVar(&cfg.VerifyBlobFree, "verify-blob-free", def.VerifyBlobFree, "with -blob-size, have every reader scan the blobs once more on one connection and fail if its STMT_USED is not back to its pre-query value once the rows are closed; prints STMT_USED and CACHE_USED before, with the rows scanned and after closing")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", def.MaxRetries, "retry a failed insert or select query up to this many times with exponential backoff when its result code is one of -retry-on, and report the retries; 0 never retries")
+	fs.Var((*retryCodes)(&cfg.RetryOn), "retry-on", "comma-separated result codes -max-retries retries: BUSY, LOCKED, PROTOCOL, NOMEM or IOERR")
+	fs.BoolVar(&cfg.KeepTemp, "keep-temp", def.KeepTemp, "leave the databases on disk for inspection and print each one's path as it is created; at most the first 100 are kept")
+	fs.IntVar(&cfg.WarmupDBs, "warmup-dbs", 0, "before the measured run, create, fill, read and close this many small throwaway databases so the allocator and page cache reach steady state, then reset the global highwaters")
+	fs.StringVar(&cfg.ErrorPolicy, "error-policy", "fail-fast", "what a database's failure does: fail-fast stops the run once the databases in flight are done, collect-all records every database's outcome and error and prints them as a table at the end without stopping")
+	fs.StringVar(&cfg.SQLFile, "sql-file", def.SQLFile, "run the semicolon-separated statements of this file on every database instead of creating table t and inserting into it; a line starting with --? after a statement holds one row of its comma-separated ? arguments and the statement runs once per row; no read-only connections are opened")
+	fs.IntVar(&cfg.MaxOpenConns, "max-open-conns", def.MaxOpenConns, "cap every database's read-write pool and each of its read-only pools at this many open connections; 0 leaves them unlimited")
+	fs.IntVar(&cfg.MaxIdleConns, "max-idle-conns", def.MaxIdleConns, "idle connections every pool keeps, closing the rest once they are returned; -1 keeps database/sql's default of 2")
+	fs.DurationVar(&cfg.ConnMaxLifetime, "conn-max-lifetime", def.ConnMaxLifetime, "close pooled connections this long after they were opened; 0 keeps them")
+	fs.BoolVar(&cfg.BackgroundWrites, "background-writes", def.BackgroundWrites, "while the readers run, keep inserting small transactions on every database's read-write connection and print the largest CACHE_USED it reached while writing and its CACHE_USED at rest once the writes stop")
+	fs.StringVar(&cfg.CPUProfile, "cpuprofile", "", "write a CPU profile of the run to this file")
+	fs.StringVar(&cfg.MemProfile, "memprofile", "", "write a Go heap profile to this file at shutdown, after the final status print, interrupted or not")
+	fs.BoolVar(&cfg.IntegrityCheck, "integrity-check", def.IntegrityCheck, "once every database's inserts and selects are done, run pragma integrity_check on it, failing unless it answers ok, and print CACHE_USED before and after the check")
+	fs.StringVar(&cfg.TempDir, "temp-dir", def.TempDir, "create the databases' temp directories in this directory instead of the system temp directory, e.g. to keep them off a tmpfs")
+	fs.BoolVar(&cfg.SharedCache, "shared-cache", def.SharedCache, "open every database's connections with cache=shared, so the read-only connections share the read-write connection's page cache, check that they do and print the aggregate's CACHE_USED_SHARED, which counts each shared cache once; -in-memory always shares the cache, which is what lets its connections reach the same database")
+	fs.StringVar(&cfg.IntDistribution, "int-distribution", def.IntDistribution, "values of the int column i: sequential numbers the rows 0 to -inserts-1, random draws them uniformly from that range and zipf with a zipf distribution over it, both from the -seed data; the selects' WHERE i < ? then matches more or less than -select-selectivity of the rows")
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "print the resolved configuration and the values derived from it, such as the -preallocate-bytes page cache slots, as JSON and exit without opening a database")
+	fs.IntVar(&cfg.MaxPageCount, "max-page-count", def.MaxPageCount, "cap every database at this many pages with pragma max_page_count on its read-write connections; the inserts stop at the SQLITE_FULL this causes and the rows inserted, the page count and CACHE_USED at the ceiling are printed; 0 leaves the cap at SQLite's default")
+	fs.Int64Var(&cfg.MmapSize, "mmap-size", def.MmapSize, "pragma mmap_size in bytes of every connection; once the workload is done the mmap_size SQLite actually uses, which it clamps to SQLITE_MAX_MMAP_SIZE, is printed with CACHE_USED and the process RSS, and every -reset-interval sampler window then carries the RSS too; 0 leaves mmap_size at SQLite's default")
+	fs.StringVar(&cfg.CheckpointMode, "checkpoint-mode", def.CheckpointMode, "once a WAL database's inserts are done, run pragma wal_checkpoint with this mode, passive, full, restart or truncate, and print the frames it answers with and CACHE_USED and MEMORY_USED before and after it, then the file sizes; ignored with a warning outside WAL mode")
+	fs.StringVar(&cfg.TempStore, "temp-store", def.TempStore, "pragma temp_store of every connection, default, file or memory; the selects then sort with ORDER BY, and once a database's inserts are done a sorted temp copy of its first table is made and dropped, printing MEMORY_USED and CACHE_USED around it; empty leaves temp_store unset")
+	fs.BoolVar(&cfg.StreamJSON, "stream-json", false, "with -duration, write every sample to stdout as one line of JSON as soon as it is taken, instead of listing the samples once the run is over")
+	fs.Float64Var(&cfg.RollbackRatio, "rollback-ratio", def.RollbackRatio, "fraction of the insert transactions, 0 to 1, rolled back instead of committed, their rows then left out of the row count check; the writer's CACHE_USED read right after every commit and rollback is summed up for each")
+	fs.IntVar(&cfg.Writers, "writers", def.Writers, "goroutines inserting into every database at the same time, each on its own connection with its share of -inserts; each writer's rows and throughput are printed, the lock contention shows in the SQLITE_BUSY and busy handler counts; each paces at its share of -rate")
+	fs.BoolVar(&cfg.Wait, "wait", false, "after the report, keep every database open until interrupted, e.g. to look at the process through -pprof-addr, instead of closing them and exiting")
+	fs.BoolVar(&cfg.ValidatePrealloc, "validate-prealloc", false, "with -preallocate-bytes, fill a throwaway database before the workload and fail unless its pages came from the arena: PAGECACHE_USED above zero and PAGECACHE_OVERFLOW zero")
+	fs.BoolVar(&cfg.SortConns, "sort-conns", false, "order per-connection output by DSN and then by the order of the connections to each DSN, rather than by the order the connection hook fired in")
+	fs.DurationVar(&cfg.InterruptAfter, "interrupt-after", def.InterruptAfter, "call sqlite3_interrupt on the connection of every select once it has run this long, then print the STMT_USED and CACHE_USED the connections of the interrupted and of the completed selects were left with; an interrupted select counts as done")
+	fs.StringVar(&cfg.SecureDelete, "secure-delete", def.SecureDelete, "set pragma secure_delete to this on every connection: off, on or fast; unset leaves SQLite's default")
+	fs.Float64Var(&cfg.DeleteRatio, "delete-ratio", def.DeleteRatio, "after the inserts, delete this fraction of the rows of every database, picked at random, -commit-every rows per transaction, and print the rows deleted, freelist_count and the writer's CACHE_USED before and after; 0 deletes nothing")
+	fs.BoolVar(&cfg.ReuseStmt, "reuse-stmt", def.ReuseStmt, "prepare every insert statement once on the database and run it in each transaction through tx.Stmt, closing it after the last one, instead of preparing it anew in every transaction; compare -report-stmt-used against a run without it")
+	fs.BoolVar(&cfg.ReportStmtUsed, "report-stmt-used", def.ReportStmtUsed, "read the writer connection's STMT_USED at the end of every insert transaction, with its statements still open, and after every commit, and print their mean and max")
+	fs.IntVar(&cfg.ExpectConns, "expect-connections", 0, "fail the run unless, after the workload, the connection hook registered this many connections give or take -expect-connections-slack, listing the DSNs they went to; 0 expects nothing")
+	fs.IntVar(&cfg.ExpectConnsSlack, "expect-connections-slack", 0, "connections more or fewer than -expect-connections tolerated")
+	fs.BoolVar(&cfg.SingleConn, "single-conn", def.SingleConn, "run every database on one read-write connection and no read-only ones: the databases one after the other and each one's inserts and then -select-iterations selects sequentially on this goroutine, so no query ever waits on a lock or needs a retry")
+	fs.DurationVar(&cfg.QueryTimeout, "query-timeout", def.QueryTimeout, "cancel any select still running after this long through its context, failing its database with context.DeadlineExceeded, and print how many selects timed out; independent of -busy-timeout, 0 lets selects run as long as they take")
+	fs.StringVar(&cfg.AutoVacuum, "auto-vacuum", def.AutoVacuum, "set pragma auto_vacuum to none, full or incremental on every database before its tables are created; incremental runs pragma incremental_vacuum after the inserts and -delete-ratio deletes and prints freelist_count and CACHE_USED before and after")
+	fs.IntVar(&cfg.IncrementalVacuumPages, "incremental-vacuum-pages", def.IncrementalVacuumPages, "pages -auto-vacuum incremental frees from the freelist, 0 for all of them")
+	fs.IntVar(&cfg.FaultInjectRate, "fault-inject-rate", def.FaultInjectRate, "fail every Nth SQLite malloc and realloc while the workload runs, to exercise the SQLITE_NOMEM paths: the inserts and selects count it as a tolerated error, anywhere else it fails the database; -integrity-check then runs on every database without faults; 0 injects none")
+	fs.BoolVar(&cfg.WarmCache, "warm-cache", def.WarmCache, "have every reader run its select once, untimed, before the timed selects start, and print the cold and warm select times and the readers' CACHE_USED once warm")
+	fs.BoolVar(&cfg.StmtStatus, "stmt-status", def.StmtStatus, "read the sqlite3_stmt_status counters (FULLSCAN_STEP, SORT, AUTOINDEX, VM_STEP, MEMUSED) of every select's statement once its rows are read and print their mean and max; the inserts' statements are finalized within their Exec, before they could be read")
+	fs.StringVar(&cfg.StatusSource, "status-source", def.StatusSource, "where the final report's memory figures come from: cgo, sqlite3_db_status through every connection's handle, or pragma, page_count, cache_size, freelist_count and the dbstat table queried through SQL without extracting any handle, approximate figures that aren't comparable")
+	fs.BoolVar(&cfg.LibMutexStats, "collect-lib-mutex-stats", false, "wrap SQLite's mutex methods to count every mutex's enters, tries and leaves and the time its enters waited, and print the mutexes that waited the longest, to tell whether the concurrent selects are held up by mutexes")
+	fs.BoolVar(&cfg.Quiet, "quiet", false, "print only one line at the end, or a JSON object with -format json, with the MEMORY_USED highwater, the aggregate CACHE_USED, the rows inserted and selected, the errors tolerated and the wall time; everything else is discarded, or goes to stderr with -v")
+	fs.BoolVar(&cfg.Verbose, "v", false, "with -quiet, write the output -quiet leaves out to stderr")
+	fs.DurationVar(&cfg.AutoCheckpointInterval, "auto-checkpoint-interval", def.AutoCheckpointInterval, "in WAL mode, run pragma wal_checkpoint(PASSIVE) on every database's read-write pool at this interval from the start of its inserts until its selects are done, and print each checkpoint's frames and the -wal size before and after; 0 runs none")
+	fs.BoolVar(&cfg.PhaseSnapshots, "phase-snapshots", def.PhaseSnapshots, "snapshot the process-wide MEMORY_USED and the Go HeapAlloc of every database after its schema is created, after its inserts, after its selects and after it is closed, and print them as a table with each phase's growth")
+	fs.Int64Var(&cfg.MaxRSS, "max-rss", 0, "watch the process RSS, or on platforms without /proc the Go runtime's memory plus MEMORY_USED, and once it is above this many bytes dump the status to stderr and stop the run, which then fails; 0 watches nothing")
+	fs.Int64Var(&cfg.AssertMaxMem, "assert-max-mem", 0, "fail the run if, after the workload, the aggregated CACHE_USED or the MEMORY_USED highwater is above this many bytes, naming the metric and by how much; 0 asserts nothing")
+	fs.StringVar(&cfg.CloseOrder, "close-order", def.CloseOrder, "close every database's read-only pools and its read-write pool in this order, printing MEMORY_USED after each Close: ro-first, rw-first or interleaved, the read-write pool between the two halves of the read-only ones; unset closes them ro-first without printing")
+	fs.BoolVar(&cfg.ReportAllocator, "report-allocator", false, "add the memory.allocator expvar, next to MEMORY_USED, to the -format json report and to every -duration sample, warning about each sample where the libc allocator holds less than SQLite counts as used; needs -tags libc.memexpvar,memory.counters")
+	fs.Var((*stringList)(&cfg.Targets), "target", "run the workload, with the other flags, once per target through repro.RunWorkload and print a table comparing their db_status and MEMORY_USED: file, memory (an in-memory database, as with -in-memory) or shared-cache (a file opened with cache=shared, as with -shared-cache); repeatable")
+	fs.Var((*intList)(&cfg.CacheSizeSweep), "cache-size-sweep", "comma-separated cache_size values; run the workload once per value with pragma cache_size set on every connection and print a table of the CACHE_USED peak and select throughput of each")
+	return fs
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
 }
-func init() {
-	go runPPROF()
+
+// intList is a flag.Value collecting the comma-separated integers of every
+// occurrence of a repeatable flag.
+type intList []int
+
+func (l *intList) String() string {
+	var s []string
+	for _, v := range *l {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, ",")
 }
 
-func run() {
-	mu := sync.Mutex{}
-	var conns []uintptr
+func (l *intList) Set(v string) error {
+	for _, f := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return err
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+// retryCodes is a flag.Value of the comma-separated result codes of
+// -retry-on, held normalized.
+type retryCodes []string
+
+func (c *retryCodes) String() string { return strings.Join(*c, ",") }
+
+func (c *retryCodes) Set(v string) error {
+	codes, err := repro.ParseRetryCodes(v)
+	if err != nil {
+		return err
+	}
+	*c = codes
+	return nil
+}
+
+// minPreallocateBytes returns the smallest -preallocate-bytes that holds a
+// page cache slot whatever the per-page header size turns out to be.
+func minPreallocateBytes(cfg *Config) int {
+	return 2 * cfg.PageSize
+}
+
+// warmupRows is how many rows each -warmup-dbs database gets.
+const warmupRows = 100
+
+// minimalSeed seeds the data generated under -minimal, unless -seed is set, so
+// every run inserts the same rows.
+const minimalSeed = 1
+
+// interruptContext returns a context canceled by the first interrupt. A second
+// interrupt exits right away, for when the cleanup the first one started
+// hangs, e.g. on a Close stuck behind a busy connection. The returned function
+// stops both.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	go func() {
+		select {
+		case <-ch:
+		case <-stopped:
+			return
+		}
+		cancel()
+		select {
+		case <-ch:
+			fmt.Fprintln(os.Stderr, "second interrupt, exiting without cleanup")
+			os.Exit(1)
+		case <-stopped:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		close(stopped)
+		cancel()
+	}
+}
+
+// runPPROF serves net/http/pprof and the status handlers on addr. A listener
+// that fails, e.g. because another instance holds the port, only costs the
+// endpoints, so the error is logged and the run goes on.
+func runPPROF(addr string) {
+	if err := http.ListenAndServe(addr, nil); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: pprof-addr: %v, set -pprof-addr to another address or to \"\" to disable it\n", err)
+	}
+}
+
+func run(cfg *Config) (err error) {
+	// ctx is canceled by the interrupt that ends a -wait run after the
+	// report, so an interrupt during the workload stops it early.
+	ctx, stopSignals := interruptContext()
+	defer stopSignals()
+	// -max-rss cancels ctx too, and its error leads whatever the cancellation
+	// made run return, or fails a run that got to finish anyway.
+	ctx, stopRSS := watchRSS(ctx, cfg.MaxRSS)
+	defer func() {
+		if rssErr := stopRSS(); rssErr != nil && !errors.Is(err, rssErr) {
+			err = errors.Join(rssErr, err)
+		}
+	}()
+	// kill -USR1 dumps the status mid-run, even with -pprof-addr "".
+	defer handleStatusDumps()()
+	goroutinesAtStart := runtime.NumGoroutine()
+	runStart := time.Now()
+
+	// The modes below scale the workload, on a copy so that cfg stays what
+	// was logged.
+	scaled := *cfg
+	cfg = &scaled
+	if cfg.Minimal {
+		cfg.DBCount, cfg.ParallelSelects = 1, 1
+	}
+	if cfg.SingleConn {
+		// The selects run on the read-write connection instead.
+		cfg.ParallelSelects = 0
+		fmt.Printf("single-conn: databases=%d inserts=%d select_iterations=%d, sequentially on one connection each\n", cfg.DBCount, cfg.Inserts, cfg.SelectIterations)
+	}
+	if cfg.RaceCheck {
+		// The amount of data doesn't matter for races, the number of
+		// goroutines and connections does.
+		cfg.Inserts, cfg.DBCount, cfg.ParallelSelects = cfg.Inserts/10, 2*cfg.DBCount, 2*cfg.ParallelSelects
+	}
 
 	driver := sqlite.Driver{}
 	driver.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
-		// extract db from conn with reflection
-		dbPtr := uintptr(reflect.ValueOf(conn).Elem().FieldByName("db").Uint())
-		mu.Lock()
-		defer mu.Unlock()
-		conns = append(conns, dbPtr)
+		if cfg.StatusSource == "pragma" {
+			// The handles are left alone, see recordPragmaStatus.
+			return nil
+		}
+		dbPtr, err := repro.ConnDBHandle(conn)
+		if err != nil {
+			// Leave the connection out of the stats rather than fail it.
+			fmt.Fprintf(os.Stderr, "warning: not tracking connection to %s: %v\n", dsn, err)
+			registry.mu.Lock()
+			registry.noHandle++
+			registry.mu.Unlock()
+			return nil
+		}
+		if cfg.VMStats || cfg.TraceSQL || cfg.BusyHandler || cfg.DBLookasideCount >= 0 {
+			hookTLS := libc.NewTLS()
+			defer hookTLS.Close()
+			if cfg.DBLookasideCount >= 0 {
+				if err := configureConnLookaside(hookTLS, conn, dbPtr, cfg); err != nil {
+					return err
+				}
+			}
+			if cfg.VMStats {
+				installVMStepCounter(hookTLS, dbPtr)
+			}
+			if cfg.TraceSQL {
+				if err := installSQLTrace(hookTLS, dbPtr); err != nil {
+					return err
+				}
+			}
+			if cfg.BusyHandler {
+				if err := installBusyHandler(hookTLS, dbPtr); err != nil {
+					return err
+				}
+			}
+		}
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+		// Counted before the untracked are left out, so a connection's id
+		// doesn't depend on which others were tracked.
+		if registry.opened == nil {
+			registry.opened = make(map[string]int)
+		}
+		id := registry.opened[dsn]
+		registry.opened[dsn]++
+		if cfg.MaxTrackedConns > 0 && len(registry.conns) >= cfg.MaxTrackedConns {
+			registry.untracked++
+			return nil
+		}
+		registry.conns = append(registry.conns, registeredConn{id: id, dsn: dsn, handle: dbPtr, conn: conn})
 		return nil
 	})
 	sql.Register("sqlite2", &driver)
 
+	var cacheSlots int32
+	if cfg.PreallocateBytes > 0 {
+		slots, slotSize, err := preallocateCache(int32(cfg.PreallocateBytes), int32(cfg.PageSize), cfg.PageCacheBacking)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("preallocate: %d slots of %d bytes in a %d byte %s arena\n", slots, slotSize, cfg.PreallocateBytes, cfg.PageCacheBacking)
+		cacheSlots = slots
+	}
+
+	// tls belongs to run's goroutine, the goroutines run starts make their
+	// own, see repro.WithTLS.
 	tls := libc.NewTLS()
+	defer tls.Close()
 
-	wg := sync.WaitGroup{}
-	var closeFuncs []func() error
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
+	if rc := sqlite3.Xsqlite3_initialize(tls); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: initialize: %v", rc)
+	}
+	setHeapLimits(tls, cfg.SoftHeapLimit, cfg.HardHeapLimit)
+	// Opening a connection initializes SQLite, so this waits until it's
+	// configured.
+	if err := checkHandleLayout(tls); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: modernc.org/sqlite %s failed the connection handle self-check, its internal layout may have changed and the stats can't be trusted: %v\n", driverVersion(), err)
+	}
+
+	if cfg.ValidatePrealloc {
+		if err := validatePageCache(ctx, tls, cfg, cacheSlots); err != nil {
+			return err
+		}
+	}
+
+	if cfg.HookCost > 0 {
+		if err := measureHookCost(&driver, cfg.HookCost, cfg); err != nil {
+			return err
+		}
+		return nil
+	}
+	if cfg.OpenPath != "" {
+		if err := readOnlyWorkload(tls, cfg.OpenPath, cfg.SelectSQL, cfg.ParallelSelects); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if len(cfg.Targets) > 0 {
+		return compareTargets(ctx, tls, cfg)
+	}
+
+	if cfg.WarmupDBs > 0 {
+		if err := warmup(ctx, tls, cfg, cfg.WarmupDBs); err != nil {
+			return err
+		}
+	}
+	baselineMemUsed, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+
+	stopMonitors := make(chan struct{})
+	monitors := sync.WaitGroup{}
+	var statsd *statsdSink
+	if cfg.StatsdAddr != "" {
+		var err error
+		if statsd, err = newStatsdSink(cfg.StatsdAddr); err != nil {
+			return err
+		}
+		defer statsd.Close()
+	}
+	if cfg.ResetInterval > 0 || statsd != nil || timeline != nil {
+		monitors.Add(1)
 		go func() {
-			err, closeFunc := createAndTestDb(10000, 10)
-			if err != nil {
-				panic(err)
+			defer monitors.Done()
+			runSampler(stopMonitors, cfg.SampleInterval, cfg.ResetInterval, readLiveConns, statsd, cfg.AlignSamples, cfg)
+		}()
+	}
+	var allocatorPeak atomic.Int64
+	if cfg.AllocatorGap {
+		monitors.Add(1)
+		go func() {
+			defer monitors.Done()
+			sampleAllocatorPeak(stopMonitors, cfg.SampleInterval, &allocatorPeak)
+		}()
+	}
+	if cfg.RaceCheck {
+		// Hammer the registry from another goroutine while the hook is still
+		// appending to it, the same way the sampler and report read it.
+		monitors.Add(1)
+		go func() {
+			defer monitors.Done()
+			raceTLS := libc.NewTLS()
+			defer raceTLS.Close()
+			for {
+				select {
+				case <-stopMonitors:
+					return
+				default:
+				}
+				readLiveConns(func(conns []registeredConn) {
+					for _, c := range conns {
+						if _, _, err := repro.DBStatus(raceTLS, c.handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0); err != nil {
+							fmt.Fprintf(os.Stderr, "warning: race-check: %v\n", err)
+						}
+					}
+				})
 			}
-			mu.Lock()
-			closeFuncs = append(closeFuncs, closeFunc)
-			mu.Unlock()
-			wg.Done()
 		}()
 	}
-	wg.Wait()
 
-	printSqliteMemoryUsageForAllDbs(tls, conns)
+	var sharedDir string
+	if cfg.ShareDir {
+		var err error
+		if sharedDir, err = os.MkdirTemp(cfg.TempDir, "test-*"); err != nil {
+			return err
+		}
+	}
+
+	memUsedBefore, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+
+	var balloon []byte
+	var gcBefore runtime.MemStats
+	if cfg.BalloonBytes > 0 {
+		// Write every page so the balloon is resident and not just reserved.
+		balloon = make([]byte, cfg.BalloonBytes)
+		for i := 0; i < len(balloon); i += os.Getpagesize() {
+			balloon[i] = 1
+		}
+		runtime.ReadMemStats(&gcBefore)
+	}
+
+	// outcomes records every database's result under -error-policy
+	// collect-all, and runs counts the workload runs.
+	var outcomes []dbOutcome
+	runs := 0
+	collectAll := cfg.ErrorPolicy == "collect-all"
+	// workload creates and tests the databases with wcfg and returns the
+	// timings of the ones tested successfully and the functions closing them
+	// all. -db-workers workers take the databases one at a time and send back
+	// their results. The databases that fail don't stop the others, their
+	// errors are joined.
+	workload := func(ctx context.Context, wcfg *repro.Config) ([]repro.PhaseTiming, []func() error, error) {
+		runs++
+		if cfg.FaultInjectRate > 0 {
+			faultsArmed.Store(true)
+			defer faultsArmed.Store(false)
+		}
+		var timings []repro.PhaseTiming
+		if cfg.Minimal || wcfg.SingleConn {
+			// One database at a time, all on this goroutine apart from the
+			// -minimal reader, which repro.CreateAndTestDb waits for.
+			var closeFuncs []func() error
+			for i := 0; i < wcfg.DBCount; i++ {
+				rng := rand.New(rand.NewSource(wcfg.DataSeed(i)))
+				if cfg.Minimal {
+					rng = rand.New(rand.NewSource(cmp.Or(wcfg.Seed, minimalSeed)))
+				}
+				closeFunc, timing, err := repro.CreateAndTestDb(ctx, wcfg, workloadHooks(), sharedDir, rng)
+				closeFuncs = append(closeFuncs, closeFunc)
+				// A database cut off by ctx didn't fail, it stopped.
+				if collectAll && (err == nil || ctx.Err() == nil) {
+					outcomes = append(outcomes, dbOutcome{Run: runs, DB: i, Err: err})
+				}
+				if err != nil {
+					if collectAll && ctx.Err() == nil {
+						continue
+					}
+					return timings, closeFuncs, err
+				}
+				timings = append(timings, timing)
+			}
+			return timings, closeFuncs, nil
+		}
+
+		type result struct {
+			db        int
+			err       error
+			closeFunc func() error
+			timing    repro.PhaseTiming
+		}
+		jobs := make(chan int)
+		results := make(chan result)
+		workers := wcfg.DBCount
+		if wcfg.DBWorkers > 0 {
+			workers = min(wcfg.DBWorkers, wcfg.DBCount)
+		}
+		for w := 0; w < workers; w++ {
+			go func() {
+				repro.PinGoroutine(wcfg)
+				for i := range jobs {
+					rng := rand.New(rand.NewSource(wcfg.DataSeed(i)))
+					closeFunc, timing, err := repro.CreateAndTestDb(ctx, wcfg, workloadHooks(), sharedDir, rng)
+					results <- result{i, err, closeFunc, timing}
+				}
+			}()
+		}
+		go func() {
+			for i := 0; i < wcfg.DBCount; i++ {
+				jobs <- i
+			}
+			close(jobs)
+		}()
+
+		var closeFuncs []func() error
+		var errs []error
+		for i := 0; i < wcfg.DBCount; i++ {
+			r := <-results
+			// A failed database's pools are closed with the others.
+			closeFuncs = append(closeFuncs, r.closeFunc)
+			if collectAll && (r.err == nil || ctx.Err() == nil) {
+				outcomes = append(outcomes, dbOutcome{Run: runs, DB: r.db, Err: r.err})
+			}
+			if r.err != nil {
+				// An interrupted run is not an outcome to tally, it still
+				// stops the run.
+				if !collectAll || ctx.Err() != nil {
+					errs = append(errs, r.err)
+				}
+				continue
+			}
+			timings = append(timings, r.timing)
+		}
+		return timings, closeFuncs, errors.Join(errs...)
+	}
+
+	// loop runs the modes' iterations on the registry: the handles of each
+	// leave it before they are closed, so nothing reads them once freed, and
+	// only the open connections are read.
+	loop := repro.Loop{
+		Workload: workload,
+		Track: func(label string) func() func() {
+			registered := registeredConns()
+			return func() func() {
+				dropped := dropConns(registered)
+				return func() { reportLeakedConns(label, dropped) }
+			}
+		},
+		Conns: func(read func([]uintptr)) {
+			readLiveConns(func(conns []registeredConn) { read(handles(conns)) })
+		},
+	}
+	if cfg.CheckBaseline {
+		loop.CheckBaseline = func(tls *libc.TLS, baseline int64, label string) error {
+			return checkMemoryBaseline(tls, baseline, label, cfg)
+		}
+	}
+
+	switch {
+	case cfg.ShortLived > 0:
+		rng := rand.New(rand.NewSource(cfg.DataSeed(0)))
+		loop.Workload = func(ctx context.Context, wcfg *repro.Config) ([]repro.PhaseTiming, []func() error, error) {
+			closeFunc, timing, err := repro.CreateAndTestDb(ctx, wcfg, workloadHooks(), sharedDir, rng)
+			return []repro.PhaseTiming{timing}, []func() error{closeFunc}, err
+		}
+		residuals, err := repro.RunShortLived(ctx, cfg.Config, cfg.ShortLived, loop)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("short-lived: dbs=%d rows_per_db=%d first_residual=%d last_residual=%d max_residual=%d slope=%.1f bytes/db\n",
+			len(residuals), repro.ShortLivedRows, residuals[0], residuals[len(residuals)-1], slices.Max(residuals), slope(residuals))
+		close(stopMonitors)
+		monitors.Wait()
+		return removeSharedDir(sharedDir, cfg)
+
+	case cfg.Duration > 0:
+		var csv *csvSink
+		if cfg.CSVOut != "" {
+			var err error
+			if csv, err = newCSVSink(cfg.CSVOut); err != nil {
+				return err
+			}
+			defer csv.Close()
+		}
+		var samples []durationSample
+		loop.Sample = func() func() int64 {
+			return startSampling(&samples, csv, cfg.StreamJSON, cfg)
+		}
+		iterations, err := repro.RunDuration(ctx, cfg.Config, cfg.Duration, loop)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("duration: %v: %d iterations, %d samples\n", cfg.Duration, iterations, len(samples))
+		// -stream-json already wrote every sample.
+		if !cfg.StreamJSON {
+			if err := printDurationSamples(os.Stdout, samples, cfg.OutputFormat == "json"); err != nil {
+				return err
+			}
+		}
+		if collectAll {
+			if err := printOutcomes(os.Stdout, outcomes); err != nil {
+				return err
+			}
+		}
+		close(stopMonitors)
+		monitors.Wait()
+		return removeSharedDir(sharedDir, cfg)
+
+	case len(cfg.CacheSizeSweep) > 0:
+		loop.Sample = func() func() int64 {
+			var samples []durationSample
+			return startSampling(&samples, nil, false, cfg)
+		}
+		steps, err := repro.RunCacheSizeSweep(ctx, cfg.Config, cfg.CacheSizeSweep, loop)
+		if err != nil {
+			return err
+		}
+		rows := make([]sweepRow, len(steps))
+		for i, step := range steps {
+			rows[i] = sweepRow{CacheSize: step.CacheSize, MemUsedHighwater: step.MemUsedHighwater, CacheUsedPeak: step.CacheUsedPeak, Selects: summarizeTimings(step.Timings).Selects}
+		}
+		if err := printSweepTable(os.Stdout, rows); err != nil {
+			return err
+		}
+		if collectAll {
+			if err := printOutcomes(os.Stdout, outcomes); err != nil {
+				return err
+			}
+		}
+		close(stopMonitors)
+		monitors.Wait()
+		return removeSharedDir(sharedDir, cfg)
+
+	case cfg.Repeat > 0:
+		runs, err := repro.RunRepeat(ctx, cfg.Config, cfg.Repeat, loop)
+		if err != nil {
+			return err
+		}
+		residuals, highwaters := make([]int64, len(runs)), make([]int64, len(runs))
+		for i, r := range runs {
+			residuals[i], highwaters[i] = r.Residual, r.Highwater
+		}
+		if err := printRepeatTrend(os.Stdout, residuals, highwaters); err != nil {
+			return err
+		}
+		if collectAll {
+			if err := printOutcomes(os.Stdout, outcomes); err != nil {
+				return err
+			}
+		}
+		close(stopMonitors)
+		monitors.Wait()
+		return removeSharedDir(sharedDir, cfg)
+	}
+
+	workloadStart := time.Now()
+	endWorkload := timeline.Phase("workload", 0)
+	timings, closeFuncs, err := workload(ctx, &cfg.Config)
+	endWorkload()
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted during the workload: %w", context.Cause(ctx))
+	}
+	if collectAll {
+		if err := printOutcomes(os.Stdout, outcomes); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	workloadElapsed := time.Since(workloadStart)
+	close(stopMonitors)
+	monitors.Wait()
+	printGoroutines("after-workload", goroutinesAtStart)
 
-	expvar.Do(func(kv expvar.KeyValue) {
-		if kv.Key == "memory.allocator" {
-			fmt.Println(kv.Value.String())
+	if cfg.DDLChurnCycles > 0 {
+		endDDLChurn := timeline.Phase("ddl-churn", 0)
+		err, closeFunc := ddlChurn(tls, cfg.DDLChurnCycles, cfg)
+		endDDLChurn()
+		if err != nil {
+			return err
+		}
+		closeFuncs = append(closeFuncs, closeFunc)
+	}
+
+	memUsedAfter, memUsedHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	if cfg.Minimal {
+		fmt.Printf("minimal: MEMORY_USED before=%v after=%v delta=%v highwater=%v\n",
+			memUsedBefore, memUsedAfter, memUsedAfter-memUsedBefore, memUsedHighwater)
+	}
+	if cfg.PinCPUs > 0 {
+		pinned, unpinned := repro.PinnedGoroutines()
+		fmt.Printf("pin-cpus: cpus=%d pinned=%d unpinned=%d memused_hw=%d elapsed=%v rows_per_sec=%.0f\n",
+			cfg.PinCPUs, pinned, unpinned, memUsedHighwater, workloadElapsed.Round(time.Millisecond),
+			float64(cfg.Inserts)*float64(cfg.DBCount)/workloadElapsed.Seconds())
+	}
+	if cfg.SoftHeapLimit > 0 || cfg.HardHeapLimit > 0 {
+		fmt.Printf("heap-limit: soft=%d hard=%d MEMORY_USED highwater=%d SQLITE_NOMEM errors=%d\n",
+			cfg.SoftHeapLimit, cfg.HardHeapLimit, memUsedHighwater, repro.NomemErrors())
+	}
+	if cfg.AllocatorGap {
+		if peak := allocatorPeak.Load(); peak == 0 {
+			fmt.Fprintf(os.Stderr, "warning: allocator-gap: the %s allocator reported no bytes, its counters need the memory allocator built with -tags memory.counters\n", libcAllocator)
+		} else {
+			fmt.Printf("allocator-gap: %d bytes (libc allocator peak=%d, sqlite MEMORY_USED highwater=%d)\n",
+				peak-memUsedHighwater, peak, memUsedHighwater)
 		}
+	}
+	if cfg.BalloonBytes > 0 {
+		// MEMORY_USED counts libc heap allocations only, so it should match
+		// a run without -balloon however hard the Go heap is squeezed.
+		var gcAfter runtime.MemStats
+		runtime.ReadMemStats(&gcAfter)
+		fmt.Printf("balloon: bytes=%d go_heap_inuse=%d gc_cycles=%d memused_hw=%d (compare with a run without -balloon)\n",
+			len(balloon), gcAfter.HeapInuse, gcAfter.NumGC-gcBefore.NumGC, memUsedHighwater)
+	}
+	mallocCount, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MALLOC_COUNT)
+	// Normalizing by rows makes runs with different -inserts comparable.
+	rowsInserted := int64(cfg.Inserts) * int64(cfg.DBCount)
+	memUsedPer1kRows := memUsedHighwater * 1000 / max(rowsInserted, 1)
+
+	// The checks and reports below read the connections still open with mu
+	// and their locks held, so neither the pools nor a dropConns can close
+	// them meanwhile. Nothing below runs SQL, which could need mu to open a
+	// connection.
+	registry.mu.Lock()
+	conns, unlockConns := liveConns()
+	poolClosed := len(registry.conns) - len(conns)
+	untracked, noHandle := registry.untracked, registry.noHandle
+	release := sync.OnceFunc(func() {
+		unlockConns()
+		registry.mu.Unlock()
 	})
-	
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt, os.Kill)
-	<-ch
+	defer release()
+
+	if cfg.ReportPath != "" || cfg.ComparePath != "" {
+		stats, errs := repro.CollectDBStatus(tls, handles(conns))
+		global, globalErrs := repro.CollectGlobalStatus(tls)
+		errs = append(errs, globalErrs...)
+		warnStatusErrors("report", errs)
+		r := repro.Result{
+			Config:     cfg.Config,
+			Timings:    timings,
+			Status:     stats,
+			Global:     global,
+			MemoryUsed: memUsedAfter - memUsedBefore,
+			Errors:     errs,
+		}
+		if cfg.ReportPath != "" {
+			if err := writeReport(cfg.ReportPath, r); err != nil {
+				return err
+			}
+		}
+		if cfg.ComparePath != "" {
+			baseline, err := readReport(cfg.ComparePath)
+			if err != nil {
+				return err
+			}
+			if err = compareReports(os.Stdout, cfg.ComparePath, baseline, "this run", r, cfg.DiffThreshold); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cfg.ExpectConns > 0 {
+		if err := checkConnCount(conns, cfg.ExpectConns, cfg.ExpectConnsSlack); err != nil {
+			return err
+		}
+	}
+	if cfg.AssertMaxMem > 0 {
+		if err := checkMaxMem(tls, handles(conns), memUsedHighwater, cfg.AssertMaxMem); err != nil {
+			return err
+		}
+	}
+
+	if cfg.CheckROWrites {
+		if err := checkReadOnlyWrites(tls, conns); err != nil {
+			return err
+		}
+	}
+	if cfg.VerifyMmap {
+		if err := checkMmapCache(tls, conns, cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.CheckAggregate {
+		if err := checkAggregateConsistency(tls, conns); err != nil {
+			return err
+		}
+	}
+	if cacheSlots > 0 {
+		printPageCacheUse(tls, cacheSlots)
+	}
+	if cfg.ScratchBytes > 0 {
+		used, usedHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_SCRATCH_USED)
+		overflow, overflowHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_SCRATCH_OVERFLOW)
+		fmt.Printf("scratch: %d byte buffer, SCRATCH_USED=%d (highwater %d) SCRATCH_OVERFLOW=%d (highwater %d)\n",
+			cfg.ScratchBytes, used, usedHighwater, overflow, overflowHighwater)
+	}
+	if cfg.HeapBytes > 0 {
+		if err := checkHeapCeiling(tls, int64(cfg.HeapBytes)); err != nil {
+			return err
+		}
+	}
+	if cfg.VerifyPrealloc {
+		if err := checkPreallocation(tls); err != nil {
+			return err
+		}
+	}
+
+	if cfg.StatusSource == "pragma" {
+		repro.PrintPragmaStatus(os.Stdout)
+	} else if !cfg.Summary {
+		fmt.Println("sqlite: status source: cgo, sqlite3_db_status of every connection handle")
+		errs, err := printSqliteMemoryUsageForAllDbs(tls, handles(connOrder(conns, cfg)), untracked, summarizeTimings(timings), memUsedPer1kRows, cfg)
+		warnStatusErrors("status", errs)
+		if err != nil {
+			return err
+		}
+		if untracked > 0 {
+			fmt.Printf("sqlite: aggregate is a sample of %v of %v connections, %v untracked (-max-tracked-conns)\n",
+				len(conns), len(conns)+poolClosed+untracked+noHandle, untracked)
+		}
+		if poolClosed > 0 {
+			fmt.Printf("sqlite: aggregate leaves out %v connections their pools closed (-max-idle-conns or -conn-max-lifetime)\n", poolClosed)
+		}
+		if noHandle > 0 {
+			fmt.Printf("sqlite: aggregate is partial, %v of %v connections had no handle to register\n",
+				noHandle, len(conns)+poolClosed+untracked+noHandle)
+		}
+		fmt.Printf("sqlite: MEMORY_USED highwater: %v (%v bytes per 1000 rows inserted)\n", memUsedHighwater, memUsedPer1kRows)
+		if cfg.VMStats {
+			fmt.Printf("sqlite: VM steps: %v\n", vmSteps.Load())
+		}
+		if cfg.BusyHandler {
+			fmt.Printf("sqlite: busy handler invocations: %v\n", busyInvocations.Load())
+		}
+		fmt.Printf("sqlite: SQLITE_BUSY/SQLITE_LOCKED errors: %v\n", repro.BusyErrors())
+		if cfg.PoolConfigured() {
+			repro.PrintPoolStats(os.Stdout, &cfg.Config)
+		}
+		if cfg.DBLookasideCount >= 0 {
+			printConnLookaside(os.Stdout, cfg)
+		}
+		if cfg.RollbackRatio > 0 {
+			repro.PrintTxnEnds(os.Stdout, &cfg.Config)
+		}
+		if cfg.InterruptAfter > 0 {
+			repro.PrintInterrupts(os.Stdout, &cfg.Config)
+		}
+		if cfg.ReportStmtUsed {
+			repro.PrintStmtUsed(os.Stdout, &cfg.Config)
+		}
+		if cfg.QueryTimeout > 0 {
+			repro.PrintQueryTimeouts(os.Stdout, &cfg.Config)
+		}
+		if cfg.FaultInjectRate > 0 {
+			printFaults(os.Stdout, cfg)
+		}
+		if cfg.StmtStatus {
+			repro.PrintStmtStatus(os.Stdout)
+		}
+		if cfg.LibMutexStats {
+			if err := printMutexStats(os.Stdout); err != nil {
+				return err
+			}
+		}
+		if cfg.SQLFile == "" {
+			repro.PrintStrLengths(os.Stdout, &cfg.Config)
+		}
+		repro.PrintFileTotals(os.Stdout)
+		if cfg.MaxRetries > 0 {
+			fmt.Printf("sqlite: retries: %v (-max-retries %d, -retry-on %s)\n", repro.Retries(), cfg.MaxRetries, strings.Join(cfg.RetryOn, ","))
+		}
+		if cfg.AllocPhases {
+			printAllocPhases()
+		}
+
+		printAllocatorStat(os.Stdout, tls)
+	}
+	if cfg.OutputFormat == "benchstat" {
+		printBenchstat(os.Stdout, memUsedHighwater, memUsedPer1kRows, mallocCount, cfg)
+	}
+
+	var bottomLine quietSummary
+	if cfg.Quiet {
+		// Read while the connections are still open, like the report.
+		aggregate, errs := aggregateSqliteMemoryUsage(tls, handles(conns))
+		warnStatusErrors("quiet", errs)
+		bottomLine = quietSummary{
+			MemUsedHighwater: memUsedHighwater,
+			MemUsedPer1kRows: memUsedPer1kRows,
+			CacheUsed:        aggregate[sqlite3.SQLITE_DBSTATUS_CACHE_USED],
+			Errors:           repro.BusyErrors() + repro.NomemErrors() + repro.QueryTimeouts(),
+			StatusErrors:     len(errs),
+		}
+		for _, t := range timings {
+			bottomLine.RowsInserted += t.InsertRows
+			bottomLine.RowsSelected += t.SelectRows
+		}
+	}
+
+	release()
+	if cfg.Wait {
+		<-ctx.Done()
+	}
+	endClose := timeline.Phase("close", 0)
+	closing := dropConns(0)
 	for _, closeFunc := range closeFuncs {
 		if err := closeFunc(); err != nil {
-			panic(err)
+			return err
+		}
+	}
+	leaked := reportLeakedConns("close", closing)
+	fmt.Printf("leak-check: %d of %d registered connections still open after close\n", leaked, len(closing))
+	if cfg.PhaseSnapshots {
+		if err := repro.PrintPhaseSnapshots(os.Stdout); err != nil {
+			return err
 		}
 	}
+	checkGoroutines("after-close", goroutinesAtStart)
+	endClose()
+	if err := removeSharedDir(sharedDir, cfg); err != nil {
+		return err
+	}
+	if cfg.CheckBaseline {
+		if err := checkMemoryBaseline(tls, baselineMemUsed, "shutdown", cfg); err != nil {
+			return err
+		}
+	}
+	if balloon != nil {
+		balloon = nil
+		runtime.GC()
+	}
+	if cfg.ClassifyRetained {
+		classifyRetainedMemory(tls, baselineMemUsed)
+	}
+	if cfg.AllocHistogram {
+		printAllocSizes()
+	}
+
+	if cfg.Summary {
+		// Memory is considered reclaimed when closing every handle brings
+		// MEMORY_USED back to what SQLite held right after initialization.
+		memUsed, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		reclaimed := "no"
+		if memUsed <= baselineMemUsed {
+			reclaimed = "yes"
+		}
+		fmt.Printf("inserts=%d dbs=%d memused_hw=%d memused_hw_per_1k_rows=%d malloc_count=%d reclaimed=%s\n",
+			cfg.Inserts, cfg.DBCount, memUsedHighwater, memUsedPer1kRows, mallocCount, reclaimed)
+	}
+
+	if timeline != nil {
+		if err := timeline.write(cfg.ChromeTracePath); err != nil {
+			return err
+		}
+	}
+	printGoroutines("shutdown", goroutinesAtStart)
+	if cfg.Quiet {
+		bottomLine.Wall = time.Since(runStart)
+		return printQuietSummary(quietOut, bottomLine, cfg)
+	}
+	return nil
 }
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "preallocate" {
-		if len(os.Args) != 3 {
-			fmt.Println("usage: preallocate <page-cache-size-bytes>")
+	cfg := new(Config)
+	fs := newFlagSet(cfg)
+	fs.Parse(os.Args[1:])
+	if err := validateFlags(cfg, fs.Args()); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		os.Exit(2)
+	}
+	cfg.resolve()
+	if cfg.Quiet {
+		if err := redirectForQuiet(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	// -diff only compares files, it needs no pprof listener.
+	if cfg.Diff {
+		if fs.NArg() != 2 {
+			fmt.Println("usage: -diff [-diff-threshold <percent>] <a.json> <b.json>")
+			os.Exit(1)
+		}
+		if err := diffReports(os.Stdout, fs.Arg(0), fs.Arg(1), cfg.DiffThreshold); err != nil {
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		pageCacheSizeBytes, err := strconv.Atoi(os.Args[2])
+		return
+	}
+	if cfg.PprofAddr != "" {
+		if cfg.HookCost == 0 {
+			// -hook-cost leaves closed handles in the registry.
+			http.HandleFunc("/sqlite/status", func(w http.ResponseWriter, r *http.Request) { serveStatus(w, r, cfg) })
+			http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) { serveMetrics(w, r, cfg) })
+		}
+		go runPPROF(cfg.PprofAddr)
+	}
+	// With -quiet these go where the rest of the output does.
+	info := os.Stderr
+	if cfg.Quiet {
+		info = os.Stdout
+	}
+	printSQLiteVersion(info)
+	printLibcAllocator(info)
+	if err := checkLibcAllocator(cfg.Allocator); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.AllocatorGap || cfg.ReportAllocator {
+		if _, err := allocatorBytes(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	switch {
+	case cfg.PinCPUs > 0 && !repro.CPUAffinitySupported:
+		fmt.Fprintln(os.Stderr, "warning: -pin-cpus is only supported on linux, running unpinned")
+	case cfg.PinCPUs > runtime.NumCPU():
+		fmt.Fprintf(os.Stderr, "warning: -pin-cpus %d exceeds the %d logical CPUs, pinning to %d\n", cfg.PinCPUs, runtime.NumCPU(), runtime.NumCPU())
+		cfg.PinCPUs = runtime.NumCPU()
+	}
+	if err := checkTempDir(cmp.Or(cfg.TempDir, os.TempDir())); err != nil {
+		fmt.Fprintf(os.Stderr, "temp-dir: %v\n", err)
+		os.Exit(1)
+	}
+	checkTempFS(cmp.Or(cfg.TempDir, os.TempDir()), cfg)
+	if cfg.ChromeTracePath != "" {
+		timeline = newChromeTrace()
+	}
+	if err := repro.EnableMemStatus(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.LookasideCount >= 0 {
+		var err error
+		repro.WithTLS(func(tls *libc.TLS) {
+			err = configureLookaside(tls, int32(cfg.LookasideSize), int32(cfg.LookasideCount))
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if cfg.HeapBytes > 0 {
+		var err error
+		repro.WithTLS(func(tls *libc.TLS) { err = configureHeap(tls, int32(cfg.HeapBytes), int32(cfg.HeapMinAlloc)) })
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if cfg.ScratchBytes > 0 {
+		var err error
+		repro.WithTLS(func(tls *libc.TLS) { err = configureScratch(tls, int32(cfg.ScratchBytes)) })
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	var installers []func() error
+	if cfg.AllocPhases || cfg.VerifyPrealloc || cfg.AllocHistogram {
+		installers = append(installers, func() error { return installCountingAllocator(cfg.PageSize) })
+	}
+	if cfg.FaultInjectRate > 0 {
+		installers = append(installers, func() error { return installFaultInjector(cfg.FaultInjectRate) })
+	}
+	if cfg.LibMutexStats {
+		installers = append(installers, installCountingMutexes)
+	}
+	for _, install := range installers {
+		if err := install(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if cfg.SQLFile != "" {
+		if _, err := repro.LoadSQLFile(cfg.SQLFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if cfg.DryRun {
+		if err := printDryRun(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Printf("config: %+v\n", *cfg)
+	// Set after the config line, which would otherwise log a file pointer,
+	// and after -quiet has redirected stdout.
+	cfg.Out = os.Stdout
+	if cfg.CheckpointMode != "" && cfg.JournalMode != "wal" {
+		fmt.Fprintf(os.Stderr, "warning: -checkpoint-mode needs WAL, the databases run in journal_mode %s and are not checkpointed\n", cfg.JournalMode)
+	}
+	if cfg.AutoCheckpointInterval > 0 && cfg.JournalMode != "wal" {
+		fmt.Fprintf(os.Stderr, "warning: -auto-checkpoint-interval needs WAL, the databases run in journal_mode %s and are not checkpointed\n", cfg.JournalMode)
+	}
+	if cfg.IntDistribution == "zipf" {
+		fmt.Printf("int-distribution: zipf s=%g v=%d over [0, %d)\n", repro.ZipfS, repro.ZipfV, cfg.Inserts)
+	}
+	stopCPUProfile := func() error { return nil }
+	if cfg.CPUProfile != "" {
+		var err error
+		if stopCPUProfile, err = startCPUProfile(cfg.CPUProfile); err != nil {
+			fmt.Fprintf(os.Stderr, "cpuprofile: %v\n", err)
+			os.Exit(1)
 		}
-		preallocateCache(int32(pageCacheSizeBytes))
 	}
-	run()
+	// The profiles are written whether or not run fails, an interrupted
+	// workload returns an error too.
+	err := run(cfg)
+	if perr := stopCPUProfile(); perr != nil {
+		fmt.Fprintf(os.Stderr, "warning: cpuprofile: %v\n", perr)
+	}
+	if cfg.MemProfile != "" {
+		if perr := writeHeapProfile(cfg.MemProfile); perr != nil {
+			fmt.Fprintf(os.Stderr, "warning: memprofile: %v\n", perr)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func createAndTestDb(insertsN int, parallelSelects int) (err error, close func() error) {
-	dir, err := os.MkdirTemp("", "test-*")
+// validateFlags rejects the flag values bound into cfg, and the combinations,
+// the workload can't run with, and the arguments args other than -diff's.
+func validateFlags(cfg *Config, args []string) error {
+	switch {
+	case len(args) > 0 && !cfg.Diff:
+		return fmt.Errorf("unexpected arguments %q", args)
+	case cfg.Inserts <= 0:
+		return errors.New("-inserts must be positive")
+	case cfg.CommitEvery < 0:
+		return errors.New("-commit-every must not be negative")
+	case cfg.MinStrSize < 0 || cfg.MaxStrSize <= cfg.MinStrSize:
+		return errors.New("-max-str must be larger than -min-str, which must not be negative")
+	case cfg.ParallelSelects < 0:
+		return errors.New("-parallel-selects must not be negative")
+	case cfg.DBCount <= 0:
+		return errors.New("-db-count must be positive")
+	case cfg.WarmupDBs < 0:
+		return errors.New("-warmup-dbs must not be negative")
+	case !(cfg.SelectSelectivity > 0 && cfg.SelectSelectivity <= 1):
+		return errors.New("-select-selectivity must be above 0 and at most 1")
+	case cfg.SelectIterations <= 0:
+		return errors.New("-select-iterations must be positive")
+	case cfg.Tables <= 0:
+		return errors.New("-tables must be positive")
+	case cfg.Tables > 1 && (cfg.CreateIndex || cfg.Analyze || cfg.OpenCursors > 0 || cfg.VerifyBlobFree):
+		return errors.New("-create-index, -analyze, -open-cursors and -verify-blob-free work on the single table t and cannot be combined with -tables")
+	case cfg.SQLFile != "" && (cfg.CreateIndex || cfg.Analyze || cfg.OpenCursors > 0 || cfg.VerifyBlobFree || cfg.Tables > 1 || cfg.ROHold > 0 || cfg.InsertRate > 0):
+		return errors.New("-create-index, -analyze, -open-cursors, -verify-blob-free, -tables, -ro-hold and -rate work on the built-in table and cannot be combined with -sql-file")
+	case cfg.BackgroundWrites && (cfg.ROHold > 0 || cfg.SQLFile != ""):
+		return errors.New("-background-writes inserts into the built-in table and cannot be combined with -ro-hold, which writes too, or -sql-file")
+	case !slices.Contains(repro.IntDistributions, cfg.IntDistribution):
+		return fmt.Errorf("-int-distribution must be one of %s, not %q", strings.Join(repro.IntDistributions, ", "), cfg.IntDistribution)
+	case cfg.CheckpointMode != "" && !slices.Contains(repro.CheckpointModes, cfg.CheckpointMode):
+		return fmt.Errorf("-checkpoint-mode must be one of %s", strings.Join(repro.CheckpointModes, ", "))
+	case cfg.TempStore != "" && !slices.Contains(repro.TempStores, cfg.TempStore):
+		return fmt.Errorf("-temp-store must be one of %s", strings.Join(repro.TempStores, ", "))
+	case cfg.TempStore != "" && cfg.SQLFile != "":
+		return errors.New("-temp-store copies the workload's table, it cannot be combined with -sql-file")
+	case cfg.RollbackRatio < 0 || cfg.RollbackRatio > 1:
+		return errors.New("-rollback-ratio must be between 0 and 1")
+	case cfg.MmapSize < 0:
+		return errors.New("-mmap-size must not be negative")
+	case cfg.MmapSize > 0 && cfg.VerifyMmap:
+		return errors.New("-mmap-size cannot be combined with -verify-mmap, which sets the read-only connections' mmap_size itself")
+	case cfg.MmapSize > 0 && cfg.InMemory:
+		return errors.New("-mmap-size needs database files, it cannot be combined with -in-memory")
+	case cfg.MaxPageCount < 0:
+		return errors.New("-max-page-count must not be negative")
+	case cfg.MaxOpenConns < 0:
+		return errors.New("-max-open-conns must not be negative")
+	case cfg.MaxIdleConns < -1:
+		return errors.New("-max-idle-conns must be -1 or more")
+	case cfg.ConnMaxLifetime < 0:
+		return errors.New("-conn-max-lifetime must not be negative")
+	case cfg.DBWorkers < 0:
+		return errors.New("-db-workers must not be negative")
+	case cfg.PageSize < 512 || cfg.PageSize > 65536 || cfg.PageSize&(cfg.PageSize-1) != 0:
+		return fmt.Errorf("-page-size must be a power of two between 512 and 65536, not %d", cfg.PageSize)
+	case cfg.PreallocateBytes != 0 && (cfg.PreallocateBytes < minPreallocateBytes(cfg) || cfg.PreallocateBytes > math.MaxInt32):
+		return fmt.Errorf("-preallocate-bytes must be between %d, one page cache slot, and %d", minPreallocateBytes(cfg), math.MaxInt32)
+	case cfg.ErrorPolicy != "fail-fast" && cfg.ErrorPolicy != "collect-all":
+		return fmt.Errorf("-error-policy must be fail-fast or collect-all, not %q", cfg.ErrorPolicy)
+	case !slices.Contains([]string{"delete", "wal", "memory", "off"}, cfg.JournalMode):
+		return fmt.Errorf("-journal-mode must be delete, wal, memory or off, not %q", cfg.JournalMode)
+	case cfg.WALShm > 0 && cfg.JournalMode != "delete" && cfg.JournalMode != "wal":
+		return fmt.Errorf("-wal-shm runs in WAL mode and cannot be combined with -journal-mode %s", cfg.JournalMode)
+	case !slices.Contains([]string{"text", "json", "benchstat"}, cfg.OutputFormat):
+		return fmt.Errorf("-format must be text, json or benchstat, not %q", cfg.OutputFormat)
+	case cfg.HeapBytes < 0 || cfg.HeapBytes > math.MaxInt32:
+		return fmt.Errorf("-heap-bytes must be between 0 and %d", math.MaxInt32)
+	case cfg.ScratchBytes < 0 || cfg.ScratchBytes > math.MaxInt32:
+		return fmt.Errorf("-scratch-bytes must be between 0 and %d", math.MaxInt32)
+	case cfg.HeapMinAlloc < 0 || cfg.HeapMinAlloc&(cfg.HeapMinAlloc-1) != 0:
+		return errors.New("-heap-min-alloc must be 0 or a power of two")
+	case cfg.SoftHeapLimit < 0 || cfg.HardHeapLimit < 0:
+		return errors.New("-soft-heap-limit and -hard-heap-limit must not be negative")
+	case slices.ContainsFunc(cfg.DSNParams, func(p string) bool { return !strings.Contains(p, "=") }):
+		return fmt.Errorf("-dsn-param must be key=value, got %q", cfg.DSNParams)
+	case cfg.MaxRetries < 0:
+		return errors.New("-max-retries must not be negative")
+	case cfg.BusyTimeout < 0:
+		return errors.New("-busy-timeout must not be negative")
+	case cfg.BusyTimeout > 0 && cfg.BusyHandler:
+		return errors.New("-busy-handler replaces busy_timeout and cannot be combined with -busy-timeout")
+	case cfg.ROHold < 0:
+		return errors.New("-ro-hold must not be negative")
+	case cfg.ROHold > 0 && cfg.JournalMode != "wal" && cfg.WALShm == 0 && !cfg.BusyHandler && cfg.BusyTimeout == 0:
+		// In the rollback journal modes the readers' shared locks keep the
+		// writer out and it fails with SQLITE_BUSY right away.
+		return errors.New("-ro-hold needs -journal-mode wal, -busy-handler or -busy-timeout")
+	case cfg.BackgroundWrites && cfg.JournalMode != "wal" && cfg.WALShm == 0 && !cfg.BusyHandler && cfg.BusyTimeout == 0:
+		// As with -ro-hold.
+		return errors.New("-background-writes needs -journal-mode wal, -busy-handler or -busy-timeout")
+	case cfg.Writers < 1 || cfg.Writers > cfg.Inserts:
+		return errors.New("-writers must be between 1 and -inserts")
+	case cfg.Writers > 1 && !cfg.BusyHandler && cfg.BusyTimeout == 0:
+		// Even in WAL mode only one writer holds the lock at a time, the
+		// others fail with SQLITE_BUSY right away.
+		return errors.New("-writers needs -busy-handler or -busy-timeout")
+	case cfg.Writers > 1 && (cfg.SQLFile != "" || cfg.MaxPageCount > 0):
+		return errors.New("-writers cannot be combined with -sql-file or -max-page-count")
+	case cfg.InsertRate > 0 && cfg.InsertRate < cfg.Writers:
+		return errors.New("-rate must be at least -writers, every writer paces at its share of it")
+	case cfg.AttachCount < 0:
+		return errors.New("-attach-count must not be negative")
+	case cfg.AttachCount > 0 && cfg.RaceCheck:
+		return errors.New("-attach-count cannot be combined with -race-check, which spreads db over several connections")
+	case cfg.BlobSize < 0:
+		return errors.New("-blob-size must not be negative")
+	case cfg.VerifyBlobFree && cfg.BlobSize == 0:
+		return errors.New("-verify-blob-free needs -blob-size")
+	case cfg.LookasideCount > 0 && cfg.LookasideSize <= 0:
+		return errors.New("-lookaside-slot-size must be positive")
+	case cfg.DBLookasideCount > 0 && cfg.DBLookasideSize <= 0:
+		return errors.New("-conn-lookaside-slot-size must be positive")
+	case slices.Contains(cfg.CacheSizeSweep, 0):
+		return errors.New("-cache-size-sweep values must not be 0, which leaves cache_size at its default")
+	case len(cfg.CacheSizeSweep) > 0 && (cfg.Repeat > 0 || cfg.ShortLived > 0 || cfg.Duration > 0):
+		return errors.New("-cache-size-sweep cannot be combined with -repeat, -short-lived or -duration")
+	case cfg.StreamJSON && cfg.Duration == 0:
+		return errors.New("-stream-json needs -duration")
+	case cfg.CSVOut != "" && cfg.Duration == 0:
+		return errors.New("-csv-out needs -duration")
+	case cfg.InMemory && (cfg.JournalMode == "wal" || cfg.WALShm > 0):
+		return errors.New("-in-memory databases cannot use WAL, -journal-mode must be delete, memory or off")
+	case cfg.InMemory && (cfg.AttachCount > 0 || cfg.ShareDir || cfg.VerifyMmap || cfg.KeepTemp):
+		return errors.New("-in-memory cannot be combined with -attach-count, -share-dir, -verify-mmap or -keep-temp, which need database files")
+	case cfg.VerifyPrealloc && cfg.PreallocateBytes == 0:
+		return errors.New("-verify-prealloc needs -preallocate-bytes")
+	case len(cfg.Targets) > 0 && (cfg.InMemory || cfg.SharedCache || cfg.SQLFile != "" || cfg.Repeat > 0 || cfg.ShortLived > 0 || cfg.Duration > 0 || len(cfg.CacheSizeSweep) > 0):
+		return errors.New("-target sets where the databases are stored and cannot be combined with -in-memory, -shared-cache, -sql-file, -repeat, -short-lived, -duration or -cache-size-sweep")
+	case slices.ContainsFunc(cfg.Targets, func(t string) bool { return !slices.Contains(targetNames, t) }):
+		return fmt.Errorf("-target must be one of %v", targetNames)
+	case slices.Contains(cfg.Targets, targetMemory) && cfg.JournalMode == "wal":
+		return errors.New("-target memory cannot use WAL, -journal-mode must not be wal")
+	case cfg.CloseOrder != "" && !slices.Contains(repro.CloseOrders, cfg.CloseOrder):
+		return fmt.Errorf("-close-order must be one of %v", repro.CloseOrders)
+	case cfg.SecureDelete != "" && !slices.Contains(repro.SecureDeleteModes, cfg.SecureDelete):
+		return fmt.Errorf("-secure-delete must be one of %v", repro.SecureDeleteModes)
+	case cfg.DeleteRatio < 0 || cfg.DeleteRatio > 1:
+		return errors.New("-delete-ratio must be between 0 and 1")
+	case cfg.DeleteRatio > 0 && (cfg.Tables > 1 || cfg.SQLFile != ""):
+		return errors.New("-delete-ratio deletes from the single built-in table t and cannot be combined with -tables or -sql-file")
+	case cfg.ReuseStmt && cfg.ManualTx:
+		return errors.New("-reuse-stmt cannot be combined with -manual-tx, whose transactions database/sql doesn't know about")
+	case cfg.ExpectConns < 0 || cfg.ExpectConnsSlack < 0:
+		return errors.New("-expect-connections and -expect-connections-slack must not be negative")
+	case cfg.AssertMaxMem < 0:
+		return errors.New("-assert-max-mem must not be negative")
+	case cfg.SingleConn && (cfg.Minimal || cfg.RaceCheck || cfg.Writers > 1 || cfg.ROHold > 0 || cfg.BackgroundWrites || cfg.MaxOpenConns > 0):
+		return errors.New("-single-conn cannot be combined with -minimal, -race-check, -writers, -ro-hold, -background-writes or -max-open-conns")
+	case cfg.SingleConn && (cfg.OpenCursors > 0 || cfg.VerifyBlobFree || cfg.SharedCache || cfg.CheckROWrites || cfg.WarmCache):
+		return errors.New("-single-conn opens no read-only connections for -open-cursors, -verify-blob-free, -shared-cache, -check-ro-writes or -warm-cache")
+	case cfg.AutoVacuum != "" && !slices.Contains(repro.AutoVacuumModes, cfg.AutoVacuum):
+		return fmt.Errorf("-auto-vacuum must be one of %v", repro.AutoVacuumModes)
+	case !slices.Contains(repro.StatusSources, cfg.StatusSource):
+		return fmt.Errorf("-status-source must be one of %v", repro.StatusSources)
+	case cfg.StatusSource == "pragma" && (cfg.PerConn || cfg.VMStats || cfg.TraceSQL || cfg.BusyHandler || cfg.DBLookasideCount >= 0 || cfg.Summary || cfg.OutputFormat != "text" || cfg.ExpectConns > 0 || cfg.AssertMaxMem > 0):
+		return errors.New("-status-source pragma registers no connection handles for -per-conn, -vm-stats, -trace-sql, -busy-handler, -conn-lookaside-count, -summary, -format, -expect-connections or -assert-max-mem")
+	case cfg.Quiet && (cfg.Summary || cfg.DryRun || cfg.Diff || cfg.Repeat > 0 || cfg.ShortLived > 0 || cfg.Duration > 0 || len(cfg.CacheSizeSweep) > 0 || cfg.StatusSource == "pragma"):
+		return errors.New("-quiet summarizes a single run's cgo status, it cannot be combined with -summary, -dry-run, -diff, -repeat, -short-lived, -duration, -cache-size-sweep or -status-source pragma")
+	case cfg.Verbose && !cfg.Quiet:
+		return errors.New("-v only applies with -quiet")
+	case cfg.PhaseSnapshots && (cfg.Repeat > 0 || cfg.ShortLived > 0 || cfg.Duration > 0 || len(cfg.CacheSizeSweep) > 0):
+		return errors.New("-phase-snapshots reports a single run's databases, it cannot be combined with -repeat, -short-lived, -duration or -cache-size-sweep")
+	case (cfg.Repeat > 0 || cfg.ShortLived > 0 || cfg.Duration > 0 || len(cfg.CacheSizeSweep) > 0) && (cfg.ResetInterval > 0 || cfg.StatsdAddr != "" || cfg.RaceCheck):
+		// These read the registry concurrently and could still be reading a
+		// handle a run has just closed.
+		return errors.New("-repeat, -short-lived, -duration and -cache-size-sweep cannot be combined with -reset-interval, -statsd or -race-check")
+	case cfg.MaxRSS < 0:
+		return errors.New("-max-rss must not be negative")
+	case cfg.AutoCheckpointInterval < 0:
+		return errors.New("-auto-checkpoint-interval must not be negative")
+	case cfg.FaultInjectRate < 0:
+		return errors.New("-fault-inject-rate must not be negative")
+	case cfg.IncrementalVacuumPages < 0:
+		return errors.New("-incremental-vacuum-pages must not be negative")
+	case cfg.InterruptAfter < 0 || cfg.QueryTimeout < 0:
+		return errors.New("-interrupt-after and -query-timeout must not be negative")
+	case !slices.Contains(pageCacheBackings, cfg.PageCacheBacking):
+		return fmt.Errorf("-pagecache-backing must be one of %v", pageCacheBackings)
+	case cfg.ValidatePrealloc && cfg.PreallocateBytes == 0:
+		return errors.New("-validate-prealloc needs -preallocate-bytes")
+	}
+	return nil
+}
+
+// checkTempDir returns an error unless dir is a directory a file can be
+// created in.
+func checkTempDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, "check-*")
 	if err != nil {
-		return err, nil
+		return err
 	}
+	f.Close()
+	return os.Remove(f.Name())
+}
 
-	defer os.RemoveAll(dir)
+// checkTempFS warns, or exits under -fail-on-tmpfs, when dir is on tmpfs. File
+// pages on tmpfs are RAM, so they distort every memory measurement.
+func checkTempFS(dir string, cfg *Config) {
+	tmpfs, err := isTmpfs(dir)
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "warning: cannot determine the filesystem type of %s: %v\n", dir, err)
+	case tmpfs && cfg.FailOnTmpfs:
+		fmt.Fprintf(os.Stderr, "%s is on tmpfs, whose file pages count as RAM and distort memory measurements; point -temp-dir or TMPDIR at a directory on a real disk\n", dir)
+		os.Exit(1)
+	case tmpfs:
+		fmt.Fprintf(os.Stderr, "warning: %s is on tmpfs, file pages will count as RAM and distort memory measurements\n", dir)
+	}
+}
 
-	fn := filepath.Join(dir, "db")
+// removeSharedDir removes the -share-dir directory, if any. Under -keep-temp
+// it is left in place, with every database in it, and its path printed.
+func removeSharedDir(dir string, cfg *Config) error {
+	if dir == "" {
+		return nil
+	}
+	if cfg.KeepTemp {
+		fmt.Printf("keep-temp: kept %s\n", dir)
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
 
-	db, err := sql.Open("sqlite2", fn)
-	if err != nil {
-		return err, nil
+// workloadHooks opens the workload's connections with the sqlite2 driver,
+// whose connection hook registers them, and traces it into -chrome-trace.
+func workloadHooks() repro.Hooks {
+	hooks := repro.Hooks{Driver: "sqlite2"}
+	if timeline != nil {
+		hooks.Trace = timeline
 	}
+	return hooks
+}
+
+// registeredConn is a SQLite connection captured by the connection hook.
+type registeredConn struct {
+	// id is the number of connections to dsn registered before this one.
+	id     int
+	dsn    string
+	handle uintptr
+	// conn is the driver connection, kept to tell whether it was closed.
+	conn sqlite.ExecQuerierContext
+}
 
-	if _, err = db.Exec(`
-drop table if exists t;
-create table t(i int, str text);
-`); err != nil {
-		return err, nil
+func handles(conns []registeredConn) []uintptr {
+	r := make([]uintptr, len(conns))
+	for i, c := range conns {
+		r[i] = c.handle
 	}
+	return r
+}
 
-	if err = inserts(db, insertsN, 100, 10, 1000); err != nil {
-		return err, nil
+// isReadOnlyDSN reports whether dsn opens the database with mode=ro, or with
+// query_only as the read-only connections to an in-memory database do.
+func isReadOnlyDSN(dsn string) bool {
+	_, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return false
 	}
-	//fmt.Println("inserts done")
+	values, err := url.ParseQuery(query)
+	return err == nil && (values.Get("mode") == "ro" || slices.Contains(values["_pragma"], "query_only(1)"))
+}
 
-	var roDbs []*sql.DB
-	wg := sync.WaitGroup{}
-	for i := 0; i < parallelSelects; i++ {
-		wg.Add(1)
-		roDb, err := sql.Open("sqlite2", fn+"?mode=ro")
-		if err != nil {
-			return err, nil
-		}
-		roDbs = append(roDbs, roDb)
-		go func() {
-			defer wg.Done()
-			if err = selects(roDb, insertsN); err != nil {
-				panic(err)
-			}
-			//	fmt.Println("selects done")
+// checkAggregateConsistency returns an error unless the aggregate computed
+// by aggregateSqliteMemoryUsage equals the sum of per-connection currents
+// read independently, and every handle in the registry is distinct. It is
+// meant to run once the workload is quiescent, so the values cannot move in
+// between.
+func checkAggregateConsistency(tls *libc.TLS, conns []registeredConn) error {
+	var problems []string
 
-		}()
+	seen := make(map[uintptr]string)
+	for _, c := range conns {
+		if dsn, ok := seen[c.handle]; ok {
+			problems = append(problems, fmt.Sprintf("handle %#x registered twice: %s and %s", c.handle, dsn, c.dsn))
+		}
+		seen[c.handle] = c.dsn
 	}
-	wg.Wait()
 
-	return nil, func() error {
-		for _, roDb := range roDbs {
-			err = roDb.Close()
+	sum := make(map[int32]int64)
+	for _, c := range conns {
+		for _, op := range repro.DBStatusOps {
+			current, _, err := repro.DBStatus(tls, c.handle, op, 0)
 			if err != nil {
-				return err
+				problems = append(problems, fmt.Sprintf("%s: %v", c.dsn, err))
 			}
+			sum[op] += int64(current)
 		}
-		return db.Close()
+	}
+	aggregate, errs := aggregateSqliteMemoryUsage(tls, handles(conns))
+	for _, err := range errs {
+		problems = append(problems, err.Error())
+	}
+	for _, op := range repro.DBStatusOps {
+		if aggregate[op] != sum[op] {
+			problems = append(problems, fmt.Sprintf("%s: aggregate=%d sum of %d connections=%d", repro.DBStatusOpName(op), aggregate[op], len(conns), sum[op]))
+		}
+	}
 
+	if len(problems) > 0 {
+		return fmt.Errorf("aggregate does not match per-connection stats:\n%s", strings.Join(problems, "\n"))
 	}
+	fmt.Printf("check-aggregate: aggregate matches the sum of %d connections\n", len(conns))
+	return nil
 }
 
-func printSqliteMemoryUsageForAllDbs(tls *libc.TLS, conns []uintptr) {
-	totalPerOp := make(map[int32]int64)
+// printPageCacheUse prints how many of the preallocateCache slots are in use
+// and how much page cache spilled to the general allocator instead.
+func printPageCacheUse(tls *libc.TLS, slots int32) {
+	used, usedHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_PAGECACHE_USED)
+	overflow, overflowHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW)
+	fmt.Printf("preallocate: %d of %d slots in use (highwater %d), %d bytes spilled to the heap (highwater %d)\n",
+		used, slots, usedHighwater, overflow, overflowHighwater)
+}
 
-	type dbStats struct {
-		current   int32
-		highwater int32
+// checkPreallocation returns an error if SQLite made any page-sized malloc,
+// which means the SQLITE_CONFIG_PAGECACHE arena was too small or bypassed.
+// SQLite's own PAGECACHE_OVERFLOW, the bytes of page cache that went to the
+// heap, is printed alongside as a cross-check.
+func checkPreallocation(tls *libc.TLS) error {
+	overflow, overflowHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW)
+	used, usedHighwater := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_PAGECACHE_USED)
+	n := pageSizedAllocs.Load()
+	fmt.Printf("verify-prealloc: page_sized_mallocs=%d PAGECACHE_USED=%d (highwater %d slots) PAGECACHE_OVERFLOW=%d (highwater %d bytes)\n",
+		n, used, usedHighwater, overflow, overflowHighwater)
+	if n > 0 {
+		return fmt.Errorf("verify-prealloc: %d page-sized mallocs bypassed the preallocated page cache", n)
 	}
+	return nil
+}
 
-	memPtr := libc.Xmalloc(tls, types.Size_t(unsafe.Sizeof(dbStats{})))
-	if memPtr == 0 {
-		panic(fmt.Errorf("sqlite: cannot allocate memory"))
+// checkConnCount returns an error if the number of registered conns is more
+// than slack away from expected, after printing both and every DSN with the
+// connections registered to it. Too many is a leak, too few pools sharing
+// or dropping connections.
+func checkConnCount(conns []registeredConn, expected, slack int) error {
+	fmt.Printf("expect-connections: registered=%d expected=%d slack=%d\n", len(conns), expected, slack)
+	if d := len(conns) - expected; -slack <= d && d <= slack {
+		return nil
 	}
-	stats := (*dbStats)(unsafe.Pointer(memPtr))
-	defer func() {
-		stats = nil
-		libc.Xfree(tls, memPtr)
-	}()
-
-	for _, db := range conns {
-		var ops = []int32{
-			sqlite3.SQLITE_DBSTATUS_CACHE_USED,
-			sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED,
-			sqlite3.SQLITE_DBSTATUS_SCHEMA_USED,
-			sqlite3.SQLITE_DBSTATUS_STMT_USED,
-			sqlite3.SQLITE_DBSTATUS_CACHE_SPILL,
-		}
-		for _, op := range ops {
-			stats.current = 0
-			stats.highwater = 0
-			retCode := sqlite3.Xsqlite3_db_status(tls, db, op, uintptr(unsafe.Pointer(&stats.current)),
-				uintptr(unsafe.Pointer(&stats.highwater)), 0)
-			if retCode != sqlite3.SQLITE_OK {
-				panic(fmt.Errorf("sqlite: db status: %v", retCode))
-			}
+	perDSN := make(map[string]int)
+	for _, c := range conns {
+		perDSN[c.dsn]++
+	}
+	for _, dsn := range slices.Sorted(maps.Keys(perDSN)) {
+		fmt.Printf("expect-connections: %s: %d\n", dsn, perDSN[dsn])
+	}
+	return fmt.Errorf("expect-connections: %d connections registered, want %d give or take %d", len(conns), expected, slack)
+}
 
-			//fmt.Printf("sqlite: db status: %v: current=%v, highwater=%v\n", op, current, highwater)
-			totalPerOp[op] += int64(stats.current)
+// checkMaxMem returns an error naming each of the aggregated CACHE_USED of
+// conns and the global MEMORY_USED highwater that is above limit, and by how
+// much.
+func checkMaxMem(tls *libc.TLS, conns []uintptr, memUsedHighwater, limit int64) error {
+	aggregate, errs := aggregateSqliteMemoryUsage(tls, conns)
+	warnStatusErrors("assert-max-mem", errs)
+	cacheUsed := aggregate[sqlite3.SQLITE_DBSTATUS_CACHE_USED]
+	fmt.Printf("assert-max-mem: CACHE_USED=%d MEMORY_USED highwater=%d limit=%d\n", cacheUsed, memUsedHighwater, limit)
+	var breaches []error
+	for _, m := range []struct {
+		name  string
+		value int64
+	}{{"CACHE_USED", cacheUsed}, {"MEMORY_USED highwater", memUsedHighwater}} {
+		if m.value > limit {
+			breaches = append(breaches, fmt.Errorf("assert-max-mem: %s=%d is %d bytes above the limit of %d", m.name, m.value, m.value-limit, limit))
 		}
 	}
-	fmt.Println("sqlite: all connections aggregated statuses:")
-	for op, total := range totalPerOp {
-		var opStr string
-		switch op {
-		case sqlite3.SQLITE_DBSTATUS_CACHE_USED:
-			opStr = "CACHE_USED"
-		case sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED:
-			opStr = "LOOKASIDE_USED"
-		case sqlite3.SQLITE_DBSTATUS_SCHEMA_USED:
-			opStr = "SCHEMA_USED"
-		case sqlite3.SQLITE_DBSTATUS_STMT_USED:
-			opStr = "STMT_USED"
-		case sqlite3.SQLITE_DBSTATUS_CACHE_SPILL:
-			opStr = "CACHE_SPILL"
-		default:
-			opStr = fmt.Sprintf("%v", op)
+	return errors.Join(breaches...)
+}
 
-		}
-		fmt.Printf("%v: %v\n", opStr, total)
+// checkMemoryBaseline returns an error if MEMORY_USED is more than
+// -baseline-slack above baseline. It is meant to run after a workload's
+// handles are all closed: any residue would otherwise be counted against
+// whatever runs next in the same process. label names the point of the
+// lifecycle being checked.
+func checkMemoryBaseline(tls *libc.TLS, baseline int64, label string, cfg *Config) error {
+	memUsed, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	residual := memUsed - baseline
+	if residual > cfg.BaselineSlack {
+		return fmt.Errorf("check-baseline: %s: MEMORY_USED=%d is %d bytes above the baseline of %d (slack %d)",
+			label, memUsed, residual, baseline, cfg.BaselineSlack)
+	}
+	fmt.Printf("check-baseline: %s: MEMORY_USED=%d residual=%d\n", label, memUsed, residual)
+	return nil
+}
+
+// classifyRetainedMemory asks SQLite to free everything it can and prints how
+// much of MEMORY_USED above baseline that released (reclaimable) and how much
+// stayed (retained). Retained memory outlives every handle, which makes it the
+// leak candidate.
+func classifyRetainedMemory(tls *libc.TLS, baseline int64) {
+	before, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	// Each call frees at most n bytes, repeat until a call frees nothing.
+	for sqlite3.Xsqlite3_release_memory(tls, math.MaxInt32) > 0 {
 	}
+	after, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	fmt.Printf("classify-retained: baseline=%d before_release=%d reclaimable=%d retained=%d\n",
+		baseline, before, before-after, after-baseline)
 }
 
-// create a lot of inserts
-func inserts(db *sql.DB, n, commitEvery, minStringSize, maxStringSize int) error {
-	for i := 0; i < n; {
-		tx, err := db.Begin()
+// checkMmapCache prints the CACHE_USED of every read-only connection and
+// returns an error if any exceeds -verify-mmap-max-cache, which means reads
+// are still going through the heap-backed page cache rather than the mapping.
+func checkMmapCache(tls *libc.TLS, conns []registeredConn, cfg *Config) error {
+	var violations []string
+	var total, largest int64
+	for _, c := range conns {
+		if !isReadOnlyDSN(c.dsn) {
+			continue
+		}
+		cacheUsed, _, err := repro.DBStatus(tls, c.handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
 		if err != nil {
-			return err
+			return fmt.Errorf("verify-mmap: %s: %w", c.dsn, err)
+		}
+		total += int64(cacheUsed)
+		largest = max(largest, int64(cacheUsed))
+		if int(cacheUsed) > cfg.VerifyMmapMaxCache {
+			violations = append(violations, fmt.Sprintf("%s: CACHE_USED=%d", c.dsn, cacheUsed))
 		}
-		stmt, err := tx.Prepare("insert into t values(?, ?)")
+	}
+	fmt.Printf("verify-mmap: read-only CACHE_USED total=%d largest=%d limit=%d\n", total, largest, cfg.VerifyMmapMaxCache)
+	if len(violations) > 0 {
+		return fmt.Errorf("read-only connections exceeded -verify-mmap-max-cache, mmap is not serving reads:\n%s", strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+// checkReadOnlyWrites returns an error if any read-only connection has a
+// non-zero CACHE_WRITE count, listing the dsn of every offending connection.
+func checkReadOnlyWrites(tls *libc.TLS, conns []registeredConn) error {
+	var violations []string
+	for _, c := range conns {
+		if !isReadOnlyDSN(c.dsn) {
+			continue
+		}
+		written, _, err := repro.DBStatus(tls, c.handle, sqlite3.SQLITE_DBSTATUS_CACHE_WRITE, 0)
 		if err != nil {
-			tx.Rollback()
-			return err
+			return fmt.Errorf("check-ro-writes: %s: %w", c.dsn, err)
 		}
-		// Insert up to commitEvery rows or until n is reached.
-		for j := 0; j < commitEvery && i < n; j++ {
-			if _, err = stmt.Exec(i, randomString(rand.Intn(maxStringSize-minStringSize)+minStringSize)); err != nil {
-				stmt.Close()
-				tx.Rollback()
-				return err
+		if written != 0 {
+			violations = append(violations, fmt.Sprintf("%s: CACHE_WRITE=%d", c.dsn, written))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("read-only connections wrote pages:\n%s", strings.Join(violations, "\n"))
+	}
+	return nil
+}
+
+// printSqliteMemoryUsageForAllDbs prints the aggregated db_status of conns, the
+// global status, with -per-conn every connection's db_status, the Go heap and
+// timing. The -format json object also carries memUsedPer1kRows. The db_status
+// and status reads that failed are left out of the sums and returned, apart
+// from the error writing the JSON. untracked is the number of connections the
+// registry left out.
+func printSqliteMemoryUsageForAllDbs(tls *libc.TLS, conns []uintptr, untracked int, timing timingSummary, memUsedPer1kRows int64, cfg *Config) ([]error, error) {
+	stats, errs := repro.CollectDBStatus(tls, conns)
+	// An aggregate of no connections would read as zero memory.
+	noConns := "no connections registered (handle extraction may have failed)"
+	if untracked > 0 {
+		noConns = "no connections registered, every one was left untracked"
+	}
+	if len(conns) == 0 {
+		fmt.Fprintf(os.Stderr, "warning: sqlite: %s, there is no db_status to aggregate\n", noConns)
+	}
+	if cfg.OutputFormat == "json" {
+		j := newDBStatusJSON(stats, len(conns))
+		global, globalErrs := repro.CollectGlobalStatus(tls)
+		errs = append(errs, globalErrs...)
+		j.Global = newGlobalStatusJSON(global)
+		j.Timing = &timing
+		j.MemUsedPer1kRows = memUsedPer1kRows
+		j.GoHeap = repro.ReadGoHeap(cfg.GCBeforeSample)
+		if cfg.ReportAllocator {
+			if stat, err := readAllocatorStat(tls); err == nil {
+				j.Allocator = &stat
 			}
-			i++
 		}
-		stmt.Close()
-		if err = tx.Commit(); err != nil {
-			return err
+		if cfg.PerConn {
+			// The same reads just failed or succeeded for the aggregate.
+			perConn, _ := repro.CollectConnStatus(tls, conns, 0)
+			for _, c := range perConn {
+				j.PerConn = append(j.PerConn, newConnStatusJSON(c))
+			}
 		}
+		return errs, json.NewEncoder(os.Stdout).Encode(j)
 	}
-	return nil
+	// The connections need not have peaked at the same time, so the sum of
+	// their highwaters only bounds the aggregate's peak.
+	if len(conns) == 0 {
+		fmt.Println("sqlite:", noConns)
+	} else {
+		fmt.Println("sqlite: all connections aggregated statuses (current/sum of per-connection highwaters):")
+		for _, stat := range stats {
+			fmt.Printf("%v: %v/%v\n", stat.Name, stat.Current, stat.HighwaterSum)
+		}
+	}
+	if cfg.SharedCache && len(conns) > 0 {
+		// CACHE_USED counts a shared cache once per connection using it.
+		shared, sharedErrs := sumCacheUsedShared(tls, conns)
+		errs = append(errs, sharedErrs...)
+		fmt.Printf("CACHE_USED_SHARED: %v\n", shared)
+	}
+	fmt.Println("sqlite: global statuses (current/highwater):")
+	global, globalErrs := repro.CollectGlobalStatus(tls)
+	errs = append(errs, globalErrs...)
+	for _, stat := range global {
+		fmt.Printf("%v: %v/%v\n", stat.Name, stat.Current, stat.Highwater)
+	}
+	if cfg.PerConn {
+		printConnStatus(tls, conns)
+	}
+	fmt.Println("go heap:", repro.ReadGoHeap(cfg.GCBeforeSample))
+	fmt.Println(timing)
+	return errs, nil
 }
 
-// do a lot of selects
-func selects(db *sql.DB, maxValue int) error {
-	rows, err := db.Query("select * from t WHERE i < ?", maxValue)
-	if err != nil {
-		return err
+// dbStatusJSON is the -format json form of the aggregated db_status.
+type dbStatusJSON struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Connections   int       `json:"connections"`
+	CacheUsed     int64     `json:"cache_used"`
+	LookasideUsed int64     `json:"lookaside_used"`
+	SchemaUsed    int64     `json:"schema_used"`
+	StmtUsed      int64     `json:"stmt_used"`
+	CacheSpill    int64     `json:"cache_spill"`
+	// HighwaterSums holds each op's sum of per-connection highwaters.
+	HighwaterSums map[string]int64 `json:"highwater_sums"`
+	Global        globalStatusJSON `json:"global"`
+	// PerConn is only filled in with -per-conn.
+	PerConn []connStatusJSON `json:"per_conn,omitempty"`
+	// Timing is left out of the -duration samples.
+	Timing *timingSummary `json:"timing,omitempty"`
+	GoHeap *repro.GoHeap  `json:"go_heap,omitempty"`
+	// Allocator is only filled in with -report-allocator.
+	Allocator *allocatorStat `json:"allocator,omitempty"`
+	// MemUsedPer1kRows, the MEMORY_USED highwater per 1000 rows inserted, is
+	// only filled in at the end of a run.
+	MemUsedPer1kRows int64 `json:"memused_hw_per_1k_rows,omitempty"`
+}
+
+// globalStatusJSON is the output of repro.CollectGlobalStatus keyed by op name.
+type globalStatusJSON struct {
+	Current   map[string]int64 `json:"current"`
+	Highwater map[string]int64 `json:"highwater"`
+}
+
+func newGlobalStatusJSON(stats []repro.GlobalStat) globalStatusJSON {
+	j := globalStatusJSON{Current: make(map[string]int64), Highwater: make(map[string]int64)}
+	for _, stat := range stats {
+		j.Current[stat.Name] = stat.Current
+		j.Highwater[stat.Name] = stat.Highwater
 	}
-	defer rows.Close()
+	return j
+}
 
-	for rows.Next() {
-		var i int
-		var s string
-		if err = rows.Scan(&i, &s); err != nil {
-			return err
+// connStatusJSON is a repro.ConnStatus keyed by op name.
+type connStatusJSON struct {
+	Index     int              `json:"index"`
+	Handle    uintptr          `json:"handle"`
+	Current   map[string]int32 `json:"current"`
+	Highwater map[string]int32 `json:"highwater"`
+}
+
+func newConnStatusJSON(c repro.ConnStatus) connStatusJSON {
+	j := connStatusJSON{Index: c.Index, Handle: c.Handle, Current: make(map[string]int32), Highwater: make(map[string]int32)}
+	for i, op := range repro.DBStatusOps {
+		j.Current[repro.DBStatusOpName(op)] = c.Current[i]
+		j.Highwater[repro.DBStatusOpName(op)] = c.Highwater[i]
+	}
+	return j
+}
+
+func newDBStatusJSON(stats []repro.OpStat, connections int) dbStatusJSON {
+	j := dbStatusJSON{Timestamp: time.Now(), Connections: connections, HighwaterSums: make(map[string]int64)}
+	for _, stat := range stats {
+		j.HighwaterSums[stat.Name] = stat.HighwaterSum
+		switch stat.Op {
+		case sqlite3.SQLITE_DBSTATUS_CACHE_USED:
+			j.CacheUsed = stat.Current
+		case sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED:
+			j.LookasideUsed = stat.Current
+		case sqlite3.SQLITE_DBSTATUS_SCHEMA_USED:
+			j.SchemaUsed = stat.Current
+		case sqlite3.SQLITE_DBSTATUS_STMT_USED:
+			j.StmtUsed = stat.Current
+		case sqlite3.SQLITE_DBSTATUS_CACHE_SPILL:
+			j.CacheSpill = stat.Current
 		}
 	}
-	return nil
+	return j
 }
 
-func randomString(l int) string {
-	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, l)
-	for i := range b {
-		b[i] = chars[rand.Intn(len(chars))]
+// aggregateSqliteMemoryUsage sums the current value of every repro.DBStatusOps
+// op across conns. The reads that fail add nothing and are returned.
+func aggregateSqliteMemoryUsage(tls *libc.TLS, conns []uintptr) (map[int32]int64, []error) {
+	totalPerOp := make(map[int32]int64)
+	perConn, errs := repro.CollectConnStatus(tls, conns, 0)
+	for _, c := range perConn {
+		for i, op := range repro.DBStatusOps {
+			totalPerOp[op] += int64(c.Current[i])
+		}
 	}
-	return string(b)
+	return totalPerOp, errs
 }
 
-func preallocateCache(pageCacheSize int32) {
-	tls := libc.NewTLS()
-	if sqlite3.Xsqlite3_threadsafe(tls) == 0 {
-		panic(fmt.Errorf("sqlite: thread safety configuration error"))
+// warnStatusErrors logs the db_status and status reads that failed while
+// collecting the stats labeled label.
+func warnStatusErrors(label string, errs []error) {
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "warning: %s: %v\n", label, err)
+	}
+}
+
+// collectGlobalStatus is repro.CollectGlobalStatus warning about the reads
+// that failed as label.
+func collectGlobalStatus(tls *libc.TLS, label string) []repro.GlobalStat {
+	stats, errs := repro.CollectGlobalStatus(tls)
+	warnStatusErrors(label, errs)
+	return stats
+}
+
+// printConnStatus prints the current/highwater of every op for each
+// connection, one line per connection, for -per-conn.
+func printConnStatus(tls *libc.TLS, conns []uintptr) {
+	fmt.Println("sqlite: per-connection statuses (current/highwater):")
+	// Only called after the aggregate, which already returned the errors.
+	perConn, _ := repro.CollectConnStatus(tls, conns, 0)
+	for _, c := range perConn {
+		var b strings.Builder
+		fmt.Fprintf(&b, "conn=%d db=%#x", c.Index, c.Handle)
+		for i, op := range repro.DBStatusOps {
+			fmt.Fprintf(&b, " %s=%d/%d", repro.DBStatusOpName(op), c.Current[i], c.Highwater[i])
+		}
+		fmt.Println(b.String())
+	}
+}
+
+// configureLookaside sets the default lookaside of every connection to
+// slotCount slots of slotSize bytes, a slotCount of 0 disables it. It must run
+// before SQLite is initialized.
+func configureLookaside(tls *libc.TLS, slotSize, slotCount int32) error {
+	list := libc.NewVaList(slotSize, slotCount)
+	if list == 0 {
+		return fmt.Errorf("sqlite: configure lookaside: cannot allocate memory")
 	}
+	defer libc.Xfree(tls, list)
 
-	p := libc.Xmalloc(tls, types.Size_t(pageCacheSize))
-	if p == 0 {
-		panic(fmt.Errorf("cannot allocate memory"))
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_LOOKASIDE, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_LOOKASIDE: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
 	}
+	return nil
+}
 
+// pageCacheHeaderSize returns the bytes SQLite adds to every page of a
+// SQLITE_CONFIG_PAGECACHE slot, as SQLITE_CONFIG_PCACHE_HDRSZ reports them.
+func pageCacheHeaderSize(tls *libc.TLS) (int32, error) {
 	headerSizeMem := libc.Xmalloc(tls, 4)
 	if headerSizeMem == 0 {
-		panic(fmt.Errorf("sqlite: cannot allocate memory for header size"))
+		return 0, fmt.Errorf("sqlite: cannot allocate memory for header size")
 	}
 	defer libc.Xfree(tls, headerSizeMem)
 
@@ -296,7 +1899,7 @@ func preallocateCache(pageCacheSize int32) {
 	// SQLITE_CONFIG_PCACHE_HDRSZ expects a pointer to an int.
 	varArgs2 := libc.NewVaList(headerSizeMem)
 	if varArgs2 == 0 {
-		panic(fmt.Errorf("sqlite: get page cache header size: cannot allocate memory"))
+		return 0, fmt.Errorf("sqlite: get page cache header size: cannot allocate memory")
 	}
 	defer libc.Xfree(tls, varArgs2)
 
@@ -309,23 +1912,81 @@ func preallocateCache(pageCacheSize int32) {
 	if rc != sqlite3.SQLITE_OK {
 		p := sqlite3.Xsqlite3_errstr(tls, rc)
 		str := libc.GoString(p)
-		panic(fmt.Errorf("sqlite: failed to configure mutex methods: %v", str))
+		return 0, fmt.Errorf("sqlite: failed to get SQLITE_CONFIG_PCACHE_HDRSZ: %v", str)
 	}
 
-	headerSize := *(*int32)(unsafe.Pointer(headerSizeMem))
-	var sqlitePageSize int32 = 4096            // or your chosen SQLite page size
-	var sz int32 = sqlitePageSize + headerSize // 4104 bytes
-	var n int32 = pageCacheSize / sz           // number of cache lines
+	return *(*int32)(unsafe.Pointer(headerSizeMem)), nil
+}
+
+// pageCacheSlots returns the number of slots for pages of pageSize bytes a
+// pageCacheSize bytes SQLITE_CONFIG_PAGECACHE arena holds and the size of one.
+func pageCacheSlots(tls *libc.TLS, pageCacheSize, pageSize int32) (n, sz int32, err error) {
+	header, err := pageCacheHeaderSize(tls)
+	if err != nil {
+		return 0, 0, err
+	}
+	sz = pageSize + header // e.g. 4104 bytes for 4096 byte pages
+	return pageCacheSize / sz, sz, nil
+}
+
+// pageCacheBackings are the values -pagecache-backing accepts.
+var pageCacheBackings = []string{"libc", "go"}
+
+// pageCacheArena holds the -pagecache-backing go arena for the life of the
+// process: SQLite only keeps its address, which the garbage collector doesn't
+// see, and writes to it from outside any Go pointer.
+var pageCacheArena struct {
+	buf    []byte
+	pinner runtime.Pinner
+}
 
+// preallocateCache hands SQLite a pageCacheSize bytes arena of slots for pages
+// of pageSize bytes and returns the number of slots and the size of one. It
+// must run before SQLite is initialized.
+//
+// The arena comes from libc.Xmalloc, the allocator SQLite's own allocations
+// share and the memory.allocator counters measure, unless backing, the
+// -pagecache-backing, is go: it is then a pinned Go []byte, counted in the go
+// heap reports and left out of those counters, at the cost of a pageCacheSize
+// object the Go heap keeps for good. Either way MEMORY_USED leaves the arena
+// out, its slots show in PAGECACHE_USED.
+func preallocateCache(pageCacheSize, pageSize int32, backing string) (n, sz int32, err error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+	if sqlite3.Xsqlite3_threadsafe(tls) == 0 {
+		return 0, 0, fmt.Errorf("sqlite: thread safety configuration error")
+	}
+
+	var p uintptr
+	// release frees a libc arena SQLite didn't take.
+	release := func() {}
+	if backing == "go" {
+		pageCacheArena.buf = make([]byte, pageCacheSize)
+		pageCacheArena.pinner.Pin(&pageCacheArena.buf[0])
+		p = uintptr(unsafe.Pointer(&pageCacheArena.buf[0]))
+		release = func() { pageCacheArena.pinner.Unpin(); pageCacheArena.buf = nil }
+	} else {
+		if p = libc.Xmalloc(tls, types.Size_t(pageCacheSize)); p == 0 {
+			return 0, 0, fmt.Errorf("sqlite: preallocate page cache: cannot allocate %d bytes", pageCacheSize)
+		}
+		release = func() { libc.Xfree(tls, p) }
+	}
+
+	if n, sz, err = pageCacheSlots(tls, pageCacheSize, pageSize); err != nil {
+		release()
+		return 0, 0, err
+	}
 	list := libc.NewVaList(p, sz, n)
-	rc = sqlite3.Xsqlite3_config(
-		tls,
-		sqlite3.SQLITE_CONFIG_PAGECACHE,
-		list,
-	)
-	if rc != sqlite3.SQLITE_OK {
-		p := sqlite3.Xsqlite3_errstr(tls, rc)
-		str := libc.GoString(p)
-		panic(fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_PAGECACHE: %v", str))
+	if list == 0 {
+		release()
+		return 0, 0, fmt.Errorf("sqlite: preallocate page cache: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	// On success SQLite owns the arena for the life of the process.
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_PAGECACHE, list); rc != sqlite3.SQLITE_OK {
+		release()
+		return 0, 0, fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_PAGECACHE: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
 	}
+	return n, sz, nil
 }
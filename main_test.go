@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+func TestValidateFlags(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		// wantErr is a substring of the error, empty for none.
+		wantErr string
+	}{
+		{nil, ""},
+		{[]string{"extra"}, "unexpected arguments"},
+		{[]string{"-inserts", "0"}, "-inserts must be positive"},
+		{[]string{"-db-count", "0"}, "-db-count must be positive"},
+		{[]string{"-repeat", "3"}, ""},
+		{[]string{"-race-check"}, ""},
+		{[]string{"-repeat", "3", "-reset-interval", "1s"}, "cannot be combined with -reset-interval"},
+		{[]string{"-short-lived", "5", "-statsd", "localhost:8125"}, "cannot be combined with -reset-interval"},
+		{[]string{"-duration", "1s", "-race-check"}, "cannot be combined with -reset-interval"},
+		{[]string{"-cache-size-sweep", "100,200", "-race-check"}, "cannot be combined with -reset-interval"},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cfg := new(Config)
+			fs := newFlagSet(cfg)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			err := validateFlags(cfg, fs.Args())
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validateFlags: %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validateFlags: %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareReports(t *testing.T) {
+	cfg := repro.DefaultConfig()
+	cfg.Inserts, cfg.DBCount = 1000, 2
+	run := func(cacheUsed, memUsedHighwater int64) repro.Result {
+		return repro.Result{
+			Config: cfg,
+			Status: []repro.OpStat{{Op: sqlite3.SQLITE_DBSTATUS_CACHE_USED, Name: "CACHE_USED", Current: cacheUsed}},
+			Global: []repro.GlobalStat{{Op: sqlite3.SQLITE_STATUS_MEMORY_USED, Name: "MEMORY_USED", Highwater: memUsedHighwater}},
+		}
+	}
+	name := filepath.Join(t.TempDir(), "report.json")
+	if err := writeReport(name, run(1000, 2000)); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := readReport(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err = compareReports(&out, "a", baseline, "b", run(1050, 2000), 10); err != nil {
+		t.Errorf("5%% growth with a 10%% threshold: %v", err)
+	}
+	for _, want := range []string{"CACHE_USED", "MEMORY_USED_HIGHWATER", "MEMORY_USED_HIGHWATER_PER_1K_ROWS"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %s in\n%s", want, out.String())
+		}
+	}
+	if err = compareReports(io.Discard, "a", baseline, "b", run(1000, 3000), 10); err == nil {
+		t.Error("50% MEMORY_USED_HIGHWATER growth with a 10% threshold, want an error")
+	}
+}
+
+// TestRunPoolCloses runs with pools that close the writers' connections as
+// they are returned and checks the report still has the db_status of the
+// connections left open.
+func TestRunPoolCloses(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "report.json")
+	cfg := new(Config)
+	fs := newFlagSet(cfg)
+	if err := fs.Parse([]string{
+		"-max-idle-conns", "1", "-max-open-conns", "4",
+		"-writers", "4", "-busy-timeout", "10s", "-inserts", "400", "-db-count", "2",
+		"-temp-dir", t.TempDir(), "-report", name, "-summary",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateFlags(cfg, fs.Args()); err != nil {
+		t.Fatal(err)
+	}
+	cfg.resolve()
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := readReport(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Status) == 0 {
+		t.Fatal("no db_status in the report")
+	}
+	for _, stat := range r.Status {
+		if stat.Op == sqlite3.SQLITE_DBSTATUS_CACHE_USED && stat.Current <= 0 {
+			t.Errorf("CACHE_USED=%d, want the cache of the connections still open", stat.Current)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// metricName returns the Prometheus name of a db_status or global status op
+// name, with a _bytes suffix for the ops measured in bytes.
+func metricName(opName string, bytes bool) string {
+	name := "sqlite_" + strings.ToLower(opName)
+	if bytes {
+		name += "_bytes"
+	}
+	return name
+}
+
+// dbStatusInBytes reports whether db_status op is measured in bytes rather
+// than in slots or pages.
+func dbStatusInBytes(op int32) bool {
+	switch op {
+	case sqlite3.SQLITE_DBSTATUS_CACHE_USED, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, sqlite3.SQLITE_DBSTATUS_STMT_USED:
+		return true
+	}
+	return false
+}
+
+// globalStatusInBytes reports whether sqlite3_status op is measured in bytes.
+func globalStatusInBytes(op int32) bool {
+	switch op {
+	case sqlite3.SQLITE_STATUS_MEMORY_USED, sqlite3.SQLITE_STATUS_MALLOC_SIZE, sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW:
+		return true
+	}
+	return false
+}
+
+// serveMetrics writes the aggregated and per-connection db_status of the
+// registered connections and the global status in the Prometheus text
+// exposition format. Per-connection values are sqlite_conn_* with a
+// connection label, so summing them doesn't double count the aggregate.
+func serveMetrics(w http.ResponseWriter, r *http.Request, cfg *Config) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var conns []uintptr
+	var aggregate []repro.OpStat
+	var perConn []repro.ConnStatus
+	var errs []error
+	readLiveConns(func(live []registeredConn) {
+		conns = handles(connOrder(live, cfg))
+		aggregate, errs = repro.CollectDBStatus(tls, conns)
+		perConn, _ = repro.CollectConnStatus(tls, conns, 0)
+	})
+	warnStatusErrors("metrics", errs)
+
+	var b strings.Builder
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("sqlite_connections", "Number of registered connections.")
+	fmt.Fprintf(&b, "sqlite_connections %d\n", len(conns))
+	for _, stat := range aggregate {
+		name := metricName(stat.Name, dbStatusInBytes(stat.Op))
+		gauge(name, "db_status "+stat.Name+" summed across registered connections.")
+		fmt.Fprintf(&b, "%s %d\n", name, stat.Current)
+	}
+	for i, op := range repro.DBStatusOps {
+		name := metricName("conn_"+repro.DBStatusOpName(op), dbStatusInBytes(op))
+		gauge(name, "db_status "+repro.DBStatusOpName(op)+" of one registered connection.")
+		for _, c := range perConn {
+			fmt.Fprintf(&b, "%s{connection=\"%d\"} %d\n", name, c.Index, c.Current[i])
+		}
+	}
+	for _, stat := range collectGlobalStatus(tls, "metrics") {
+		name := metricName(stat.Name, globalStatusInBytes(stat.Op))
+		gauge(name, "sqlite3_status "+stat.Name+" current value.")
+		fmt.Fprintf(&b, "%s %d\n", name, stat.Current)
+		name = metricName(stat.Name+"_highwater", globalStatusInBytes(stat.Op))
+		gauge(name, "sqlite3_status "+stat.Name+" highwater.")
+		fmt.Fprintf(&b, "%s %d\n", name, stat.Highwater)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
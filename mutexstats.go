@@ -0,0 +1,205 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// mutexHottest is how many mutexes printMutexStats lists.
+const mutexHottest = 10
+
+// mutexStat counts the calls made on one SQLite mutex and the time its enters
+// spent before they got it.
+type mutexStat struct {
+	name                        string
+	enters, tries, busy, leaves atomic.Int64
+	waitNanos                   atomic.Int64
+}
+
+var (
+	// defaultMutex holds the mutex methods that were installed before the
+	// counting ones, modernc.org/sqlite's, which every call is delegated to.
+	defaultMutex sqlite3.Tsqlite3_mutex_methods
+
+	// mutexStats maps every mutex SQLite allocated to its *mutexStat. A
+	// freed mutex keeps its entry, a later one at the same address adds to
+	// it.
+	mutexStats sync.Map
+	// dynamicMutexes numbers the fast and recursive mutexes in the order
+	// they were allocated.
+	dynamicMutexes atomic.Int64
+)
+
+// installCountingMutexes wraps SQLite's mutex methods so that every enter,
+// try and leave is counted against its mutex, with the time each enter
+// waited. modernc.org/sqlite's fast mutexes fail every try, so the wait is
+// measured around the enter itself, which includes the call's own cost. It
+// must run before SQLite is initialized.
+func installCountingMutexes() error {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	methods := libc.Xmalloc(tls, types.Size_t(unsafe.Sizeof(sqlite3.Tsqlite3_mutex_methods{})))
+	if methods == 0 {
+		return fmt.Errorf("sqlite: install counting mutexes: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, methods)
+
+	list := libc.NewVaList(methods)
+	if list == 0 {
+		return fmt.Errorf("sqlite: install counting mutexes: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_GETMUTEX, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to get SQLITE_CONFIG_GETMUTEX: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	defaultMutex = *(*sqlite3.Tsqlite3_mutex_methods)(unsafe.Pointer(methods))
+
+	counting := defaultMutex
+	counting.FxMutexAlloc = cFuncPointer(countingMutexAlloc)
+	counting.FxMutexEnter = cFuncPointer(countingMutexEnter)
+	counting.FxMutexTry = cFuncPointer(countingMutexTry)
+	counting.FxMutexLeave = cFuncPointer(countingMutexLeave)
+	*(*sqlite3.Tsqlite3_mutex_methods)(unsafe.Pointer(methods)) = counting
+
+	// SQLite copies the struct, so methods can be freed on return.
+	list2 := libc.NewVaList(methods)
+	if list2 == 0 {
+		return fmt.Errorf("sqlite: install counting mutexes: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list2)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_MUTEX, list2); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_MUTEX: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
+
+func countingMutexAlloc(tls *libc.TLS, typ int32) uintptr {
+	m := (*(*func(*libc.TLS, int32) uintptr)(unsafe.Pointer(&struct{ uintptr }{defaultMutex.FxMutexAlloc})))(tls, typ)
+	if m == 0 {
+		return 0
+	}
+	name := mutexTypeName(typ)
+	if typ == sqlite3.SQLITE_MUTEX_FAST || typ == sqlite3.SQLITE_MUTEX_RECURSIVE {
+		name = fmt.Sprintf("%s#%d", name, dynamicMutexes.Add(1))
+	}
+	// A static mutex is allocated by every caller that needs it, the first
+	// name stays.
+	mutexStats.LoadOrStore(m, &mutexStat{name: name})
+	return m
+}
+
+func countingMutexEnter(tls *libc.TLS, m uintptr) {
+	start := time.Now()
+	(*(*func(*libc.TLS, uintptr))(unsafe.Pointer(&struct{ uintptr }{defaultMutex.FxMutexEnter})))(tls, m)
+	if s := loadMutexStat(m); s != nil {
+		s.enters.Add(1)
+		s.waitNanos.Add(int64(time.Since(start)))
+	}
+}
+
+func countingMutexTry(tls *libc.TLS, m uintptr) int32 {
+	rc := (*(*func(*libc.TLS, uintptr) int32)(unsafe.Pointer(&struct{ uintptr }{defaultMutex.FxMutexTry})))(tls, m)
+	if s := loadMutexStat(m); s != nil {
+		s.tries.Add(1)
+		if rc != sqlite3.SQLITE_OK {
+			s.busy.Add(1)
+		}
+	}
+	return rc
+}
+
+func countingMutexLeave(tls *libc.TLS, m uintptr) {
+	(*(*func(*libc.TLS, uintptr))(unsafe.Pointer(&struct{ uintptr }{defaultMutex.FxMutexLeave})))(tls, m)
+	if s := loadMutexStat(m); s != nil {
+		s.leaves.Add(1)
+	}
+}
+
+// loadMutexStat returns the mutexStat of m, nil for a mutex allocated before
+// the counting methods were installed.
+func loadMutexStat(m uintptr) *mutexStat {
+	v, ok := mutexStats.Load(m)
+	if !ok {
+		return nil
+	}
+	return v.(*mutexStat)
+}
+
+func mutexTypeName(typ int32) string {
+	switch typ {
+	case sqlite3.SQLITE_MUTEX_FAST:
+		return "fast"
+	case sqlite3.SQLITE_MUTEX_RECURSIVE:
+		return "recursive"
+	case sqlite3.SQLITE_MUTEX_STATIC_MAIN:
+		return "STATIC_MAIN"
+	case sqlite3.SQLITE_MUTEX_STATIC_MEM:
+		return "STATIC_MEM"
+	case sqlite3.SQLITE_MUTEX_STATIC_OPEN:
+		return "STATIC_OPEN"
+	case sqlite3.SQLITE_MUTEX_STATIC_PRNG:
+		return "STATIC_PRNG"
+	case sqlite3.SQLITE_MUTEX_STATIC_LRU:
+		return "STATIC_LRU"
+	case sqlite3.SQLITE_MUTEX_STATIC_PMEM:
+		return "STATIC_PMEM"
+	case sqlite3.SQLITE_MUTEX_STATIC_APP1:
+		return "STATIC_APP1"
+	case sqlite3.SQLITE_MUTEX_STATIC_APP2:
+		return "STATIC_APP2"
+	case sqlite3.SQLITE_MUTEX_STATIC_APP3:
+		return "STATIC_APP3"
+	case sqlite3.SQLITE_MUTEX_STATIC_VFS1:
+		return "STATIC_VFS1"
+	case sqlite3.SQLITE_MUTEX_STATIC_VFS2:
+		return "STATIC_VFS2"
+	case sqlite3.SQLITE_MUTEX_STATIC_VFS3:
+		return "STATIC_VFS3"
+	default:
+		return fmt.Sprintf("%v", typ)
+	}
+}
+
+// printMutexStats writes the mutexHottest mutexes whose enters waited the
+// longest, with their counts, and the totals of all of them.
+func printMutexStats(w io.Writer) error {
+	var stats []*mutexStat
+	mutexStats.Range(func(_, v any) bool {
+		stats = append(stats, v.(*mutexStat))
+		return true
+	})
+	slices.SortFunc(stats, func(a, b *mutexStat) int {
+		return cmp.Or(cmp.Compare(b.waitNanos.Load(), a.waitNanos.Load()), cmp.Compare(a.name, b.name))
+	})
+	var enters, wait int64
+	for _, s := range stats {
+		enters += s.enters.Load()
+		wait += s.waitNanos.Load()
+	}
+	fmt.Fprintf(w, "mutex-stats: mutexes=%d enters=%d waited=%v, hottest:\n", len(stats), enters, time.Duration(wait).Round(time.Microsecond))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "mutex\tenters\ttries\tbusy\tleaves\twaited\tmean wait\t")
+	for _, s := range stats[:min(mutexHottest, len(stats))] {
+		var mean time.Duration
+		if n := s.enters.Load(); n > 0 {
+			mean = time.Duration(s.waitNanos.Load() / n)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%v\t%v\t\n", s.name, s.enters.Load(), s.tries.Load(), s.busy.Load(), s.leaves.Load(),
+			time.Duration(s.waitNanos.Load()).Round(time.Microsecond), mean)
+	}
+	return tw.Flush()
+}
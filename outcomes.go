@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// dbOutcome is how one database's repro.CreateAndTestDb went under
+// -error-policy collect-all.
+type dbOutcome struct {
+	// Run counts the workload runs, more than one with -repeat, -duration
+	// or -cache-size-sweep.
+	Run int
+	DB  int
+	Err error
+}
+
+// printOutcomes writes outcomes as a table in the order the databases
+// finished, followed by the totals.
+func printOutcomes(w io.Writer, outcomes []dbOutcome) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "run\tdb\toutcome\terror")
+	failed, timedOut := 0, 0
+	for _, o := range outcomes {
+		if o.Err == nil {
+			fmt.Fprintf(tw, "%d\t%d\tok\t\n", o.Run, o.DB)
+			continue
+		}
+		failed++
+		outcome := "failed"
+		// A -query-timeout is the run bounding a slow select, not SQLite
+		// failing.
+		if errors.Is(o.Err, context.DeadlineExceeded) {
+			outcome = "timed-out"
+			timedOut++
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%v\n", o.Run, o.DB, outcome, o.Err)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "error-policy: collect-all: %d databases, %d ok, %d failed, %d of them timed out\n", len(outcomes), len(outcomes)-failed, failed, timedOut)
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// validatePageCache checks that SQLite takes its page cache from the
+// -preallocate-bytes arena of slots slots rather than from the heap. Before
+// the workload opens anything, it fills a throwaway database with about half
+// as many pages as the arena holds and, with its connection still holding
+// them, wants PAGECACHE_USED above zero and PAGECACHE_OVERFLOW at zero. Both
+// are process-wide, which is why it runs while no other connection is open.
+func validatePageCache(ctx context.Context, tls *libc.TLS, cfg *Config, slots int32) error {
+	dir, err := os.MkdirTemp(cfg.TempDir, "test-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=page_size(%d)", filepath.Join(dir, "db"), cfg.PageSize))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// One connection, so the pages stay in the cache that was filled.
+	db.SetMaxOpenConns(1)
+
+	// With its cell overhead a row of half a page fills a page of its own,
+	// the schema and the b-tree take a few more, which an arena of a
+	// handful of slots doesn't have room for either.
+	rows := max(int(slots)/2, 1)
+	value := strings.Repeat("x", cfg.PageSize/2)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "create table t(str text)"); err != nil {
+		return err
+	}
+	for i := 0; i < rows; i++ {
+		if _, err = tx.ExecContext(ctx, "insert into t values(?)", value); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	used, _, err := repro.Status(tls, sqlite3.SQLITE_STATUS_PAGECACHE_USED)
+	if err != nil {
+		return err
+	}
+	overflow, _, err := repro.Status(tls, sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("validate-prealloc: rows=%d PAGECACHE_USED=%d of %d slots PAGECACHE_OVERFLOW=%d\n", rows, used, slots, overflow)
+	switch {
+	case overflow > 0:
+		return fmt.Errorf("validate-prealloc: %d bytes of page cache spilled to the heap, increase -preallocate-bytes", overflow)
+	case used == 0:
+		return fmt.Errorf("validate-prealloc: no page cache slot is in use with %d rows written, SQLite isn't using the arena", rows)
+	}
+	return nil
+}
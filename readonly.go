@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// readOnlyWorkload opens the existing database at path from readers read-only
+// connections, each running query in a loop until interrupted, and then
+// prints every connection's CACHE_USED and their sum. Nothing is written, so
+// the numbers are the read side's steady state alone.
+func readOnlyWorkload(tls *libc.TLS, path, query string, readers int) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	// Only a file: URI keeps its query string, a plain path would drop
+	// mode=ro and open the database read-write.
+	dsn := (&url.URL{Scheme: "file", OmitHost: true, Path: path, RawQuery: "mode=ro"}).String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handles := make([]uintptr, readers)
+	errs := make(chan error, readers)
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		db, err := sql.Open("sqlite2", dsn)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if handles[i], err = repro.SQLConnHandle(conn); err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := drain(ctx, conn, query); err != nil && ctx.Err() == nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	// Runs before the deferred closes.
+	defer dropConns(0)
+	fmt.Printf("open: %s: %d readers running %q, interrupt to report\n", path, readers, query)
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt)
+	defer signal.Stop(ch)
+	select {
+	case <-ch:
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return err
+	default:
+	}
+
+	var total int64
+	for i, handle := range handles {
+		current, _, err := repro.DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+		if err != nil {
+			return fmt.Errorf("open: conn=%d: %w", i, err)
+		}
+		total += int64(current)
+		fmt.Printf("open: conn=%d CACHE_USED=%d\n", i, current)
+	}
+	fmt.Printf("open: readers=%d CACHE_USED total=%d per_conn=%d\n", readers, total, total/int64(max(readers, 1)))
+	return nil
+}
+
+// drain runs query on conn and reads every row it returns.
+func drain(ctx context.Context, conn *sql.Conn, query string) error {
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	return rows.Err()
+}
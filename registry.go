@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+
+	"modernc.org/libc"
+	"sqlite-repro/repro"
+)
+
+// registry holds the connections captured by the connection hook. It is
+// package-level so that the /sqlite/status handler can reach it.
+//
+// Handles are queried with mu held, so anything that closes registered
+// connections must drop them from conns under mu first. The pools may still
+// close connections of their own, which stay in conns and are skipped by
+// liveConns.
+var registry struct {
+	mu    sync.Mutex
+	conns []registeredConn
+	// untracked counts the connections left out by -max-tracked-conns.
+	untracked int
+	// noHandle counts the connections left out because the hook could not
+	// read their handle.
+	noHandle int
+	// opened counts the connections to each DSN, tracked or not, to number
+	// them.
+	opened map[string]int
+}
+
+// registeredConns returns the number of registered connections, to pass to
+// dropConns once the connections registered after it are to be closed.
+func registeredConns() int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return len(registry.conns)
+}
+
+// dropConns drops the connections registered after the first n from the
+// registry and returns them. Call it before closing them.
+func dropConns(n int) []registeredConn {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	dropped := slices.Clone(registry.conns[n:])
+	registry.conns = registry.conns[:n]
+	return dropped
+}
+
+// liveConns locks every registered connection still open and returns them
+// with the function unlocking them. Call it with mu held. The driver closes a
+// connection under its lock, so the handles stay valid until the unlock even
+// if a pool closes the connections meanwhile.
+func liveConns() (live []registeredConn, unlock func()) {
+	var unlocks []func()
+	for _, c := range registry.conns {
+		if _, unlockConn, ok := repro.LockConn(c.conn); ok {
+			live, unlocks = append(live, c), append(unlocks, unlockConn)
+		}
+	}
+	return live, func() {
+		for _, unlockConn := range unlocks {
+			unlockConn()
+		}
+	}
+}
+
+// readLiveConns calls read with the registered connections still open, none
+// of which can be closed before read returns.
+func readLiveConns(read func(conns []registeredConn)) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	live, unlock := liveConns()
+	defer unlock()
+	read(live)
+}
+
+// connOrder returns conns in the order per-connection output lists them: as
+// registered, which follows the goroutines the connection hook fired on, or
+// under -sort-conns by DSN and then id, which is the same from run to run for
+// the same DSNs. Databases in temp directories get random names, so across
+// such runs the ith database in name order lines up with the ith of the other
+// run, and its connections with its connections.
+func connOrder(conns []registeredConn, cfg *Config) []registeredConn {
+	if !cfg.SortConns {
+		return conns
+	}
+	sorted := slices.Clone(conns)
+	slices.SortFunc(sorted, func(a, b registeredConn) int {
+		return cmp.Or(cmp.Compare(a.dsn, b.dsn), cmp.Compare(a.id, b.id))
+	})
+	return sorted
+}
+
+// reportLeakedConns warns about every connection in conns that is still open
+// and returns how many are. Call it once everything conns were opened for has
+// been closed: a connection still open then is held by something that
+// outlived its database, along with its page cache.
+func reportLeakedConns(label string, conns []registeredConn) int {
+	leaked := 0
+	for i, c := range conns {
+		if connClosed(c.conn) {
+			continue
+		}
+		leaked++
+		fmt.Fprintf(os.Stderr, "warning: %s: connection %d db=%#x to %s still open\n", label, i, c.handle, c.dsn)
+	}
+	return leaked
+}
+
+// serveStatus writes the aggregated db_status of the registered connections
+// and the global status as JSON, in the -format json layout.
+func serveStatus(w http.ResponseWriter, r *http.Request, cfg *Config) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var j dbStatusJSON
+	var errs []error
+	readLiveConns(func(live []registeredConn) {
+		conns := handles(connOrder(live, cfg))
+		var stats []repro.OpStat
+		stats, errs = repro.CollectDBStatus(tls, conns)
+		j = newDBStatusJSON(stats, len(conns))
+		if cfg.PerConn || r.URL.Query().Has("per_conn") {
+			perConn, _ := repro.CollectConnStatus(tls, conns, 0)
+			for _, c := range perConn {
+				j.PerConn = append(j.PerConn, newConnStatusJSON(c))
+			}
+		}
+	})
+	warnStatusErrors("status", errs)
+	j.Global = newGlobalStatusJSON(collectGlobalStatus(tls, "status"))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(j); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
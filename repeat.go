@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// printRepeatTrend writes the per-run MEMORY_USED residuals and highwaters of
+// -repeat as a table, and their least-squares slopes in bytes per run. A
+// slope well above zero means every run leaves memory behind, a one-time
+// allocation only raises the first run. Each highwater is the peak of its own
+// run, one that never drops from a run to the next while the runs close their
+// handles is the leak this looks for.
+func printRepeatTrend(w io.Writer, residuals, highwaters []int64) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "run\tresidual\thighwater\t")
+	for i := range residuals {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t\n", i, residuals[i], highwaters[i])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	var series []string
+	for _, r := range residuals {
+		series = append(series, fmt.Sprint(r))
+	}
+	grows := len(highwaters) > 1 && slices.IsSorted(highwaters) && highwaters[len(highwaters)-1] > highwaters[0]
+	_, err := fmt.Fprintf(w, "repeat: runs=%d residuals=%s slope=%.1f bytes/run highwater slope=%.1f bytes/run highwater_monotonic=%t\n",
+		len(residuals), strings.Join(series, ","), slope(residuals), slope(highwaters), grows)
+	return err
+}
+
+// slope returns the least-squares slope of ys against their indices.
+func slope(ys []int64) float64 {
+	n := float64(len(ys))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range ys {
+		x := float64(i)
+		sumX += x
+		sumY += float64(y)
+		sumXY += x * float64(y)
+		sumXX += x * x
+	}
+	return (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+}
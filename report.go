@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// reportOps flattens r into the ops -diff and -compare compare, by name: the
+// aggregated db_status ops plus the process-wide MEMORY_USED highwater, that
+// highwater per 1000 rows inserted and MALLOC_COUNT.
+func reportOps(r repro.Result) map[string]int64 {
+	ops := make(map[string]int64)
+	for _, stat := range r.Status {
+		ops[stat.Name] = stat.Current
+	}
+	for _, stat := range r.Global {
+		switch stat.Op {
+		case sqlite3.SQLITE_STATUS_MEMORY_USED:
+			// Normalizing by rows makes runs with different -inserts
+			// comparable.
+			rows := int64(r.Config.Inserts) * int64(r.Config.DBCount)
+			ops["MEMORY_USED_HIGHWATER"] = stat.Highwater
+			ops["MEMORY_USED_HIGHWATER_PER_1K_ROWS"] = stat.Highwater * 1000 / max(rows, 1)
+		case sqlite3.SQLITE_STATUS_MALLOC_COUNT:
+			ops["MALLOC_COUNT"] = stat.Current
+		}
+	}
+	return ops
+}
+
+// writeReport writes r to the file name in the repro.Result JSON schema.
+func writeReport(name string, r repro.Result) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0o644)
+}
+
+// readReport reads back a file writeReport wrote. It fails on a file of any
+// other schema version.
+func readReport(name string) (repro.Result, error) {
+	var r repro.Result
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return r, err
+	}
+	if err = json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// diffReports compares the reports in files a and b with compareReports.
+func diffReports(w io.Writer, a, b string, threshold float64) error {
+	ra, err := readReport(a)
+	if err != nil {
+		return err
+	}
+	rb, err := readReport(b)
+	if err != nil {
+		return err
+	}
+	return compareReports(w, a, ra, b, rb, threshold)
+}
+
+// compareReports prints the per-op change of reportOps from run ra, named a,
+// to run rb, named b, and returns an error if any op grew by more than
+// threshold percent. A threshold of zero disables the regression check.
+// readReport has already rejected files of another schema version.
+func compareReports(w io.Writer, a string, ra repro.Result, b string, rb repro.Result, threshold float64) error {
+	opsA, opsB := reportOps(ra), reportOps(rb)
+	names := make([]string, 0, len(opsA))
+	for name := range opsA {
+		names = append(names, name)
+	}
+	for name := range opsB {
+		if _, ok := opsA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var regressed []string
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\ta\tb\tdelta\tchange\t")
+	for _, name := range names {
+		va, vb := opsA[name], opsB[name]
+		change := percentChange(va, vb)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\t%+.1f%%\t\n", name, va, vb, vb-va, change)
+		if threshold > 0 && change > threshold {
+			regressed = append(regressed, name)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(regressed) > 0 {
+		return fmt.Errorf("regressed by more than %v%%: %v", threshold, regressed)
+	}
+	return nil
+}
+
+// percentChange returns how much b differs from a as a percentage of a. Growth
+// from zero is reported as +Inf.
+func percentChange(a, b int64) float64 {
+	switch {
+	case a == b:
+		return 0
+	case a == 0:
+		return math.Inf(1)
+	default:
+		return float64(b-a) / float64(a) * 100
+	}
+}
@@ -0,0 +1,39 @@
+package repro
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+var (
+	// nextPinnedCPU hands out CPUs to pinned goroutines round robin.
+	nextPinnedCPU atomic.Int64
+	// pinnedGoroutines and pinFailures count the outcomes of PinGoroutine,
+	// only the first failure is logged.
+	pinnedGoroutines, pinFailures atomic.Int64
+)
+
+// PinGoroutine pins the calling goroutine to the next of the first
+// cfg.PinCPUs logical CPUs. The goroutine keeps its thread until it exits, at
+// which point the runtime discards the thread along with its affinity mask.
+func PinGoroutine(cfg *Config) {
+	if cfg.PinCPUs <= 0 || !CPUAffinitySupported {
+		return
+	}
+	cpu := int(nextPinnedCPU.Add(1)-1) % cfg.PinCPUs
+	if err := pinToCPU(cpu); err != nil {
+		if pinFailures.Add(1) > 1 {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "warning: cannot pin goroutine to cpu %d, running unpinned: %v\n", cpu, err)
+		return
+	}
+	pinnedGoroutines.Add(1)
+}
+
+// PinnedGoroutines returns how many goroutines PinGoroutine pinned and how
+// many it failed to.
+func PinnedGoroutines() (pinned, failed int64) {
+	return pinnedGoroutines.Load(), pinFailures.Load()
+}
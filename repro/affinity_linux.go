@@ -0,0 +1,24 @@
+//go:build linux
+
+package repro
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+const CPUAffinitySupported = true
+
+// pinToCPU locks the calling goroutine to its OS thread and restricts that
+// thread to cpu.
+func pinToCPU(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	return nil
+}
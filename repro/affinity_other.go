@@ -0,0 +1,13 @@
+//go:build !linux
+
+package repro
+
+import "errors"
+
+const CPUAffinitySupported = false
+
+// pinToCPU locks the calling goroutine to its OS thread and restricts that
+// thread to cpu.
+func pinToCPU(cpu int) error {
+	return errors.New("CPU affinity is only supported on linux")
+}
@@ -0,0 +1,58 @@
+package repro
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+
+	"modernc.org/libc"
+)
+
+// AllocPhase labels what a connection is doing when SQLite allocates, so a
+// counting allocator can attribute the bytes, see TLSAllocPhase.
+type AllocPhase int32
+
+const (
+	PhaseOther AllocPhase = iota
+	PhasePrepare
+	PhaseExec
+	PhaseCount
+)
+
+func (p AllocPhase) String() string {
+	switch p {
+	case PhasePrepare:
+		return "prepare"
+	case PhaseExec:
+		return "exec"
+	default:
+		return "other"
+	}
+}
+
+// connPhases maps the *libc.TLS of a connection to its *atomic.Int32 phase.
+// Every call a modernc.org/sqlite connection makes into SQLite uses the
+// connection's own TLS, which makes the TLS a goroutine-safe key for "the
+// connection that is allocating".
+var connPhases sync.Map
+
+// TLSAllocPhase returns the phase of the connection whose TLS is tls,
+// PhaseOther for one the inserts never labeled.
+func TLSAllocPhase(tls *libc.TLS) AllocPhase {
+	if v, ok := connPhases.Load(tls); ok {
+		return AllocPhase(v.(*atomic.Int32).Load())
+	}
+	return PhaseOther
+}
+
+// connPhase returns the phase indicator of conn and the function deleting it,
+// to call before conn is closed, after which the connection's allocations are
+// PhaseOther again. It fails if the connection's TLS can't be read.
+func connPhase(conn *sql.Conn) (*atomic.Int32, func(), error) {
+	tls, err := SQLConnTLS(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, _ := connPhases.LoadOrStore(tls, new(atomic.Int32))
+	return v.(*atomic.Int32), func() { connPhases.Delete(tls) }, nil
+}
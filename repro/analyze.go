@@ -0,0 +1,62 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// analyze runs ANALYZE on fn through one of db's connections and prints that
+// connection's SCHEMA_USED before and once the statistics are loaded, and
+// whether the plan of the selects' query with bound maxValue then uses an
+// index.
+func analyze(ctx context.Context, w io.Writer, db *sql.DB, fn string, maxValue int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	// Make sure the schema is loaded, so the baseline doesn't lack it.
+	if _, err = conn.ExecContext(ctx, "select count(*) from sqlite_schema"); err != nil {
+		return err
+	}
+	before, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "analyze"); err != nil {
+		return err
+	}
+	// ANALYZE changes the schema, the next statement reloads it along with
+	// the sqlite_stat tables.
+	if _, err = conn.ExecContext(ctx, "select count(*) from sqlite_schema"); err != nil {
+		return err
+	}
+	after, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, 0)
+	if err != nil {
+		return err
+	}
+
+	plan, err := selectsPlan(ctx, conn, maxValue)
+	if err != nil {
+		return err
+	}
+	usesIndex := slices.ContainsFunc(plan, func(detail string) bool { return strings.Contains(detail, "USING INDEX") })
+	fmt.Fprintf(w, "analyze: %s: SCHEMA_USED before=%d after=%d delta=%d uses_index=%t plan=%q\n",
+		fn, before, after, after-before, usesIndex, strings.Join(plan, "; "))
+	return nil
+}
@@ -0,0 +1,73 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// attachDatabases attaches cfg.AttachCount new databases next to fn, as aux0
+// onwards, to the connection of db that the inserts go through, and fills
+// aux0.t with a tenth of cfg.Inserts rows. It prints the connection's
+// CACHE_USED before and after, which covers the main and every attached
+// database.
+//
+// ATTACH only applies to the connection it runs on. The pool hands that one
+// idle connection back for every later statement on db, which is why
+// -attach-count can't be combined with -race-check.
+func attachDatabases(ctx context.Context, db *sql.DB, fn string, rng *rand.Rand, cfg *Config) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	before, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	for k := 0; k < cfg.AttachCount; k++ {
+		name := fmt.Sprintf("%s-aux%d", filepath.Base(fn), k)
+		if _, err = conn.ExecContext(ctx, "attach database ? as ?", filepath.Join(filepath.Dir(fn), name), fmt.Sprintf("aux%d", k)); err != nil {
+			return err
+		}
+		if _, err = conn.ExecContext(ctx, fmt.Sprintf("create table aux%d.t(i int, str text)", k)); err != nil {
+			return err
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	rows := cfg.Inserts / 10
+	for i := 0; i < rows; i++ {
+		s := randomString(rng, rng.Intn(cfg.MaxStrSize-cfg.MinStrSize)+cfg.MinStrSize)
+		if _, err = tx.ExecContext(ctx, "insert into aux0.t values(?, ?)", i, s); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	after, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cfg.out(), "attach: %s: attached=%d aux0_rows=%d CACHE_USED before=%d after=%d\n", fn, cfg.AttachCount, rows, before, after)
+	return nil
+}
@@ -0,0 +1,32 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+)
+
+// backgroundWriteBatch is the number of rows every -background-writes
+// transaction inserts.
+const backgroundWriteBatch = 10
+
+// writeInBackground keeps inserting transactions of backgroundWriteBatch rows
+// into db until ctx is done, reading the CACHE_USED of the writer connection
+// after each, and returns the rows inserted and the largest CACHE_USED read.
+func writeInBackground(ctx context.Context, db *sql.DB, rng *rand.Rand, cfg *Config) (rows int, cacheUsedMax int32, err error) {
+	batchCfg := *cfg
+	batchCfg.Inserts, batchCfg.CommitEvery, batchCfg.InsertRate = backgroundWriteBatch, backgroundWriteBatch, 0
+	for ctx.Err() == nil {
+		n, err := inserts(ctx, db, rng, &batchCfg)
+		rows += n
+		if err != nil {
+			return rows, cacheUsedMax, err
+		}
+		cacheUsed, err := poolCacheUsed(db)
+		if err != nil {
+			return rows, cacheUsedMax, err
+		}
+		cacheUsedMax = max(cacheUsedMax, cacheUsed)
+	}
+	return rows, cacheUsedMax, nil
+}
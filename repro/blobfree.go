@@ -0,0 +1,93 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// stmtMemory is the STMT_USED and CACHE_USED of a connection at one point.
+type stmtMemory struct {
+	StmtUsed  int64
+	CacheUsed int64
+}
+
+func readStmtMemory(tls *libc.TLS, handle uintptr) (stmtMemory, error) {
+	stmt, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_STMT_USED, 0)
+	if err != nil {
+		return stmtMemory{}, err
+	}
+	cache, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return stmtMemory{}, err
+	}
+	return stmtMemory{StmtUsed: int64(stmt), CacheUsed: int64(cache)}, nil
+}
+
+func (m *stmtMemory) add(o stmtMemory) {
+	m.StmtUsed += o.StmtUsed
+	m.CacheUsed += o.CacheUsed
+}
+
+// checkBlobFree runs the selects' query over every row with i below maxValue
+// on one connection of db, scanning the blobs, and returns the connection's
+// memory before the query, with the last row scanned, and after the rows are
+// closed. It returns an error if STMT_USED
+// after closing is above what it was before the query, which would mean the
+// statement kept its blob buffers.
+func checkBlobFree(db *sql.DB, maxValue int) (before, scanned, closed stmtMemory, err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return before, scanned, closed, err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return before, scanned, closed, err
+	}
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	if before, err = readStmtMemory(tls, handle); err != nil {
+		return before, scanned, closed, err
+	}
+	rows, err := conn.QueryContext(ctx, "select * from t WHERE i < ?", maxValue)
+	if err != nil {
+		return before, scanned, closed, err
+	}
+	for rows.Next() {
+		var i int
+		var s string
+		var b []byte
+		if err = rows.Scan(&i, &s, &b); err != nil {
+			rows.Close()
+			return before, scanned, closed, err
+		}
+		// The driver may finalize the statement as soon as Next finds no
+		// more rows, so read while on the row.
+		if scanned, err = readStmtMemory(tls, handle); err != nil {
+			rows.Close()
+			return before, scanned, closed, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return before, scanned, closed, err
+	}
+	if err = rows.Close(); err != nil {
+		return before, scanned, closed, err
+	}
+	if closed, err = readStmtMemory(tls, handle); err != nil {
+		return before, scanned, closed, err
+	}
+	if closed.StmtUsed > before.StmtUsed {
+		return before, scanned, closed, fmt.Errorf("verify-blob-free: STMT_USED is %d after the rows were closed, %d before the query", closed.StmtUsed, before.StmtUsed)
+	}
+	return before, scanned, closed, nil
+}
@@ -0,0 +1,87 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// CheckpointModes are the values -checkpoint-mode accepts, the modes of
+// pragma wal_checkpoint.
+var CheckpointModes = []string{"passive", "full", "restart", "truncate"}
+
+// checkpoint runs pragma wal_checkpoint(mode) on fn through one of db's
+// read-write connections and prints the busy, log and checkpointed frames it
+// answers with, and that connection's CACHE_USED and the process-wide
+// MEMORY_USED before and after.
+func checkpoint(ctx context.Context, w io.Writer, db *sql.DB, fn, mode string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	cacheBefore, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	memBefore, _, err := Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	if err != nil {
+		return err
+	}
+	var busy, log, checkpointed int
+	if err = conn.QueryRowContext(ctx, "pragma wal_checkpoint("+mode+")").Scan(&busy, &log, &checkpointed); err != nil {
+		return err
+	}
+	cacheAfter, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	memAfter, _, err := Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "checkpoint: %s: mode=%s busy=%d log=%d checkpointed=%d CACHE_USED before=%d after=%d MEMORY_USED before=%d after=%d\n",
+		fn, mode, busy, log, checkpointed, cacheBefore, cacheAfter, memBefore, memAfter)
+	return nil
+}
+
+// checkpointPeriodically runs pragma wal_checkpoint(PASSIVE) on fn through
+// one of db's read-write connections every interval until ctx is done,
+// printing the frames each answers with and the -wal file's size before and
+// after, and returns how many ran. A checkpoint ctx cuts short isn't an
+// error.
+func checkpointPeriodically(ctx context.Context, w io.Writer, db *sql.DB, fn string, interval time.Duration) (n int, err error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return n, nil
+		case <-ticker.C:
+		}
+		walBefore := fileSize(fn + "-wal")
+		var busy, log, checkpointed int
+		if err = db.QueryRowContext(ctx, "pragma wal_checkpoint(passive)").Scan(&busy, &log, &checkpointed); err != nil {
+			if ctx.Err() != nil {
+				return n, nil
+			}
+			return n, fmt.Errorf("auto-checkpoint: %s: %w", fn, err)
+		}
+		n++
+		fmt.Fprintf(w, "auto-checkpoint: %s: #%d busy=%d log=%d checkpointed=%d wal before=%d after=%d\n",
+			fn, n, busy, log, checkpointed, walBefore, fileSize(fn+"-wal"))
+	}
+}
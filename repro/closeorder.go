@@ -0,0 +1,69 @@
+package repro
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// CloseOrders are the orders -close-order closes a database's pools in.
+var CloseOrders = []string{"ro-first", "rw-first", "interleaved"}
+
+// closeOnce returns the closeFunc of fn's pools: its first call closes them
+// with closeDatabases, every later one is a no-op returning the first's
+// error, so that the signal path and the normal exit can both call it
+// without the second getting "database is closed" or closing anything twice.
+func closeOnce(w io.Writer, cfg *Config, fn string, db *sql.DB, roDbs []*sql.DB) func() error {
+	return sync.OnceValue(func() error { return closeDatabases(w, cfg, fn, db, roDbs) })
+}
+
+// closeDatabases closes the read-only pools roDbs and the read-write pool db of
+// fn in the cfg.CloseOrder order: the read-only ones and then db, db first, or
+// db between the first and the second half of the read-only ones. A failed
+// Close doesn't stop the others, the errors are joined.
+//
+// With cfg.CloseOrder set, the global MEMORY_USED is written to w after every
+// Close, so memory can be seen dropping as the handles go away.
+func closeDatabases(w io.Writer, cfg *Config, fn string, db *sql.DB, roDbs []*sql.DB) error {
+	type pool struct {
+		name string
+		db   *sql.DB
+	}
+	ro := make([]pool, len(roDbs))
+	for i, roDb := range roDbs {
+		ro[i] = pool{fmt.Sprintf("ro=%d", i), roDb}
+	}
+	rw := pool{"rw", db}
+	var order []pool
+	switch cfg.CloseOrder {
+	case "rw-first":
+		order = append([]pool{rw}, ro...)
+	case "interleaved":
+		half := len(ro) / 2
+		order = append(append(append(order, ro[:half]...), rw), ro[half:]...)
+	default:
+		order = append(ro, rw)
+	}
+
+	var before int64
+	if cfg.CloseOrder != "" {
+		WithTLS(func(tls *libc.TLS) { before, _ = ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED) })
+	}
+	var errs []error
+	for _, p := range order {
+		if err := p.db.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: close %s: %w", fn, p.name, err))
+		}
+		if cfg.CloseOrder != "" {
+			var memUsed int64
+			WithTLS(func(tls *libc.TLS) { memUsed, _ = ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED) })
+			fmt.Fprintf(w, "close-order: %s: %s closed MEMORY_USED=%d (%+d)\n", fn, p.name, memUsed, memUsed-before)
+		}
+	}
+	return errors.Join(errs...)
+}
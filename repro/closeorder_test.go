@@ -0,0 +1,44 @@
+package repro
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// TestCloseOnceTwice calls a closeFunc twice and checks the second call
+// returns no error and closes nothing: the -close-order lines, one per pool
+// closed, are only written by the first.
+func TestCloseOnceTwice(t *testing.T) {
+	db, fn := openTestDB(t)
+	roDbs := make([]*sql.DB, 2)
+	for i := range roDbs {
+		roDb, err := sql.Open("sqlite", "file:"+fn+"?mode=ro")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A pool only opens a connection when it is used.
+		if err = roDb.Ping(); err != nil {
+			t.Fatal(err)
+		}
+		roDbs[i] = roDb
+	}
+
+	var out bytes.Buffer
+	closeFunc := closeOnce(&out, &Config{CloseOrder: "ro-first"}, fn, db, roDbs)
+	if err := closeFunc(); err != nil {
+		t.Fatalf("first close: %v", err)
+	}
+	for i, p := range append([]*sql.DB{db}, roDbs...) {
+		if err := p.Ping(); err == nil {
+			t.Errorf("pool %d still open after the first close", i)
+		}
+	}
+	if err := closeFunc(); err != nil {
+		t.Errorf("second close: %v, want nil", err)
+	}
+	if n := strings.Count(out.String(), "close-order: "); n != 1+len(roDbs) {
+		t.Errorf("%d pools closed, want %d:\n%s", n, 1+len(roDbs), out.String())
+	}
+}
@@ -0,0 +1,230 @@
+package repro
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Config holds the tunables of the workload CreateAndTestDb, RunWorkload and
+// the mode loops such as RunRepeat run. main fills one in from the flags and
+// logs it, so every run's output records what it ran with.
+type Config struct {
+	Inserts     int
+	CommitEvery int
+	MinStrSize  int
+	MaxStrSize  int
+	// BlobSize 0 keeps the table to two columns.
+	BlobSize int
+	// InsertRate 0 inserts as fast as possible.
+	InsertRate      int
+	DBCount         int
+	DBWorkers       int
+	ParallelSelects int
+	// IntDistribution is sequential, random or zipf.
+	IntDistribution string
+	// Tables 1 keeps the single table t.
+	Tables int
+	// SelectSelectivity is the fraction of the rows the selects match.
+	SelectSelectivity float64
+	SelectIterations  int
+	// JournalMode and WALAutocheckpoint are already resolved against
+	// -wal-shm.
+	JournalMode       string
+	WALAutocheckpoint int
+	PageSize          int
+	// InMemory databases have no file, JournalMode is then resolved to
+	// memory unless it is off.
+	InMemory    bool
+	AttachCount int
+	// CacheSize 0 leaves cache_size at SQLite's default.
+	CacheSize int
+	// DSNParams are appended to every connection's DSN.
+	DSNParams []string
+	// BusyTimeout 0 leaves busy_timeout unset.
+	BusyTimeout time.Duration
+	// SQLFile, if set, replaces the table and the inserts with its
+	// statements, and ParallelSelects is then 0.
+	SQLFile string
+	// ROHold 0 runs the selects once.
+	ROHold time.Duration
+	// Seed 0 seeds from the clock.
+	Seed int64
+
+	// TempDir "" creates the temp directories in the system one.
+	TempDir string
+	// KeepTemp leaves up to keepTempMax databases on disk.
+	KeepTemp bool
+	// SharedCache opens every connection with cache=shared, InMemory ones
+	// always are.
+	SharedCache bool
+	// RaceCheck has every reader also select from the read-write pool.
+	RaceCheck bool
+	// SingleConn runs every database on its one read-write connection.
+	SingleConn bool
+	// Writers 1 inserts on one goroutine.
+	Writers int
+	// PinCPUs 0 leaves the workload's goroutines unpinned.
+	PinCPUs int
+	// MaxOpenConns 0, MaxIdleConns -1 and ConnMaxLifetime 0 leave the pools
+	// at database/sql's defaults.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// The pragmas of every connection, the zero value leaves SQLite's
+	// default.
+	MaxPageCount int
+	SecureDelete string
+	TempStore    string
+	MmapSize     int64
+	// AutoVacuum is set before the tables are created, incremental then
+	// frees IncrementalVacuumPages after the inserts, 0 for all of them.
+	AutoVacuum             string
+	IncrementalVacuumPages int
+
+	// The inserts, see inserts.
+	ReuseStmt      bool
+	ManualTx       bool
+	AllocPhases    bool
+	ReportStmtUsed bool
+	// RollbackRatio is the fraction of the transactions rolled back.
+	RollbackRatio float64
+
+	// The selects, see selects. ScratchBytes is the SQLITE_CONFIG_SCRATCH
+	// buffer, with it the selects sort.
+	InterruptAfter time.Duration
+	QueryTimeout   time.Duration
+	StmtStatus     bool
+	ScratchBytes   int
+	// MaxRetries 0 never retries, RetryOn holds the retryableCodes names
+	// retried.
+	MaxRetries int
+	RetryOn    []string
+
+	// What every database runs after its inserts, in this order.
+	CreateIndex            bool
+	AutoCheckpointInterval time.Duration
+	Analyze                bool
+	DeleteRatio            float64
+	CheckpointMode         string
+	VacuumAfter            bool
+
+	// What the readers do besides the selects.
+	WarmCache        bool
+	OpenCursors      int
+	VerifyBlobFree   bool
+	BackgroundWrites bool
+	VerifyMmap       bool
+
+	// What every database reports once its selects are done. WALShm is the
+	// -wal-shm the WAL mode was resolved from, 0 reports nothing.
+	WALShm         int
+	IntegrityCheck bool
+	PhaseSnapshots bool
+	// GCBeforeSample collects garbage before every read of the Go heap.
+	GCBeforeSample bool
+	// FaultInjectRate 0 injects no allocation faults, with it the inserts
+	// and selects tolerate SQLITE_NOMEM and IntegrityCheck is implied.
+	FaultInjectRate int
+	// StatusSource is cgo or pragma, pragma leaves the handles alone.
+	StatusSource string
+	// CloseOrder "" closes the read-only pools first without printing.
+	CloseOrder string
+
+	// Out receives the lines the workload prints about each database, from
+	// the goroutines of all of them at once, so it must be safe for
+	// concurrent use. nil discards them.
+	Out io.Writer
+}
+
+// DefaultConfig returns the Config of the command's default flags.
+func DefaultConfig() Config {
+	return Config{
+		Inserts:           10000,
+		CommitEvery:       100,
+		MinStrSize:        10,
+		MaxStrSize:        1000,
+		DBCount:           10,
+		ParallelSelects:   10,
+		IntDistribution:   "sequential",
+		Tables:            1,
+		SelectSelectivity: 1,
+		SelectIterations:  1,
+		JournalMode:       "delete",
+		WALAutocheckpoint: 1000,
+		PageSize:          4096,
+		Writers:           1,
+		MaxIdleConns:      -1,
+		RetryOn:           []string{"BUSY", "LOCKED", "PROTOCOL"},
+		StatusSource:      "cgo",
+	}
+}
+
+// out returns c.Out, or io.Discard if it is nil.
+func (c *Config) out() io.Writer {
+	if c.Out == nil {
+		return io.Discard
+	}
+	return c.Out
+}
+
+// validate checks what the workload can't run without. main validates every
+// field against its flag before it gets here.
+func (c *Config) validate() error {
+	switch {
+	case c.Inserts < 1:
+		return fmt.Errorf("repro: Inserts %d, want at least 1", c.Inserts)
+	case c.CommitEvery < 0:
+		return fmt.Errorf("repro: CommitEvery %d, want 0 or more", c.CommitEvery)
+	case c.MinStrSize < 0 || c.MaxStrSize <= c.MinStrSize:
+		return fmt.Errorf("repro: string sizes [%d, %d), want 0 <= MinStrSize < MaxStrSize", c.MinStrSize, c.MaxStrSize)
+	case c.DBCount < 1:
+		return fmt.Errorf("repro: DBCount %d, want at least 1", c.DBCount)
+	case c.DBWorkers < 0:
+		return fmt.Errorf("repro: DBWorkers %d, want 0 or more", c.DBWorkers)
+	case c.ParallelSelects < 0:
+		return fmt.Errorf("repro: ParallelSelects %d, want 0 or more", c.ParallelSelects)
+	case c.Tables < 1:
+		return fmt.Errorf("repro: Tables %d, want at least 1", c.Tables)
+	case c.Writers < 1:
+		return fmt.Errorf("repro: Writers %d, want at least 1", c.Writers)
+	case c.SelectSelectivity <= 0 || c.SelectSelectivity > 1:
+		return fmt.Errorf("repro: SelectSelectivity %v, want (0, 1]", c.SelectSelectivity)
+	case c.SelectIterations < 1:
+		return fmt.Errorf("repro: SelectIterations %d, want at least 1", c.SelectIterations)
+	case c.JournalMode == "":
+		return errors.New("repro: no JournalMode")
+	case c.InMemory && c.JournalMode == "wal":
+		return errors.New("repro: an InMemory database cannot use WAL")
+	case c.PageSize < 512 || c.PageSize > 65536 || c.PageSize&(c.PageSize-1) != 0:
+		return fmt.Errorf("repro: PageSize %d, want a power of two from 512 to 65536", c.PageSize)
+	}
+	return nil
+}
+
+// tableName returns the name of the k-th table: t with a single one, t0 to
+// tN-1 otherwise.
+func (c *Config) tableName(k int) string {
+	if c.Tables == 1 {
+		return "t"
+	}
+	return fmt.Sprintf("t%d", k)
+}
+
+// SelectBound returns the bound of the selects' WHERE i < ?, which matches
+// SelectSelectivity of the Inserts rows, and at least one.
+func (c *Config) SelectBound() int {
+	return max(int(math.Ceil(float64(c.Inserts)*c.SelectSelectivity)), 1)
+}
+
+// DataSeed returns the seed of the i-th database's data: Seed plus i, or a
+// clock-based seed if Seed is 0.
+func (c *Config) DataSeed(i int) int64 {
+	if c.Seed == 0 {
+		return time.Now().UnixNano() + int64(i)
+	}
+	return c.Seed + int64(i)
+}
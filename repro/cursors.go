@@ -0,0 +1,85 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// cursorRows is how far into its range every cursor opened by holdCursors
+// steps before it is left paused.
+const cursorRows = 10
+
+// holdCursors opens n cursors on one connection of db, each over a different
+// slice of i in [0, maxValue) and advanced cursorRows rows, and returns the
+// connection's CACHE_USED before opening them and while they are all open.
+// Every open statement keeps its current page referenced, so the cache can't
+// hand those pages out again.
+func holdCursors(db *sql.DB, n, maxValue int) (none, open int32, err error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	if none, _, err = DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0); err != nil {
+		return 0, 0, err
+	}
+	var cursors []*sql.Rows
+	defer func() {
+		for _, rows := range cursors {
+			if closeErr := rows.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}()
+	for k := 0; k < n; k++ {
+		rows, err := conn.QueryContext(ctx, "select * from t where i >= ?", k*maxValue/n)
+		if err != nil {
+			return 0, 0, err
+		}
+		cursors = append(cursors, rows)
+		for j := 0; j < cursorRows && rows.Next(); j++ {
+		}
+		if err = rows.Err(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if open, _, err = DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0); err != nil {
+		return 0, 0, err
+	}
+	return none, open, nil
+}
+
+// poolCacheUsed returns the CACHE_USED of the connection db hands out next,
+// which for a pool used by one goroutine at a time is the one it always
+// reuses.
+func poolCacheUsed(db *sql.DB) (int32, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return 0, err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	current, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	return current, err
+}
@@ -0,0 +1,110 @@
+package repro
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SecureDeleteModes are the values -secure-delete accepts, those of pragma
+// secure_delete.
+var SecureDeleteModes = []string{"off", "on", "fast"}
+
+// deleteRows deletes each row of table from fn with probability ratio, through
+// one of db's read-write connections, cfg.CommitEvery rows per transaction,
+// and prints the rows deleted, freelist_count and that connection's CACHE_USED
+// before and after. The deletes are retried and their tolerated errors counted
+// like the inserts', a row a tolerated error skipped stays.
+func deleteRows(ctx context.Context, db *sql.DB, fn, table string, rng *rand.Rand, ratio float64, cfg *Config) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	// The rows are picked up front, a query open on conn would hold a read
+	// transaction across the deletes' commits.
+	var rowids []int64
+	rows, err := conn.QueryContext(ctx, "select rowid from "+table)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for ; rows.Next(); total++ {
+		var rowid int64
+		if err = rows.Scan(&rowid); err != nil {
+			rows.Close()
+			return err
+		}
+		if rng.Float64() < ratio {
+			rowids = append(rowids, rowid)
+		}
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var freelistBefore, freelistAfter int
+	if err = conn.QueryRowContext(ctx, "pragma freelist_count").Scan(&freelistBefore); err != nil {
+		return err
+	}
+	cacheBefore, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	batch := cfg.CommitEvery
+	if batch <= 0 {
+		batch = len(rowids)
+	}
+	deleted := 0
+	for start := 0; start < len(rowids); start += batch {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, rowid := range rowids[start:min(start+batch, len(rowids))] {
+			var res sql.Result
+			err = retry(ctx, cfg, func() (err error) {
+				res, err = tx.ExecContext(ctx, "delete from "+table+" where rowid = ?", rowid)
+				return err
+			})
+			if err = countTolerated(err); err != nil {
+				tx.Rollback()
+				return err
+			}
+			// res is nil when a tolerated error skipped the row.
+			if res != nil {
+				n, _ := res.RowsAffected()
+				deleted += int(n)
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	cacheAfter, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	if err = conn.QueryRowContext(ctx, "pragma freelist_count").Scan(&freelistAfter); err != nil {
+		return err
+	}
+	fmt.Fprintf(cfg.out(), "delete-ratio: %s: deleted=%d of %d freelist_count before=%d after=%d CACHE_USED before=%d after=%d (-secure-delete %s)\n",
+		fn, deleted, total, freelistBefore, freelistAfter, cacheBefore, cacheAfter, cmp.Or(cfg.SecureDelete, "default"))
+	return nil
+}
@@ -0,0 +1,32 @@
+package repro
+
+import (
+	"database/sql"
+	"sync"
+
+	"modernc.org/libc"
+)
+
+// faultExempt holds the *libc.TLS of the connections whose allocations a fault
+// injector must never fail, see exemptFromFaults.
+var faultExempt sync.Map
+
+// FaultExempt reports whether the connection whose TLS is tls is spared from
+// injected allocation faults.
+func FaultExempt(tls *libc.TLS) bool {
+	_, ok := faultExempt.Load(tls)
+	return ok
+}
+
+// exemptFromFaults spares conn from any fault injector until the returned
+// function is called, so that the checks made after the faults see the
+// database as the faults left it. It fails if the connection's TLS can't be
+// read.
+func exemptFromFaults(conn *sql.Conn) (func(), error) {
+	tls, err := SQLConnTLS(conn)
+	if err != nil {
+		return nil, err
+	}
+	faultExempt.Store(tls, struct{}{})
+	return func() { faultExempt.Delete(tls) }, nil
+}
@@ -0,0 +1,59 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// dbFileSizes are the sizes in bytes of a database's files. Pages is the main
+// file's size in pages, in WAL mode without the pages still in the WAL.
+type dbFileSizes struct {
+	DB    int64
+	Pages int64
+	WAL   int64
+	SHM   int64
+}
+
+func (s dbFileSizes) String() string {
+	return fmt.Sprintf("size=%d pages=%d wal=%d shm=%d", s.DB, s.Pages, s.WAL, s.SHM)
+}
+
+// statDBFiles returns the sizes of the database file fn of pages of pageSize
+// bytes and, with wal set, of its -wal and -shm files.
+func statDBFiles(fn string, pageSize int, wal bool) dbFileSizes {
+	s := dbFileSizes{DB: fileSize(fn)}
+	s.Pages = s.DB / int64(pageSize)
+	if wal {
+		s.WAL, s.SHM = fileSize(fn+"-wal"), fileSize(fn+"-shm")
+	}
+	return s
+}
+
+// fileTotals sums the file sizes of every database once it is filled, or
+// vacuumed with -vacuum-after, see recordDBFiles.
+var fileTotals struct {
+	mu  sync.Mutex
+	dbs int
+	dbFileSizes
+}
+
+func recordDBFiles(s dbFileSizes) {
+	fileTotals.mu.Lock()
+	defer fileTotals.mu.Unlock()
+	fileTotals.dbs++
+	fileTotals.DB += s.DB
+	fileTotals.Pages += s.Pages
+	fileTotals.WAL += s.WAL
+	fileTotals.SHM += s.SHM
+}
+
+// PrintFileTotals writes the file sizes recordDBFiles summed, nothing if it
+// recorded no database.
+func PrintFileTotals(w io.Writer) {
+	fileTotals.mu.Lock()
+	defer fileTotals.mu.Unlock()
+	if fileTotals.dbs > 0 {
+		fmt.Fprintf(w, "file-size: dbs=%d total %v\n", fileTotals.dbs, fileTotals.dbFileSizes)
+	}
+}
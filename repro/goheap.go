@@ -0,0 +1,29 @@
+package repro
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// GoHeap is the part of runtime.MemStats that shows how much Go memory backs
+// what SQLite reports.
+type GoHeap struct {
+	HeapAlloc uint64 `json:"heap_alloc"`
+	HeapSys   uint64 `json:"heap_sys"`
+	HeapInuse uint64 `json:"heap_inuse"`
+}
+
+// ReadGoHeap reads the Go heap, after a collection if gc is set so that only
+// live memory is counted.
+func ReadGoHeap(gc bool) *GoHeap {
+	if gc {
+		runtime.GC()
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return &GoHeap{HeapAlloc: m.HeapAlloc, HeapSys: m.HeapSys, HeapInuse: m.HeapInuse}
+}
+
+func (h *GoHeap) String() string {
+	return fmt.Sprintf("go_heap_alloc=%d go_heap_sys=%d go_heap_inuse=%d", h.HeapAlloc, h.HeapSys, h.HeapInuse)
+}
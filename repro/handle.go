@@ -0,0 +1,121 @@
+package repro
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"modernc.org/libc"
+	"modernc.org/sqlite"
+)
+
+// ConnDBHandle returns the sqlite3* behind a modernc.org/sqlite connection,
+// which the driver keeps in the unexported db field of its conn struct. This
+// and ConnTLS are the single place that knows the layout, so a driver upgrade
+// that changes it fails here with an error naming what is missing.
+func ConnDBHandle(conn sqlite.ExecQuerierContext) (uintptr, error) {
+	f, err := connField(conn, "db")
+	if err != nil {
+		return 0, err
+	}
+	if f.Kind() != reflect.Uintptr {
+		return 0, fmt.Errorf("sqlite: %T.db is a %v, not a uintptr", conn, f.Type())
+	}
+	if f.Uint() == 0 {
+		return 0, fmt.Errorf("sqlite: %T is closed", conn)
+	}
+	return uintptr(f.Uint()), nil
+}
+
+// ConnTLS returns the TLS a modernc.org/sqlite connection calls SQLite with,
+// which the driver keeps in the unexported tls field of its conn struct.
+func ConnTLS(conn sqlite.ExecQuerierContext) (*libc.TLS, error) {
+	f, err := connField(conn, "tls")
+	if err != nil {
+		return nil, err
+	}
+	if want := reflect.TypeFor[*libc.TLS](); f.Type() != want {
+		return nil, fmt.Errorf("sqlite: %T.tls is a %v, not a %v", conn, f.Type(), want)
+	}
+	if f.IsNil() {
+		return nil, fmt.Errorf("sqlite: %T is closed", conn)
+	}
+	return (*libc.TLS)(f.UnsafePointer()), nil
+}
+
+// LockConn locks conn if it is still open and returns its handle and the
+// function unlocking it, ok is false for a closed conn. The driver closes a
+// connection under the same lock, so its pool can't free the handle before
+// the unlock.
+func LockConn(conn sqlite.ExecQuerierContext) (handle uintptr, unlock func(), ok bool) {
+	unlock = func() {}
+	if l, isLocker := conn.(sync.Locker); isLocker {
+		l.Lock()
+		unlock = l.Unlock
+	}
+	handle, err := ConnDBHandle(conn)
+	if err != nil {
+		unlock()
+		return 0, nil, false
+	}
+	return handle, unlock, true
+}
+
+// connField returns the field name of the struct conn points to.
+func connField(conn sqlite.ExecQuerierContext, name string) (reflect.Value, error) {
+	v := reflect.ValueOf(conn)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("sqlite: connection is a %T, not a pointer to a struct", conn)
+	}
+	f := v.Elem().FieldByName(name)
+	if !f.IsValid() {
+		return reflect.Value{}, fmt.Errorf("sqlite: %T has no %s field", conn, name)
+	}
+	return f, nil
+}
+
+// RawDBHandle is ConnDBHandle for the driver connection sql.Conn.Raw passes.
+func RawDBHandle(driverConn any) (uintptr, error) {
+	conn, err := rawConn(driverConn)
+	if err != nil {
+		return 0, err
+	}
+	return ConnDBHandle(conn)
+}
+
+// RawTLS is ConnTLS for the driver connection sql.Conn.Raw passes.
+func RawTLS(driverConn any) (*libc.TLS, error) {
+	conn, err := rawConn(driverConn)
+	if err != nil {
+		return nil, err
+	}
+	return ConnTLS(conn)
+}
+
+func rawConn(driverConn any) (sqlite.ExecQuerierContext, error) {
+	conn, ok := driverConn.(sqlite.ExecQuerierContext)
+	if !ok {
+		return nil, fmt.Errorf("sqlite: driver connection %T is not a modernc.org/sqlite connection", driverConn)
+	}
+	return conn, nil
+}
+
+// SQLConnHandle returns the sqlite3* behind conn, a connection of a
+// modernc.org/sqlite pool.
+func SQLConnHandle(conn *sql.Conn) (handle uintptr, err error) {
+	err = conn.Raw(func(driverConn any) (err error) {
+		handle, err = RawDBHandle(driverConn)
+		return err
+	})
+	return handle, err
+}
+
+// SQLConnTLS is SQLConnHandle for the connection's TLS.
+func SQLConnTLS(conn *sql.Conn) (tls *libc.TLS, err error) {
+	err = conn.Raw(func(driverConn any) (err error) {
+		tls, err = RawTLS(driverConn)
+		return err
+	})
+	return tls, err
+}
@@ -0,0 +1,108 @@
+package repro
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"modernc.org/libc"
+)
+
+// fakeMethods makes the fakes below modernc.org/sqlite connections as far
+// as the type assertions go, none of them is ever called.
+type fakeMethods struct{}
+
+func (fakeMethods) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (fakeMethods) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+// fakeConn has the fields of the driver's conn struct ConnDBHandle and
+// ConnTLS read.
+type fakeConn struct {
+	fakeMethods
+	db  uintptr
+	tls *libc.TLS
+}
+
+// fakeNoFields has neither of them.
+type fakeNoFields struct {
+	fakeMethods
+}
+
+// fakeWrongKinds has both with the wrong types.
+type fakeWrongKinds struct {
+	fakeMethods
+	db  string
+	tls uintptr
+}
+
+func TestConnDBHandle(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		conn    any
+		want    uintptr
+		wantErr string
+	}{
+		{"handle", &fakeConn{db: 0x1234}, 0x1234, ""},
+		{"not a pointer", fakeConn{db: 0x1234}, 0, "not a pointer to a struct"},
+		{"nil pointer", (*fakeConn)(nil), 0, "not a pointer to a struct"},
+		{"no db field", &fakeNoFields{}, 0, "has no db field"},
+		{"db of the wrong kind", &fakeWrongKinds{db: "0x1234"}, 0, "db is a string, not a uintptr"},
+		{"zero handle", &fakeConn{}, 0, "is closed"},
+		{"not a sqlite connection", struct{}{}, 0, "is not a modernc.org/sqlite connection"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RawDBHandle(tt.conn)
+			checkFieldErr(t, err, tt.wantErr)
+			if got != tt.want {
+				t.Errorf("handle %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnTLS(t *testing.T) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	for _, tt := range []struct {
+		name    string
+		conn    any
+		want    *libc.TLS
+		wantErr string
+	}{
+		{"tls", &fakeConn{tls: tls}, tls, ""},
+		{"not a pointer", fakeConn{tls: tls}, nil, "not a pointer to a struct"},
+		{"no tls field", &fakeNoFields{}, nil, "has no tls field"},
+		{"tls of the wrong type", &fakeWrongKinds{tls: 1}, nil, "tls is a uintptr, not a *libc.TLS"},
+		{"nil tls", &fakeConn{}, nil, "is closed"},
+		{"not a sqlite connection", struct{}{}, nil, "is not a modernc.org/sqlite connection"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RawTLS(tt.conn)
+			checkFieldErr(t, err, tt.wantErr)
+			if got != tt.want {
+				t.Errorf("tls %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
+
+// checkFieldErr fails t unless err contains wantErr, or is nil for an empty
+// wantErr.
+func checkFieldErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Errorf("no error, want one containing %q", wantErr)
+	case err != nil && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("error %q, want one containing %q", err, wantErr)
+	}
+}
@@ -0,0 +1,72 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// createIndex creates idx_t_i on t(i) through one of db's connections and
+// prints that connection's SCHEMA_USED before and after, and the plan of the
+// selects' query with the index in place.
+func createIndex(w io.Writer, db *sql.DB, fn string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	before, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "create index idx_t_i on t(i)"); err != nil {
+		return err
+	}
+	after, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_SCHEMA_USED, 0)
+	if err != nil {
+		return err
+	}
+
+	plan, err := selectsPlan(ctx, conn, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "create-index: %s: SCHEMA_USED before=%d after=%d delta=%d plan=%q\n",
+		fn, before, after, after-before, strings.Join(plan, "; "))
+	return nil
+}
+
+// selectsPlan returns the detail column of every row of the query plan of the
+// selects' query with bound maxValue on conn. The bound only matters once
+// ANALYZE has collected statistics.
+func selectsPlan(ctx context.Context, conn *sql.Conn, maxValue int) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, "explain query plan select * from t WHERE i < ?", maxValue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err = rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
@@ -0,0 +1,257 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// create a lot of inserts, paced to at most cfg.InsertRate when it is positive,
+// returns the number of rows committed
+func inserts(ctx context.Context, db *sql.DB, rng *rand.Rand, cfg *Config) (int, error) {
+	// Prepared before a connection is pinned, so that the pinned one is the
+	// idle connection they were prepared on.
+	var shared []*sql.Stmt
+	if cfg.ReuseStmt {
+		for k := 0; k < cfg.Tables; k++ {
+			stmt, err := db.PrepareContext(ctx, insertQuery(cfg, k))
+			if err != nil {
+				closeStmts(shared)
+				return 0, err
+			}
+			shared = append(shared, stmt)
+		}
+		defer closeStmts(shared)
+	}
+	begin := func() (txn, error) { return db.BeginTx(ctx, nil) }
+	setPhase := func(AllocPhase) {}
+	endTxn := func(rolledBack bool) {}
+	stmtsDone := func() {}
+	if cfg.AllocPhases || cfg.ManualTx || cfg.RollbackRatio > 0 || cfg.ReportStmtUsed {
+		// Pin one connection so its phase indicator can be set around
+		// Prepare and Exec, so BEGIN and COMMIT issued through Exec run
+		// on the same connection, and so the CACHE_USED read after a
+		// transaction ends is the writer's. The pool would reuse the same
+		// idle connection for every transaction anyway.
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+
+		handle, err := SQLConnHandle(conn)
+		if err != nil {
+			return 0, err
+		}
+		endTxn = func(rolledBack bool) {
+			if cfg.RollbackRatio > 0 {
+				recordTxnEnd(handle, rolledBack)
+			}
+			if cfg.ReportStmtUsed && !rolledBack {
+				recordStmtUsed(handle, &stmtUsed.afterCommit)
+			}
+		}
+		if cfg.ReportStmtUsed {
+			stmtsDone = func() { recordStmtUsed(handle, &stmtUsed.inTxn) }
+		}
+
+		if cfg.AllocPhases {
+			phase, release, err := connPhase(conn)
+			if err != nil {
+				return 0, err
+			}
+			defer release()
+			setPhase = func(p AllocPhase) { phase.Store(int32(p)) }
+		}
+		begin = func() (txn, error) { return conn.BeginTx(ctx, nil) }
+		if cfg.ManualTx {
+			begin = func() (txn, error) {
+				if _, err := conn.ExecContext(ctx, "begin"); err != nil {
+					return nil, err
+				}
+				return manualTxn{conn}, nil
+			}
+		}
+	}
+
+	// A CommitEvery of 0 keeps one transaction and statement open for all
+	// the rows.
+	batch := cfg.CommitEvery
+	if batch <= 0 {
+		batch = cfg.Inserts
+	}
+	intValue := intValues(cfg, rng)
+	// inserted counts the rows the statements reported inserting, which
+	// leaves out the ones a tolerated error skipped, and committed those
+	// of the transactions committed so far.
+	inserted, committed := 0, 0
+	start := time.Now()
+	for i := 0; i < cfg.Inserts; {
+		if err := ctx.Err(); err != nil {
+			return committed, err
+		}
+		tx, err := begin()
+		if err != nil {
+			return committed, err
+		}
+		// modernc.org/sqlite compiles the statement inside every Exec, so
+		// with this driver most statement allocations land in the exec
+		// phase and prepare stays close to zero.
+		setPhase(PhasePrepare)
+		// One statement per table, row i goes to table i % cfg.Tables.
+		stmts := make([]*sql.Stmt, 0, cfg.Tables)
+		for k := 0; k < cfg.Tables; k++ {
+			if shared != nil {
+				// Closing it leaves shared[k] prepared.
+				stmts = append(stmts, tx.Stmt(shared[k]))
+				continue
+			}
+			var stmt *sql.Stmt
+			if stmt, err = tx.Prepare(insertQuery(cfg, k)); err != nil {
+				break
+			}
+			stmts = append(stmts, stmt)
+		}
+		setPhase(PhaseOther)
+		if err != nil {
+			closeStmts(stmts)
+			tx.Rollback()
+			return committed, err
+		}
+		// Insert up to batch rows or until cfg.Inserts is reached.
+		for j := 0; j < batch && i < cfg.Inserts; j++ {
+			if cfg.InsertRate > 0 {
+				// Schedule row i relative to the start rather than the
+				// previous row so sleep overshoot doesn't accumulate.
+				select {
+				case <-ctx.Done():
+					closeStmts(stmts)
+					tx.Rollback()
+					return committed, ctx.Err()
+				case <-time.After(time.Until(start.Add(time.Duration(i) * time.Second / time.Duration(cfg.InsertRate)))):
+				}
+			}
+			l := rng.Intn(cfg.MaxStrSize-cfg.MinStrSize) + cfg.MinStrSize
+			recordStrLength(cfg, l)
+			args := []any{intValue(i), randomString(rng, l)}
+			if cfg.BlobSize > 0 {
+				b := make([]byte, cfg.BlobSize)
+				rng.Read(b)
+				args = append(args, b)
+			}
+			setPhase(PhaseExec)
+			stmt := stmts[i%cfg.Tables]
+			var res sql.Result
+			err = retry(ctx, cfg, func() (err error) {
+				res, err = stmt.ExecContext(ctx, args...)
+				return err
+			})
+			setPhase(PhaseOther)
+			if isFull(err) {
+				// Only the failed statement is rolled back, the rows
+				// before it in the transaction still commit.
+				closeStmts(stmts)
+				if tx.Commit() == nil {
+					committed = inserted
+				}
+				return committed, &fullError{Rows: committed, err: err}
+			}
+			if err = countTolerated(err); err != nil {
+				closeStmts(stmts)
+				tx.Rollback()
+				return committed, err
+			}
+			// res is nil when a tolerated error skipped the row.
+			if res != nil {
+				if n, err := res.RowsAffected(); err != nil || n != 1 {
+					closeStmts(stmts)
+					tx.Rollback()
+					return committed, fmt.Errorf("insert of row %d into %s affected %d rows, want 1 (%v)", i, cfg.tableName(i%cfg.Tables), n, err)
+				}
+				inserted++
+			}
+			i++
+		}
+		stmtsDone()
+		closeStmts(stmts)
+		if cfg.RollbackRatio > 0 && rng.Float64() < cfg.RollbackRatio {
+			if err = tx.Rollback(); err != nil {
+				return committed, err
+			}
+			inserted = committed
+			endTxn(true)
+			continue
+		}
+		if err = tx.Commit(); err != nil {
+			if isFull(err) {
+				return committed, &fullError{Rows: committed, err: err}
+			}
+			return committed, err
+		}
+		committed = inserted
+		endTxn(false)
+	}
+	return committed, nil
+}
+
+// insertQuery returns the statement inserting a row into table k of cfg.
+func insertQuery(cfg *Config, k int) string {
+	placeholders := "?, ?"
+	if cfg.BlobSize > 0 {
+		placeholders = "?, ?, ?"
+	}
+	return "insert into " + cfg.tableName(k) + " values(" + placeholders + ")"
+}
+
+func closeStmts(stmts []*sql.Stmt) {
+	for _, stmt := range stmts {
+		stmt.Close()
+	}
+}
+
+// txn is the part of *sql.Tx that inserts uses, so -manual-tx can substitute
+// BEGIN and COMMIT statements for it.
+type txn interface {
+	Prepare(query string) (*sql.Stmt, error)
+	Stmt(stmt *sql.Stmt) *sql.Stmt
+	Commit() error
+	Rollback() error
+}
+
+// manualTxn is a transaction opened with an explicit BEGIN on conn. database/sql
+// doesn't know about it, so statements prepared in it are plain connection
+// statements.
+type manualTxn struct {
+	conn *sql.Conn
+}
+
+func (t manualTxn) Prepare(query string) (*sql.Stmt, error) {
+	return t.conn.PrepareContext(context.Background(), query)
+}
+
+// Stmt returns stmt as it is, it runs on any connection of its pool. That's
+// why -reuse-stmt and -manual-tx are rejected together.
+func (t manualTxn) Stmt(stmt *sql.Stmt) *sql.Stmt {
+	return stmt
+}
+
+func (t manualTxn) Commit() error {
+	_, err := t.conn.ExecContext(context.Background(), "commit")
+	return err
+}
+
+func (t manualTxn) Rollback() error {
+	_, err := t.conn.ExecContext(context.Background(), "rollback")
+	return err
+}
+
+func randomString(rng *rand.Rand, l int) string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, l)
+	for i := range b {
+		b[i] = chars[rng.Intn(len(chars))]
+	}
+	return string(b)
+}
@@ -0,0 +1,30 @@
+package repro
+
+import (
+	"math/rand"
+)
+
+// IntDistributions are the values -int-distribution accepts.
+var IntDistributions = []string{"sequential", "random", "zipf"}
+
+// ZipfS and ZipfV are the s and v of the zipf distribution, s > 1 and v >= 1;
+// with these a handful of values take most of the rows.
+const (
+	ZipfS = 1.1
+	ZipfV = 1
+)
+
+// intValues returns the function giving the int column's value for row i of
+// cfg.Inserts under cfg.IntDistribution: i itself, or a value in
+// [0, cfg.Inserts) drawn from rng uniformly or with a zipf distribution.
+func intValues(cfg *Config, rng *rand.Rand) func(i int) int {
+	switch cfg.IntDistribution {
+	case "random":
+		return func(int) int { return rng.Intn(cfg.Inserts) }
+	case "zipf":
+		zipf := rand.NewZipf(rng, ZipfS, ZipfV, uint64(cfg.Inserts-1))
+		return func(int) int { return int(zipf.Uint64()) }
+	default:
+		return func(i int) int { return i }
+	}
+}
@@ -0,0 +1,72 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// integrityCheck runs pragma integrity_check on fn through one of db's
+// read-write connections, printing that connection's CACHE_USED before and
+// after, and fails unless the check answers ok. The fault injector spares the
+// check.
+func integrityCheck(ctx context.Context, db *sql.DB, fn string, cfg *Config) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if cfg.FaultInjectRate > 0 {
+		unexempt, err := exemptFromFaults(conn)
+		if err != nil {
+			return err
+		}
+		defer unexempt()
+	}
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	cacheBefore, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	// Every problem found is a row of its own, a sound database gives the
+	// single row ok.
+	rows, err := conn.QueryContext(ctx, "pragma integrity_check")
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err = rows.Scan(&problem); err != nil {
+			rows.Close()
+			return err
+		}
+		problems = append(problems, problem)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cacheAfter, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cfg.out(), "integrity-check: %s: CACHE_USED before=%d after=%d\n", fn, cacheBefore, cacheAfter)
+	if len(problems) != 1 || problems[0] != "ok" {
+		return fmt.Errorf("sqlite: %s: integrity_check: %s", fn, strings.Join(problems, "; "))
+	}
+	return nil
+}
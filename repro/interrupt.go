@@ -0,0 +1,89 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"modernc.org/libc"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// interruptStats holds the STMT_USED and CACHE_USED of a reader connection
+// read right after each of its selects of a kind ended.
+type interruptStats struct {
+	stmt, cache statusSummary
+}
+
+// interrupts holds the interruptStats of the selects that ran to completion
+// and of those -interrupt-after interrupted, see armInterrupt.
+var interrupts struct {
+	mu                     sync.Mutex
+	completed, interrupted interruptStats
+}
+
+// armInterrupt calls sqlite3_interrupt on conn's handle once d has passed, to
+// interrupt the select about to run on it. The returned function, called
+// with the select's error once its rows are closed, disarms it and reads the
+// connection's STMT_USED and CACHE_USED into interrupts. It returns nil in
+// place of SQLITE_INTERRUPT, an interrupted select is an outcome to measure.
+// An interrupt that fires once nothing runs on the connection is cleared like
+// one that interrupted the select.
+func armInterrupt(conn *sql.Conn, d time.Duration) (func(error) error, error) {
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return nil, err
+	}
+	fired := make(chan struct{})
+	timer := time.AfterFunc(d, func() {
+		defer close(fired)
+		WithTLS(func(tls *libc.TLS) { sqlite3.Xsqlite3_interrupt(tls, handle) })
+	})
+	return func(err error) error {
+		if !timer.Stop() {
+			<-fired
+			// SQLite only clears the interrupt once the connection starts
+			// its next statement, until then the driver takes it for a
+			// broken connection and database/sql closes it under the
+			// registry.
+			var flagged int32
+			WithTLS(func(tls *libc.TLS) { flagged = sqlite3.Xsqlite3_is_interrupted(tls, handle) })
+			if flagged != 0 {
+				if _, clearErr := conn.ExecContext(context.Background(), "select 1"); clearErr != nil {
+					fmt.Fprintf(os.Stderr, "warning: interrupt-after: clearing the interrupt: %v\n", clearErr)
+				}
+			}
+		}
+		var e *sqlite.Error
+		interrupted := errors.As(err, &e) && e.Code()&0xff == sqlite3.SQLITE_INTERRUPT
+		if err != nil && !interrupted {
+			return err
+		}
+		s := &interrupts.completed
+		if interrupted {
+			s = &interrupts.interrupted
+		}
+		recordDBStatus("interrupt-after", &interrupts.mu, &s.stmt, handle, sqlite3.SQLITE_DBSTATUS_STMT_USED)
+		recordDBStatus("interrupt-after", &interrupts.mu, &s.cache, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED)
+		return nil
+	}, nil
+}
+
+// PrintInterrupts writes the totals of interrupts.
+func PrintInterrupts(w io.Writer, cfg *Config) {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	for _, t := range []struct {
+		kind string
+		interruptStats
+	}{{"completed", interrupts.completed}, {"interrupted", interrupts.interrupted}} {
+		fmt.Fprintf(w, "interrupt-after: %s selects=%d STMT_USED mean=%d max=%d CACHE_USED mean=%d max=%d (-interrupt-after %v)\n",
+			t.kind, t.stmt.Count, t.stmt.mean(), t.stmt.Max, t.cache.mean(), t.cache.Max, cfg.InterruptAfter)
+	}
+}
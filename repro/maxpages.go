@@ -0,0 +1,44 @@
+package repro
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// fullError is the error inserts returns once the database is full, which
+// -max-page-count makes it on purpose. Rows is how many rows it committed.
+type fullError struct {
+	Rows int
+	err  error
+}
+
+func (e *fullError) Error() string { return e.err.Error() }
+
+func (e *fullError) Unwrap() error { return e.err }
+
+// isFull reports whether err is SQLITE_FULL.
+func isFull(err error) bool {
+	var e *sqlite.Error
+	return errors.As(err, &e) && e.Code()&0xff == sqlite3.SQLITE_FULL
+}
+
+// reportFull prints how many of the cfg.Inserts rows fn got before it reached
+// cfg.MaxPageCount, its page count and the CACHE_USED of db's writer
+// connection at the ceiling.
+func reportFull(db *sql.DB, fn string, full *fullError, cfg *Config) error {
+	var pages int64
+	if err := db.QueryRow("pragma page_count").Scan(&pages); err != nil {
+		return err
+	}
+	cacheUsed, err := poolCacheUsed(db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cfg.out(), "max-page-count: %s: SQLITE_FULL after %d of %d rows, page_count=%d of %d CACHE_USED=%d\n",
+		fn, full.Rows, cfg.Inserts, pages, cfg.MaxPageCount, cacheUsed)
+	return nil
+}
@@ -0,0 +1,55 @@
+package repro
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// effectiveMmapSize returns the mmap_size of the connection db hands out next,
+// which SQLite clamps to SQLITE_MAX_MMAP_SIZE without an error.
+func effectiveMmapSize(db *sql.DB) (int64, error) {
+	var size int64
+	err := db.QueryRow("pragma mmap_size").Scan(&size)
+	return size, err
+}
+
+// reportMmap prints the mmap_size the connections of database fn got from
+// -mmap-size, db's read-write one and the first of roDbs, with the CACHE_USED
+// of both kinds and the process RSS. Pages read through the mapping count in
+// the RSS but not in CACHE_USED.
+func reportMmap(db *sql.DB, roDbs []*sql.DB, fn string, cfg *Config) error {
+	rwSize, err := effectiveMmapSize(db)
+	if err != nil {
+		return err
+	}
+	rwCache, err := poolCacheUsed(db)
+	if err != nil {
+		return err
+	}
+	var roSize, roCache int64
+	for i, roDb := range roDbs {
+		if i == 0 {
+			if roSize, err = effectiveMmapSize(roDb); err != nil {
+				return err
+			}
+		}
+		cacheUsed, err := poolCacheUsed(roDb)
+		if err != nil {
+			return err
+		}
+		roCache += int64(cacheUsed)
+	}
+	fmt.Fprintf(cfg.out(), "mmap-size: %s: requested=%d effective rw=%d ro=%d enabled=%t rw_CACHE_USED=%d ro_CACHE_USED=%d %s\n",
+		fn, cfg.MmapSize, rwSize, roSize, rwSize > 0, rwCache, roCache, RSSField())
+	return nil
+}
+
+// RSSField formats the process RSS as a rss= field, saying why if it cannot
+// be read.
+func RSSField() string {
+	rss, err := ProcessRSS()
+	if err != nil {
+		return fmt.Sprintf("rss=unknown (%v)", err)
+	}
+	return fmt.Sprintf("rss=%d", rss)
+}
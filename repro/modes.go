@@ -0,0 +1,250 @@
+package repro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// ShortLivedRows is how many rows each RunShortLived database gets.
+const ShortLivedRows = 100
+
+// Loop is the caller's part of the mode loops: the workload every iteration
+// runs and the bookkeeping of the connections it opens. Only Workload must be
+// set.
+type Loop struct {
+	// Workload creates and tests an iteration's databases with cfg and
+	// returns their timings and the functions closing them, on an error too.
+	Workload func(ctx context.Context, cfg *Config) ([]PhaseTiming, []func() error, error)
+	// Track is called before an iteration's workload with the mode's name.
+	// The function it returns is called once the workload is done, before
+	// the databases are closed, and the one that returns once they are.
+	Track func(label string) (drop func() (closed func()))
+	// Conns calls read with the handles of the open connections, none of
+	// which can be closed before read returns.
+	Conns func(read func(conns []uintptr))
+	// Sample starts sampling the connections and returns the function
+	// stopping it, which returns the largest aggregated CACHE_USED sampled.
+	Sample func() (stop func() (cacheUsedPeak int64))
+	// CheckBaseline checks MEMORY_USED against baseline once an iteration's
+	// databases are closed, label names the iteration.
+	CheckBaseline func(tls *libc.TLS, baseline int64, label string) error
+}
+
+// iterate runs one iteration of the workload with cfg, calls done, if set,
+// while its databases are all still open and then closes them, whatever the
+// workload returned. label names the mode to Track and iteration the
+// iteration to CheckBaseline, which runs unless something failed. With
+// stopped set, a workload error once ctx is done is the iteration being cut
+// off rather than failing, it is dropped and cut is set.
+func (l Loop) iterate(ctx context.Context, tls *libc.TLS, cfg *Config, label, iteration string, done func(), stopped bool) (timings []PhaseTiming, cut bool, err error) {
+	baseline, _ := ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	drop := func() (closed func()) { return func() {} }
+	if l.Track != nil {
+		drop = l.Track(label)
+	}
+	timings, closeFuncs, err := l.Workload(ctx, cfg)
+	if err != nil && stopped && ctx.Err() != nil {
+		err, cut = nil, true
+	}
+	if done != nil {
+		done()
+	}
+	closed := drop()
+	errs := []error{err}
+	for _, closeFunc := range closeFuncs {
+		errs = append(errs, closeFunc())
+	}
+	closed()
+	if err := errors.Join(errs...); err != nil {
+		return timings, cut, err
+	}
+	if l.CheckBaseline != nil {
+		if err := l.CheckBaseline(tls, baseline, iteration); err != nil {
+			return timings, cut, err
+		}
+	}
+	return timings, cut, nil
+}
+
+// conns calls read with the handles of l.Conns, or with none without it.
+func (l Loop) conns(read func(conns []uintptr)) {
+	if l.Conns == nil {
+		read(nil)
+		return
+	}
+	l.Conns(read)
+}
+
+// sample starts l.Sample, if set, and returns the function stopping it.
+func (l Loop) sample() (stop func() (cacheUsedPeak int64)) {
+	if l.Sample == nil {
+		return func() int64 { return 0 }
+	}
+	return l.Sample()
+}
+
+// resetHighwaters resets the db_status highwaters of the open connections and
+// the global highwaters, so that the highwaters read next only cover what
+// happens from now on. The reads and resets that fail are warned about as
+// label.
+func (l Loop) resetHighwaters(tls *libc.TLS, label string) {
+	l.conns(func(conns []uintptr) { warnErrors(label, ResetHighwaters(tls, conns)) })
+}
+
+// ResetHighwaters resets the db_status highwaters of conns and the global
+// highwaters to their current values. The reads and resets that failed are
+// returned.
+func ResetHighwaters(tls *libc.TLS, conns []uintptr) []error {
+	_, errs := CollectConnStatus(tls, conns, 1)
+	for _, op := range GlobalStatusOps {
+		if err := ResetStatusHighwater(tls, op); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func warnErrors(label string, errs []error) {
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "warning: %s: %v\n", label, err)
+	}
+}
+
+// RunShortLived runs n iterations of a single small database, ShortLivedRows
+// rows and one reader, each closed before the next, and returns the
+// MEMORY_USED left after each above what it was before the first.
+func RunShortLived(ctx context.Context, cfg Config, n int, loop Loop) ([]int64, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	cfg.Inserts, cfg.ParallelSelects = ShortLivedRows, 1
+	start, _ := ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	residuals := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		if _, _, err := loop.iterate(ctx, tls, &cfg, "short-lived", fmt.Sprintf("short-lived db %d", i), nil, false); err != nil {
+			return residuals, err
+		}
+		memUsed, _ := ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		residuals = append(residuals, memUsed-start)
+	}
+	return residuals, nil
+}
+
+// RunDuration runs the workload over and over until d has passed, sampling
+// the connections all along, and returns the number of iterations that
+// completed. Each iteration's highwaters only cover that iteration. The
+// iteration the deadline cuts off is the normal end of the run, its databases
+// are closed like the others and its error dropped.
+func RunDuration(ctx context.Context, cfg Config, d time.Duration, loop Loop) (int, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	stop := loop.sample()
+	defer stop()
+	// The deadline also cancels the iteration in flight.
+	durationCtx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	iterations := 0
+	for durationCtx.Err() == nil {
+		loop.resetHighwaters(tls, "duration")
+		_, cut, err := loop.iterate(durationCtx, tls, &cfg, "duration", fmt.Sprintf("duration iteration %d", iterations), nil, true)
+		if err != nil {
+			return iterations, err
+		}
+		if !cut {
+			iterations++
+		}
+	}
+	return iterations, nil
+}
+
+// SweepStep is the outcome of one RunCacheSizeSweep run.
+type SweepStep struct {
+	CacheSize int
+	// CacheUsedPeak is the largest aggregated CACHE_USED sampled during the
+	// run or read at its end. SQLite keeps no highwater for CACHE_USED.
+	CacheUsedPeak int64
+	// MemUsedHighwater is the run's own, the highwaters are reset before it.
+	MemUsedHighwater int64
+	Timings          []PhaseTiming
+}
+
+// RunCacheSizeSweep runs the workload once with every cache_size of sizes, in
+// order, and returns a SweepStep for each. The connections are sampled during
+// each run and read once more after it, while they are all still open.
+func RunCacheSizeSweep(ctx context.Context, cfg Config, sizes []int, loop Loop) ([]SweepStep, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	steps := make([]SweepStep, 0, len(sizes))
+	for i, size := range sizes {
+		cfg.CacheSize = size
+		loop.resetHighwaters(tls, "cache-size-sweep")
+		step := SweepStep{CacheSize: size}
+		stop := loop.sample()
+		// The selects are done but every connection is still open, the last
+		// chance to read a cache the samples all missed.
+		done := func() {
+			step.CacheUsedPeak = stop()
+			loop.conns(func(conns []uintptr) {
+				stats, err := CollectStatus(tls, conns)
+				if err != nil {
+					warnErrors("cache-size-sweep", []error{err})
+				}
+				for _, stat := range stats {
+					if stat.Op == sqlite3.SQLITE_DBSTATUS_CACHE_USED {
+						step.CacheUsedPeak = max(step.CacheUsedPeak, stat.Current)
+					}
+				}
+			})
+			_, step.MemUsedHighwater = ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		}
+		// A residue would be counted against the next cache size.
+		timings, _, err := loop.iterate(ctx, tls, &cfg, "cache-size-sweep", fmt.Sprintf("sweep iteration %d (cache_size %d)", i, size), done, false)
+		if err != nil {
+			return steps, err
+		}
+		step.Timings = timings
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// RepeatRun is the MEMORY_USED after one RunRepeat run, the residual above
+// what it was before the first and the run's own highwater.
+type RepeatRun struct {
+	MemoryUsed int64
+	Residual   int64
+	Highwater  int64
+}
+
+// RunRepeat runs the workload n times, closing each run's databases before
+// the next, and returns a RepeatRun for each, also written to cfg.Out as it
+// completes.
+func RunRepeat(ctx context.Context, cfg Config, n int, loop Loop) ([]RepeatRun, error) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	start, _ := ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	runs := make([]RepeatRun, 0, n)
+	for r := 0; r < n; r++ {
+		// Every run's highwater is its own peak.
+		if err := ResetStatusHighwater(tls, sqlite3.SQLITE_STATUS_MEMORY_USED); err != nil {
+			warnErrors("repeat", []error{err})
+		}
+		if _, _, err := loop.iterate(ctx, tls, &cfg, "repeat", fmt.Sprintf("repeat run %d", r), nil, false); err != nil {
+			return runs, err
+		}
+		memUsed, highwater := ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		run := RepeatRun{MemoryUsed: memUsed, Residual: memUsed - start, Highwater: highwater}
+		runs = append(runs, run)
+		fmt.Fprintf(cfg.out(), "repeat: run=%d MEMORY_USED=%d residual=%d highwater=%d\n", r, run.MemoryUsed, run.Residual, run.Highwater)
+	}
+	return runs, nil
+}
@@ -0,0 +1,54 @@
+package repro
+
+import (
+	"context"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"modernc.org/libc"
+)
+
+// TestRunRepeat runs a database per run and checks every run was tracked
+// around its workload, dropped before its database was closed and checked
+// after.
+func TestRunRepeat(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.DBCount, cfg.Seed, cfg.TempDir = 100, 1, 1, t.TempDir()
+	var events []string
+	loop := Loop{
+		Workload: func(ctx context.Context, cfg *Config) ([]PhaseTiming, []func() error, error) {
+			events = append(events, "workload")
+			closeFunc, timing, err := CreateAndTestDb(ctx, cfg, Hooks{}, "", rand.New(rand.NewSource(cfg.DataSeed(0))))
+			return []PhaseTiming{timing}, []func() error{func() error {
+				events = append(events, "close")
+				return closeFunc()
+			}}, err
+		},
+		Track: func(label string) func() func() {
+			events = append(events, "track "+label)
+			return func() func() {
+				events = append(events, "drop")
+				return func() { events = append(events, "closed") }
+			}
+		},
+		CheckBaseline: func(tls *libc.TLS, baseline int64, label string) error {
+			events = append(events, label)
+			return nil
+		},
+	}
+	runs, err := RunRepeat(context.Background(), cfg, 2, loop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("%d runs, want 2", len(runs))
+	}
+	want := []string{
+		"track repeat", "workload", "drop", "close", "closed", "repeat run 0",
+		"track repeat", "workload", "drop", "close", "closed", "repeat run 1",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events %q, want %q", events, want)
+	}
+}
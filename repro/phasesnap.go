@@ -0,0 +1,68 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// phaseSnapshot is the process-wide MEMORY_USED and the Go HeapAlloc at the
+// end of one phase of a database's life.
+type phaseSnapshot struct {
+	label     string
+	sqliteMem int64
+	goHeap    uint64
+}
+
+// phaseSnapshots holds the -phase-snapshots of every database, dbs in the
+// order their first snapshot was taken in.
+var phaseSnapshots struct {
+	mu   sync.Mutex
+	dbs  []string
+	byDB map[string][]phaseSnapshot
+}
+
+// snapshotPhase appends the snapshot labelled label to fn's. MEMORY_USED is
+// process-wide, with databases created concurrently a phase's growth includes
+// the other databases'.
+func snapshotPhase(fn, label string, cfg *Config) {
+	var memUsed int64
+	WithTLS(func(tls *libc.TLS) { memUsed, _ = ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED) })
+	s := phaseSnapshot{label: label, sqliteMem: memUsed, goHeap: ReadGoHeap(cfg.GCBeforeSample).HeapAlloc}
+
+	phaseSnapshots.mu.Lock()
+	defer phaseSnapshots.mu.Unlock()
+	if phaseSnapshots.byDB == nil {
+		phaseSnapshots.byDB = make(map[string][]phaseSnapshot)
+	}
+	if _, ok := phaseSnapshots.byDB[fn]; !ok {
+		phaseSnapshots.dbs = append(phaseSnapshots.dbs, fn)
+	}
+	phaseSnapshots.byDB[fn] = append(phaseSnapshots.byDB[fn], s)
+}
+
+// PrintPhaseSnapshots writes every database's snapshots as a table, each with
+// how much MEMORY_USED and HeapAlloc grew since the database's previous one.
+func PrintPhaseSnapshots(w io.Writer) error {
+	phaseSnapshots.mu.Lock()
+	defer phaseSnapshots.mu.Unlock()
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "db\tphase\tMEMORY_USED\tgrowth\tgo_heap_alloc\tgrowth\t")
+	for _, fn := range phaseSnapshots.dbs {
+		var prev phaseSnapshot
+		for i, s := range phaseSnapshots.byDB[fn] {
+			memGrowth, heapGrowth := "", ""
+			if i > 0 {
+				memGrowth = fmt.Sprintf("%+d", s.sqliteMem-prev.sqliteMem)
+				heapGrowth = fmt.Sprintf("%+d", int64(s.goHeap)-int64(prev.goHeap))
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\t\n", fn, s.label, s.sqliteMem, memGrowth, s.goHeap, heapGrowth)
+			prev = s
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,81 @@
+package repro
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// poolStats sums the sql.DBStats of a kind of pool across the databases.
+type poolStats struct {
+	Pools int
+	// Opened counts every connection the pools opened, the ones they have
+	// closed since included.
+	Opened int64
+	// Open is what the pools held once the selects were done.
+	Open           int64
+	IdleClosed     int64
+	LifetimeClosed int64
+	Waits          int64
+}
+
+func (p *poolStats) add(s sql.DBStats) {
+	closed := s.MaxIdleClosed + s.MaxIdleTimeClosed + s.MaxLifetimeClosed
+	p.Pools++
+	p.Opened += int64(s.OpenConnections) + closed
+	p.Open += int64(s.OpenConnections)
+	p.IdleClosed += s.MaxIdleClosed + s.MaxIdleTimeClosed
+	p.LifetimeClosed += s.MaxLifetimeClosed
+	p.Waits += s.WaitCount
+}
+
+// pools sums the stats of the read-write pool and of the read-only pools of
+// every database, see recordPoolStats.
+var pools struct {
+	mu     sync.Mutex
+	rw, ro poolStats
+}
+
+// configurePool applies cfg.MaxOpenConns, cfg.MaxIdleConns and
+// cfg.ConnMaxLifetime to db.
+func configurePool(db *sql.DB, cfg *Config) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns >= 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+}
+
+// PoolConfigured reports whether any of the pool settings is.
+func (c *Config) PoolConfigured() bool {
+	return c.MaxOpenConns > 0 || c.MaxIdleConns >= 0 || c.ConnMaxLifetime > 0
+}
+
+// recordPoolStats adds the stats of a database's read-write pool rw and
+// read-only pools ro to pools.
+func recordPoolStats(rw *sql.DB, ro []*sql.DB) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	pools.rw.add(rw.Stats())
+	for _, db := range ro {
+		pools.ro.add(db.Stats())
+	}
+}
+
+// PrintPoolStats writes the totals of pools.
+func PrintPoolStats(w io.Writer, cfg *Config) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	for _, p := range []struct {
+		kind string
+		poolStats
+	}{{"rw", pools.rw}, {"ro", pools.ro}} {
+		fmt.Fprintf(w, "pool: %s: pools=%d opened=%d open=%d closed_idle=%d closed_lifetime=%d waits=%d (-max-open-conns %d, -max-idle-conns %d, -conn-max-lifetime %v)\n",
+			p.kind, p.Pools, p.Opened, p.Open, p.IdleClosed, p.LifetimeClosed, p.Waits, cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxLifetime)
+	}
+}
@@ -0,0 +1,109 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// StatusSources are the values -status-source accepts: cgo reads
+// sqlite3_db_status through every connection's handle, pragma asks each
+// database through SQL and never touches a handle.
+var StatusSources = []string{"cgo", "pragma"}
+
+// pragmaStatus is what -status-source pragma learns of one database through
+// SQL. None of it is db_status: the cache bound is what cache_size lets a
+// connection's page cache grow to, not what it holds.
+type pragmaStatus struct {
+	PageCount     int64
+	PageSize      int64
+	CacheSize     int64
+	FreelistCount int64
+	// DBStatBytes sums the pages of the dbstat virtual table, zero with
+	// DBStat false when SQLite was built without it.
+	DBStat      bool
+	DBStatBytes int64
+}
+
+// cacheBound returns the bytes a connection's page cache is allowed to reach,
+// at most the whole database.
+func (s pragmaStatus) cacheBound() int64 {
+	bound := s.CacheSize * s.PageSize
+	if s.CacheSize < 0 {
+		// A negative cache_size is a size in KiB.
+		bound = -s.CacheSize * 1024
+	}
+	return min(bound, s.PageCount*s.PageSize)
+}
+
+// readPragmaStatus queries the pragma status of the database behind db on
+// one of its connections.
+func readPragmaStatus(ctx context.Context, db *sql.DB) (pragmaStatus, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return pragmaStatus{}, err
+	}
+	defer conn.Close()
+
+	var s pragmaStatus
+	for _, p := range []struct {
+		pragma string
+		dest   *int64
+	}{
+		{"page_count", &s.PageCount},
+		{"page_size", &s.PageSize},
+		{"cache_size", &s.CacheSize},
+		{"freelist_count", &s.FreelistCount},
+	} {
+		if err = conn.QueryRowContext(ctx, "pragma "+p.pragma).Scan(p.dest); err != nil {
+			return pragmaStatus{}, fmt.Errorf("pragma %s: %w", p.pragma, err)
+		}
+	}
+	// Without SQLITE_ENABLE_DBSTAT_VTAB there is no such table.
+	if err = conn.QueryRowContext(ctx, "select coalesce(sum(pgsize), 0) from dbstat").Scan(&s.DBStatBytes); err == nil {
+		s.DBStat = true
+	}
+	return s, nil
+}
+
+// pragmaTotals sums the pragmaStatus of every database, with cacheBounds the
+// cache bound of each times its connections.
+var pragmaTotals struct {
+	mu                                  sync.Mutex
+	dbs, dbstatDBs                      int
+	pageBytes, freelistPages, dbstatSum int64
+	cacheBounds                         int64
+}
+
+// recordPragmaStatus reads the pragma status of fn through db, prints it and
+// adds it to pragmaTotals, conns being the connections open to fn.
+func recordPragmaStatus(ctx context.Context, w io.Writer, db *sql.DB, fn string, conns int) error {
+	s, err := readPragmaStatus(ctx, db)
+	if err != nil {
+		return fmt.Errorf("%s: status-source pragma: %w", fn, err)
+	}
+	fmt.Fprintf(w, "status-source: pragma: %s: page_count=%d page_size=%d cache_size=%d freelist_count=%d cache_bound=%d dbstat=%t dbstat_bytes=%d\n",
+		fn, s.PageCount, s.PageSize, s.CacheSize, s.FreelistCount, s.cacheBound(), s.DBStat, s.DBStatBytes)
+	pragmaTotals.mu.Lock()
+	defer pragmaTotals.mu.Unlock()
+	pragmaTotals.dbs++
+	pragmaTotals.pageBytes += s.PageCount * s.PageSize
+	pragmaTotals.freelistPages += s.FreelistCount
+	pragmaTotals.cacheBounds += s.cacheBound() * int64(conns)
+	if s.DBStat {
+		pragmaTotals.dbstatDBs++
+		pragmaTotals.dbstatSum += s.DBStatBytes
+	}
+	return nil
+}
+
+// PrintPragmaStatus writes pragmaTotals, in place of the db_status aggregate
+// under -status-source pragma.
+func PrintPragmaStatus(w io.Writer) {
+	pragmaTotals.mu.Lock()
+	defer pragmaTotals.mu.Unlock()
+	fmt.Fprintf(w, "sqlite: status source: pragma, approximate and not comparable to db_status: dbs=%d database_bytes=%d freelist_pages=%d cache_bound=%d dbstat_bytes=%d (%d of %d dbs)\n",
+		pragmaTotals.dbs, pragmaTotals.pageBytes, pragmaTotals.freelistPages, pragmaTotals.cacheBounds, pragmaTotals.dbstatSum, pragmaTotals.dbstatDBs, pragmaTotals.dbs)
+}
@@ -0,0 +1,41 @@
+package repro
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// timedSelects counts the selects run under -query-timeout and
+// queryTimeouts those of them that ran out of it.
+var timedSelects, queryTimeouts atomic.Int64
+
+// queryDeadline returns a context ending d from now at the latest, for one
+// select, and the function to call with the select's error once its rows are
+// closed. That function returns an error wrapping context.DeadlineExceeded,
+// and counts it in queryTimeouts, if the select ran out of d, whatever the
+// driver returned for it: the interrupt that stops the statement can surface
+// as SQLITE_INTERRUPT. The connection stays usable, the interrupt only ends
+// the statement. An end of ctx itself is left to the caller.
+func queryDeadline(ctx context.Context, d time.Duration) (context.Context, func(error) error) {
+	timedSelects.Add(1)
+	qctx, cancel := context.WithTimeout(ctx, d)
+	return qctx, func(err error) error {
+		defer cancel()
+		if err == nil || ctx.Err() != nil || qctx.Err() != context.DeadlineExceeded {
+			return err
+		}
+		queryTimeouts.Add(1)
+		return fmt.Errorf("select: -query-timeout %v: %w", d, context.DeadlineExceeded)
+	}
+}
+
+// PrintQueryTimeouts writes how many of the selects timed out.
+func PrintQueryTimeouts(w io.Writer, cfg *Config) {
+	fmt.Fprintf(w, "query-timeout: %d of %d selects timed out (-query-timeout %v)\n", queryTimeouts.Load(), timedSelects.Load(), cfg.QueryTimeout)
+}
+
+// QueryTimeouts returns how many selects ran out of QueryTimeout.
+func QueryTimeouts() int64 { return queryTimeouts.Load() }
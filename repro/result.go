@@ -0,0 +1,327 @@
+package repro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON schema of a Result. It changes
+// whenever a field is renamed, removed or changes meaning, not when one is
+// added. 3 is the first Result -report writes, its files of version 2 had a
+// schema of their own.
+const SchemaVersion = 3
+
+// resultJSON is the JSON schema of a Result, versioned by SchemaVersion so
+// that tools reading it depend on the schema rather than on Result.
+type resultJSON struct {
+	SchemaVersion int               `json:"schema_version"`
+	Config        configJSON        `json:"config"`
+	Timings       []phaseTimingJSON `json:"timings"`
+	Status        []opStatJSON      `json:"status"`
+	Global        []globalStatJSON  `json:"global"`
+	MemoryUsed    int64             `json:"memory_used"`
+	Errors        []string          `json:"errors"`
+}
+
+// configJSON is a Config with its durations in nanoseconds.
+type configJSON struct {
+	Inserts                  int      `json:"inserts"`
+	CommitEvery              int      `json:"commit_every"`
+	MinStrSize               int      `json:"min_str_size"`
+	MaxStrSize               int      `json:"max_str_size"`
+	BlobSize                 int      `json:"blob_size"`
+	InsertRate               int      `json:"insert_rate"`
+	DBCount                  int      `json:"db_count"`
+	DBWorkers                int      `json:"db_workers"`
+	ParallelSelects          int      `json:"parallel_selects"`
+	IntDistribution          string   `json:"int_distribution"`
+	Tables                   int      `json:"tables"`
+	SelectSelectivity        float64  `json:"select_selectivity"`
+	SelectIterations         int      `json:"select_iterations"`
+	JournalMode              string   `json:"journal_mode"`
+	WALAutocheckpoint        int      `json:"wal_autocheckpoint"`
+	PageSize                 int      `json:"page_size"`
+	InMemory                 bool     `json:"in_memory"`
+	AttachCount              int      `json:"attach_count"`
+	CacheSize                int      `json:"cache_size"`
+	DSNParams                []string `json:"dsn_params"`
+	BusyTimeoutNs            int64    `json:"busy_timeout_ns"`
+	SQLFile                  string   `json:"sql_file"`
+	ROHoldNs                 int64    `json:"ro_hold_ns"`
+	Seed                     int64    `json:"seed"`
+	TempDir                  string   `json:"temp_dir"`
+	KeepTemp                 bool     `json:"keep_temp"`
+	SharedCache              bool     `json:"shared_cache"`
+	RaceCheck                bool     `json:"race_check"`
+	SingleConn               bool     `json:"single_conn"`
+	Writers                  int      `json:"writers"`
+	PinCPUs                  int      `json:"pin_cpus"`
+	MaxOpenConns             int      `json:"max_open_conns"`
+	MaxIdleConns             int      `json:"max_idle_conns"`
+	ConnMaxLifetimeNs        int64    `json:"conn_max_lifetime_ns"`
+	MaxPageCount             int      `json:"max_page_count"`
+	SecureDelete             string   `json:"secure_delete"`
+	TempStore                string   `json:"temp_store"`
+	MmapSize                 int64    `json:"mmap_size"`
+	AutoVacuum               string   `json:"auto_vacuum"`
+	IncrementalVacuumPages   int      `json:"incremental_vacuum_pages"`
+	ReuseStmt                bool     `json:"reuse_stmt"`
+	ManualTx                 bool     `json:"manual_tx"`
+	AllocPhases              bool     `json:"alloc_phases"`
+	ReportStmtUsed           bool     `json:"report_stmt_used"`
+	RollbackRatio            float64  `json:"rollback_ratio"`
+	InterruptAfterNs         int64    `json:"interrupt_after_ns"`
+	QueryTimeoutNs           int64    `json:"query_timeout_ns"`
+	StmtStatus               bool     `json:"stmt_status"`
+	ScratchBytes             int      `json:"scratch_bytes"`
+	MaxRetries               int      `json:"max_retries"`
+	RetryOn                  []string `json:"retry_on"`
+	CreateIndex              bool     `json:"create_index"`
+	AutoCheckpointIntervalNs int64    `json:"auto_checkpoint_interval_ns"`
+	Analyze                  bool     `json:"analyze"`
+	DeleteRatio              float64  `json:"delete_ratio"`
+	CheckpointMode           string   `json:"checkpoint_mode"`
+	VacuumAfter              bool     `json:"vacuum_after"`
+	WarmCache                bool     `json:"warm_cache"`
+	OpenCursors              int      `json:"open_cursors"`
+	VerifyBlobFree           bool     `json:"verify_blob_free"`
+	BackgroundWrites         bool     `json:"background_writes"`
+	VerifyMmap               bool     `json:"verify_mmap"`
+	WALShm                   int      `json:"wal_shm"`
+	IntegrityCheck           bool     `json:"integrity_check"`
+	PhaseSnapshots           bool     `json:"phase_snapshots"`
+	GCBeforeSample           bool     `json:"gc_before_sample"`
+	FaultInjectRate          int      `json:"fault_inject_rate"`
+	StatusSource             string   `json:"status_source"`
+	CloseOrder               string   `json:"close_order"`
+}
+
+func newConfigJSON(c Config) configJSON {
+	return configJSON{
+		Inserts:                  c.Inserts,
+		CommitEvery:              c.CommitEvery,
+		MinStrSize:               c.MinStrSize,
+		MaxStrSize:               c.MaxStrSize,
+		BlobSize:                 c.BlobSize,
+		InsertRate:               c.InsertRate,
+		DBCount:                  c.DBCount,
+		DBWorkers:                c.DBWorkers,
+		ParallelSelects:          c.ParallelSelects,
+		IntDistribution:          c.IntDistribution,
+		Tables:                   c.Tables,
+		SelectSelectivity:        c.SelectSelectivity,
+		SelectIterations:         c.SelectIterations,
+		JournalMode:              c.JournalMode,
+		WALAutocheckpoint:        c.WALAutocheckpoint,
+		PageSize:                 c.PageSize,
+		InMemory:                 c.InMemory,
+		AttachCount:              c.AttachCount,
+		CacheSize:                c.CacheSize,
+		DSNParams:                c.DSNParams,
+		BusyTimeoutNs:            int64(c.BusyTimeout),
+		SQLFile:                  c.SQLFile,
+		ROHoldNs:                 int64(c.ROHold),
+		Seed:                     c.Seed,
+		TempDir:                  c.TempDir,
+		KeepTemp:                 c.KeepTemp,
+		SharedCache:              c.SharedCache,
+		RaceCheck:                c.RaceCheck,
+		SingleConn:               c.SingleConn,
+		Writers:                  c.Writers,
+		PinCPUs:                  c.PinCPUs,
+		MaxOpenConns:             c.MaxOpenConns,
+		MaxIdleConns:             c.MaxIdleConns,
+		ConnMaxLifetimeNs:        int64(c.ConnMaxLifetime),
+		MaxPageCount:             c.MaxPageCount,
+		SecureDelete:             c.SecureDelete,
+		TempStore:                c.TempStore,
+		MmapSize:                 c.MmapSize,
+		AutoVacuum:               c.AutoVacuum,
+		IncrementalVacuumPages:   c.IncrementalVacuumPages,
+		ReuseStmt:                c.ReuseStmt,
+		ManualTx:                 c.ManualTx,
+		AllocPhases:              c.AllocPhases,
+		ReportStmtUsed:           c.ReportStmtUsed,
+		RollbackRatio:            c.RollbackRatio,
+		InterruptAfterNs:         int64(c.InterruptAfter),
+		QueryTimeoutNs:           int64(c.QueryTimeout),
+		StmtStatus:               c.StmtStatus,
+		ScratchBytes:             c.ScratchBytes,
+		MaxRetries:               c.MaxRetries,
+		RetryOn:                  c.RetryOn,
+		CreateIndex:              c.CreateIndex,
+		AutoCheckpointIntervalNs: int64(c.AutoCheckpointInterval),
+		Analyze:                  c.Analyze,
+		DeleteRatio:              c.DeleteRatio,
+		CheckpointMode:           c.CheckpointMode,
+		VacuumAfter:              c.VacuumAfter,
+		WarmCache:                c.WarmCache,
+		OpenCursors:              c.OpenCursors,
+		VerifyBlobFree:           c.VerifyBlobFree,
+		BackgroundWrites:         c.BackgroundWrites,
+		VerifyMmap:               c.VerifyMmap,
+		WALShm:                   c.WALShm,
+		IntegrityCheck:           c.IntegrityCheck,
+		PhaseSnapshots:           c.PhaseSnapshots,
+		GCBeforeSample:           c.GCBeforeSample,
+		FaultInjectRate:          c.FaultInjectRate,
+		StatusSource:             c.StatusSource,
+		CloseOrder:               c.CloseOrder,
+	}
+}
+
+func (c configJSON) config() Config {
+	return Config{
+		Inserts:                c.Inserts,
+		CommitEvery:            c.CommitEvery,
+		MinStrSize:             c.MinStrSize,
+		MaxStrSize:             c.MaxStrSize,
+		BlobSize:               c.BlobSize,
+		InsertRate:             c.InsertRate,
+		DBCount:                c.DBCount,
+		DBWorkers:              c.DBWorkers,
+		ParallelSelects:        c.ParallelSelects,
+		IntDistribution:        c.IntDistribution,
+		Tables:                 c.Tables,
+		SelectSelectivity:      c.SelectSelectivity,
+		SelectIterations:       c.SelectIterations,
+		JournalMode:            c.JournalMode,
+		WALAutocheckpoint:      c.WALAutocheckpoint,
+		PageSize:               c.PageSize,
+		InMemory:               c.InMemory,
+		AttachCount:            c.AttachCount,
+		CacheSize:              c.CacheSize,
+		DSNParams:              c.DSNParams,
+		BusyTimeout:            time.Duration(c.BusyTimeoutNs),
+		SQLFile:                c.SQLFile,
+		ROHold:                 time.Duration(c.ROHoldNs),
+		Seed:                   c.Seed,
+		TempDir:                c.TempDir,
+		KeepTemp:               c.KeepTemp,
+		SharedCache:            c.SharedCache,
+		RaceCheck:              c.RaceCheck,
+		SingleConn:             c.SingleConn,
+		Writers:                c.Writers,
+		PinCPUs:                c.PinCPUs,
+		MaxOpenConns:           c.MaxOpenConns,
+		MaxIdleConns:           c.MaxIdleConns,
+		ConnMaxLifetime:        time.Duration(c.ConnMaxLifetimeNs),
+		MaxPageCount:           c.MaxPageCount,
+		SecureDelete:           c.SecureDelete,
+		TempStore:              c.TempStore,
+		MmapSize:               c.MmapSize,
+		AutoVacuum:             c.AutoVacuum,
+		IncrementalVacuumPages: c.IncrementalVacuumPages,
+		ReuseStmt:              c.ReuseStmt,
+		ManualTx:               c.ManualTx,
+		AllocPhases:            c.AllocPhases,
+		ReportStmtUsed:         c.ReportStmtUsed,
+		RollbackRatio:          c.RollbackRatio,
+		InterruptAfter:         time.Duration(c.InterruptAfterNs),
+		QueryTimeout:           time.Duration(c.QueryTimeoutNs),
+		StmtStatus:             c.StmtStatus,
+		ScratchBytes:           c.ScratchBytes,
+		MaxRetries:             c.MaxRetries,
+		RetryOn:                c.RetryOn,
+		CreateIndex:            c.CreateIndex,
+		AutoCheckpointInterval: time.Duration(c.AutoCheckpointIntervalNs),
+		Analyze:                c.Analyze,
+		DeleteRatio:            c.DeleteRatio,
+		CheckpointMode:         c.CheckpointMode,
+		VacuumAfter:            c.VacuumAfter,
+		WarmCache:              c.WarmCache,
+		OpenCursors:            c.OpenCursors,
+		VerifyBlobFree:         c.VerifyBlobFree,
+		BackgroundWrites:       c.BackgroundWrites,
+		VerifyMmap:             c.VerifyMmap,
+		WALShm:                 c.WALShm,
+		IntegrityCheck:         c.IntegrityCheck,
+		PhaseSnapshots:         c.PhaseSnapshots,
+		GCBeforeSample:         c.GCBeforeSample,
+		FaultInjectRate:        c.FaultInjectRate,
+		StatusSource:           c.StatusSource,
+		CloseOrder:             c.CloseOrder,
+	}
+}
+
+// phaseTimingJSON has the durations of a PhaseTiming in nanoseconds.
+type phaseTimingJSON struct {
+	InsertsNs  int64 `json:"inserts_ns"`
+	InsertRows int   `json:"insert_rows"`
+	SelectsNs  int64 `json:"selects_ns"`
+	SelectRows int   `json:"select_rows"`
+}
+
+type opStatJSON struct {
+	Op           int32  `json:"op"`
+	Name         string `json:"name"`
+	Current      int64  `json:"current"`
+	HighwaterSum int64  `json:"highwater_sum"`
+}
+
+type globalStatJSON struct {
+	Op        int32  `json:"op"`
+	Name      string `json:"name"`
+	Current   int64  `json:"current"`
+	Highwater int64  `json:"highwater"`
+}
+
+// MarshalJSON encodes r in the SchemaVersion schema. The Errors are encoded
+// as their messages.
+func (r Result) MarshalJSON() ([]byte, error) {
+	v := resultJSON{
+		SchemaVersion: SchemaVersion,
+		Config:        newConfigJSON(r.Config),
+		Timings:       make([]phaseTimingJSON, len(r.Timings)),
+		Status:        make([]opStatJSON, len(r.Status)),
+		Global:        make([]globalStatJSON, len(r.Global)),
+		MemoryUsed:    r.MemoryUsed,
+		Errors:        make([]string, len(r.Errors)),
+	}
+	for i, t := range r.Timings {
+		v.Timings[i] = phaseTimingJSON{int64(t.Inserts), t.InsertRows, int64(t.Selects), t.SelectRows}
+	}
+	for i, s := range r.Status {
+		v.Status[i] = opStatJSON(s)
+	}
+	for i, s := range r.Global {
+		v.Global[i] = globalStatJSON(s)
+	}
+	for i, err := range r.Errors {
+		v.Errors[i] = err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes what MarshalJSON encoded, the Errors coming back as
+// errors with the same messages. It fails on any schema_version other than
+// SchemaVersion.
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var v resultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("repro: Result schema_version %d, want %d", v.SchemaVersion, SchemaVersion)
+	}
+	res := Result{
+		Config:     v.Config.config(),
+		MemoryUsed: v.MemoryUsed,
+	}
+	for _, t := range v.Timings {
+		res.Timings = append(res.Timings, PhaseTiming{time.Duration(t.InsertsNs), t.InsertRows, time.Duration(t.SelectsNs), t.SelectRows})
+	}
+	for _, s := range v.Status {
+		res.Status = append(res.Status, OpStat(s))
+	}
+	for _, s := range v.Global {
+		res.Global = append(res.Global, GlobalStat(s))
+	}
+	for _, msg := range v.Errors {
+		res.Errors = append(res.Errors, errors.New(msg))
+	}
+	*r = res
+	return nil
+}
@@ -0,0 +1,55 @@
+package repro
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func TestResultJSONRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Seed, cfg.TempDir, cfg.BusyTimeout = 42, "/tmp/repro", 5*time.Second
+	want := Result{
+		Config: cfg,
+		Timings: []PhaseTiming{
+			{Inserts: 1500 * time.Millisecond, InsertRows: 10000, Selects: 250 * time.Microsecond, SelectRows: 100000},
+			{Inserts: time.Second, InsertRows: 9999, Selects: 3, SelectRows: 0},
+		},
+		Status: []OpStat{
+			{Op: sqlite3.SQLITE_DBSTATUS_CACHE_USED, Name: "CACHE_USED", Current: 1 << 20, HighwaterSum: 0},
+			{Op: sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED, Name: "LOOKASIDE_USED", Current: 12, HighwaterSum: 100},
+		},
+		Global: []GlobalStat{
+			{Op: sqlite3.SQLITE_STATUS_MEMORY_USED, Name: "MEMORY_USED", Current: 4 << 20, Highwater: 5 << 20},
+		},
+		MemoryUsed: -7,
+		Errors:     []error{errors.New("sqlite3_db_status(CACHE_USED): SQLITE_MISUSE")},
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"schema_version":3`) {
+		t.Errorf("%s: no schema_version 3", data)
+	}
+	var got Result
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestResultJSONSchemaVersion(t *testing.T) {
+	var res Result
+	if err := json.Unmarshal([]byte(`{"schema_version":2,"ops":{}}`), &res); err == nil {
+		t.Error("a version 2 -report file decoded, want an error")
+	}
+}
@@ -0,0 +1,88 @@
+package repro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// retryableCodes are the primary result codes -retry-on accepts, by name.
+var retryableCodes = map[string]int{
+	"BUSY":     sqlite3.SQLITE_BUSY,
+	"LOCKED":   sqlite3.SQLITE_LOCKED,
+	"PROTOCOL": sqlite3.SQLITE_PROTOCOL,
+	"NOMEM":    sqlite3.SQLITE_NOMEM,
+	"IOERR":    sqlite3.SQLITE_IOERR,
+}
+
+// retries counts the attempts retry repeated across the run.
+var retries atomic.Int64
+
+// retryBaseDelay and retryMaxDelay bound the exponential backoff of retry.
+const (
+	retryBaseDelay = time.Millisecond
+	retryMaxDelay  = 100 * time.Millisecond
+)
+
+// ParseRetryCodes parses a comma-separated list of retryableCodes names and
+// returns them as the names retryableCodes has them under.
+func ParseRetryCodes(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimPrefix(strings.TrimSpace(name), "SQLITE_"))
+		if name == "" {
+			continue
+		}
+		if _, ok := retryableCodes[name]; !ok {
+			return nil, fmt.Errorf("unknown result code %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// retry runs op until it succeeds, fails with an error whose primary result
+// code is not one of cfg.RetryOn, or has been retried cfg.MaxRetries times,
+// doubling the delay between attempts from retryBaseDelay up to
+// retryMaxDelay. It returns op's last error, or ctx's if ctx is done while
+// waiting.
+func retry(ctx context.Context, cfg *Config, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= cfg.MaxRetries || !retryable(err, cfg.RetryOn) {
+			return err
+		}
+		retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, retryMaxDelay)
+	}
+}
+
+// retryable reports whether err is a SQLite error with the primary result code
+// of one of the retryableCodes names.
+func retryable(err error, names []string) bool {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, name := range names {
+		if e.Code()&0xff == retryableCodes[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// Retries returns how many times retry retried an operation.
+func Retries() int64 { return retries.Load() }
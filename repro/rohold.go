@@ -0,0 +1,28 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+)
+
+// holdWrites keeps inserting batches of cfg.Inserts rows into db until ctx is
+// done, the -ro-hold write pressure on the readers, and returns the number of
+// rows inserted.
+func holdWrites(ctx context.Context, db *sql.DB, rng *rand.Rand, cfg *Config) (int, error) {
+	rows := 0
+	for ctx.Err() == nil {
+		n, err := inserts(ctx, db, rng, cfg)
+		rows += n
+		if err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// holdOver reports whether an error of a statement run with hold, a context
+// derived from parent, only means the -ro-hold period is over.
+func holdOver(hold, parent context.Context) bool {
+	return hold.Err() != nil && parent.Err() == nil
+}
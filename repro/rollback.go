@@ -0,0 +1,41 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// txnEnds holds the CACHE_USED of the writer connection read right after
+// each committed and each rolled back transaction of every database under
+// -rollback-ratio, see recordTxnEnd.
+var txnEnds struct {
+	mu                 sync.Mutex
+	commits, rollbacks statusSummary
+}
+
+// recordTxnEnd reads the CACHE_USED of the writer connection handle, whose
+// transaction just committed or, with rolledBack set, rolled back, and adds
+// it to txnEnds.
+func recordTxnEnd(handle uintptr, rolledBack bool) {
+	s := &txnEnds.commits
+	if rolledBack {
+		s = &txnEnds.rollbacks
+	}
+	recordDBStatus("rollback-ratio", &txnEnds.mu, s, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED)
+}
+
+// PrintTxnEnds writes the totals of txnEnds.
+func PrintTxnEnds(w io.Writer, cfg *Config) {
+	txnEnds.mu.Lock()
+	defer txnEnds.mu.Unlock()
+	for _, t := range []struct {
+		kind string
+		statusSummary
+	}{{"commit", txnEnds.commits}, {"rollback", txnEnds.rollbacks}} {
+		fmt.Fprintf(w, "rollback-ratio: after %s: transactions=%d CACHE_USED mean=%d max=%d (-rollback-ratio %g)\n",
+			t.kind, t.Count, t.mean(), t.Max, cfg.RollbackRatio)
+	}
+}
@@ -0,0 +1,30 @@
+package repro
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// checkRowCount fails unless the tables of database fn hold the rows inserts
+// reported committing, so that a workload that didn't write what it was asked
+// to can't pass for one that did. It warns when tolerated errors left inserted
+// short of cfg.Inserts, which -rollback-ratio and -max-page-count do on
+// purpose.
+func checkRowCount(db *sql.DB, fn string, cfg *Config, inserted int) error {
+	var rows int
+	for k := 0; k < cfg.Tables; k++ {
+		var n int
+		if err := db.QueryRow("select count(*) from " + cfg.tableName(k)).Scan(&n); err != nil {
+			return err
+		}
+		rows += n
+	}
+	if rows != inserted {
+		return fmt.Errorf("row count: %s: the tables hold %d rows, the inserts committed %d", fn, rows, inserted)
+	}
+	if inserted < cfg.Inserts && cfg.MaxPageCount == 0 && cfg.RollbackRatio == 0 {
+		fmt.Fprintf(os.Stderr, "warning: row count: %s: %d of %d rows inserted, tolerated errors skipped the rest\n", fn, inserted, cfg.Inserts)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+
+package repro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProcessRSS returns the resident set size of the process in bytes, the
+// second field of /proc/self/statm in pages.
+func ProcessRSS() (int64, error) {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+	var size, resident int64
+	if _, err = fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, fmt.Errorf("/proc/self/statm: %w", err)
+	}
+	return resident * int64(os.Getpagesize()), nil
+}
+
+// MappedBytes returns how many bytes of the file name the process has mapped,
+// summed over its mappings in /proc/self/maps.
+func MappedBytes(name string) (int64, error) {
+	if resolved, err := filepath.EvalSymlinks(name); err == nil {
+		name = resolved
+	}
+	maps, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, line := range strings.Split(string(maps), "\n") {
+		// start-end perms offset dev inode pathname
+		fields := strings.Fields(line)
+		if len(fields) < 6 || strings.Join(fields[5:], " ") != name {
+			continue
+		}
+		var start, end int64
+		if _, err := fmt.Sscanf(fields[0], "%x-%x", &start, &end); err != nil {
+			return 0, fmt.Errorf("/proc/self/maps: %w", err)
+		}
+		total += end - start
+	}
+	return total, nil
+}
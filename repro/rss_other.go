@@ -0,0 +1,15 @@
+//go:build !linux
+
+package repro
+
+import "errors"
+
+// ProcessRSS returns the resident set size of the process in bytes.
+func ProcessRSS() (int64, error) {
+	return 0, errors.New("reading the RSS is only supported on linux")
+}
+
+// MappedBytes returns how many bytes of the file name the process has mapped.
+func MappedBytes(name string) (int64, error) {
+	return 0, errors.New("reading the process mappings is only supported on linux")
+}
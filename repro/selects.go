@@ -0,0 +1,86 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+
+	"modernc.org/libc"
+)
+
+// do a lot of selects on table, returns the number of rows read
+func selects(ctx context.Context, db *sql.DB, table string, maxValue int, cfg *Config) (n int, err error) {
+	queryContext := db.QueryContext
+	var handle uintptr
+	if cfg.InterruptAfter > 0 || cfg.StmtStatus {
+		// The interrupt and the statement counters need the handle of the
+		// connection the query runs on.
+		var conn *sql.Conn
+		if conn, err = db.Conn(ctx); err != nil {
+			return 0, countTolerated(err)
+		}
+		defer conn.Close()
+		if cfg.InterruptAfter > 0 {
+			var disarm func(error) error
+			if disarm, err = armInterrupt(conn, cfg.InterruptAfter); err != nil {
+				return 0, err
+			}
+			// Runs once the rows are closed.
+			defer func() { err = disarm(err) }()
+		}
+		if cfg.StmtStatus {
+			if handle, err = SQLConnHandle(conn); err != nil {
+				return 0, err
+			}
+		}
+		queryContext = conn.QueryContext
+	}
+	if cfg.QueryTimeout > 0 {
+		// Deferred after the disarm so that runs on the error it returns.
+		var endQuery func(error) error
+		ctx, endQuery = queryDeadline(ctx, cfg.QueryTimeout)
+		defer func() { err = endQuery(err) }()
+	}
+	var rows *sql.Rows
+	err = retry(ctx, cfg, func() (err error) {
+		query := "select * from " + table + " WHERE i < ?"
+		if cfg.ScratchBytes > 0 || cfg.TempStore != "" {
+			// Sorting on a column without an index needs a sorter.
+			query += " order by str"
+		}
+		rows, err = queryContext(ctx, query, maxValue)
+		return err
+	})
+	if err != nil {
+		return 0, countTolerated(err)
+	}
+	defer rows.Close()
+
+	// database/sql finalizes the statement as the last Next finds no more
+	// rows, so the counters are read after every row and the last read
+	// kept, short of what the final step adds.
+	var tls *libc.TLS
+	var counters [][]int64
+	if cfg.StmtStatus {
+		tls = libc.NewTLS()
+		defer tls.Close()
+	}
+	for ; rows.Next(); n++ {
+		var i int
+		var s string
+		dest := []any{&i, &s}
+		if cfg.BlobSize > 0 {
+			var b []byte
+			dest = append(dest, &b)
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return n, countTolerated(err)
+		}
+		if cfg.StmtStatus {
+			counters = readStmtStatus(tls, handle)
+		}
+	}
+	if cfg.StmtStatus && rows.Err() == nil {
+		recordStmtStatus(counters)
+	}
+	return n, countTolerated(rows.Err())
+}
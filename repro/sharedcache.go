@@ -0,0 +1,49 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// CheckSharedCache prints the CACHE_USED and CACHE_USED_SHARED of one of db's
+// read-write connections to fn and fails unless the readers read-only
+// connections share its page cache. SQLite charges a shared cache in full to
+// CACHE_USED of every connection using it but splits it evenly among them in
+// CACHE_USED_SHARED, so the two are only equal for a cache of its own.
+func CheckSharedCache(ctx context.Context, w io.Writer, db *sql.DB, fn string, readers int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	used, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	shared, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED_SHARED, 0)
+	if err != nil {
+		return err
+	}
+	if readers > 0 && used > 0 && shared >= used {
+		return fmt.Errorf("shared-cache: %s: the read-only connections don't share the page cache, CACHE_USED=%d CACHE_USED_SHARED=%d", fn, used, shared)
+	}
+	sharers := 1
+	if shared > 0 {
+		sharers = int((used + shared/2) / shared)
+	}
+	fmt.Fprintf(w, "shared-cache: %s: CACHE_USED=%d CACHE_USED_SHARED=%d, the cache is shared by %d connections\n", fn, used, shared, sharers)
+	return nil
+}
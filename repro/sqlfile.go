@@ -0,0 +1,204 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sqlStatement is one statement of a -sql-file. It runs once per row of Args,
+// or once without arguments if it has none.
+type sqlStatement struct {
+	SQL  string
+	Args [][]any
+}
+
+// sqlArgsPrefix starts a line of arguments for the statement before it.
+const sqlArgsPrefix = "--?"
+
+// sqlFiles caches the statements of every file LoadSQLFile parsed by path, so
+// that the databases of a run parse their SQLFile once.
+var sqlFiles sync.Map
+
+// LoadSQLFile returns the statements of the file at path, see parseSQL, read
+// on the first call for path.
+func LoadSQLFile(path string) ([]sqlStatement, error) {
+	if stmts, ok := sqlFiles.Load(path); ok {
+		return stmts.([]sqlStatement), nil
+	}
+	stmts, err := loadSQLFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sqlFiles.Store(path, stmts)
+	return stmts, nil
+}
+
+func loadSQLFile(path string) ([]sqlStatement, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stmts, err := parseSQL(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("%s: no statements", path)
+	}
+	return stmts, nil
+}
+
+// parseSQL splits src into statements, each ending with a semicolon outside of
+// quotes and comments. A line starting with sqlArgsPrefix after a statement
+// holds one row of comma-separated arguments for its ? parameters: NULL,
+// integers, reals, 'quoted' strings, anything else is taken as a string. A
+// semicolon inside a trigger body ends the statement early, triggers are not
+// supported.
+func parseSQL(src string) ([]sqlStatement, error) {
+	var stmts []sqlStatement
+	var b strings.Builder
+	for len(src) > 0 {
+		if strings.TrimSpace(b.String()) == "" {
+			trimmed := strings.TrimLeft(src, " \t\r\n")
+			if strings.HasPrefix(trimmed, sqlArgsPrefix) {
+				line, rest, _ := strings.Cut(trimmed[len(sqlArgsPrefix):], "\n")
+				if len(stmts) == 0 {
+					return nil, errors.New("arguments before the first statement")
+				}
+				args, err := parseSQLArgs(line)
+				if err != nil {
+					return nil, err
+				}
+				last := &stmts[len(stmts)-1]
+				last.Args = append(last.Args, args)
+				src = rest
+				b.Reset()
+				continue
+			}
+		}
+		var end int
+		switch {
+		case src[0] == '\'' || src[0] == '"':
+			if end = strings.IndexByte(src[1:], src[0]); end < 0 {
+				return nil, fmt.Errorf("unterminated %c quote", src[0])
+			}
+			// A doubled quote is an escaped one, the same string goes on.
+			end += 2
+		case strings.HasPrefix(src, "--"):
+			if end = strings.IndexByte(src, '\n'); end < 0 {
+				end = len(src)
+			}
+		case strings.HasPrefix(src, "/*"):
+			if end = strings.Index(src, "*/"); end < 0 {
+				return nil, errors.New("unterminated /* comment")
+			}
+			end += 2
+		case src[0] == ';':
+			if sql := strings.TrimSpace(b.String()); sql != "" {
+				stmts = append(stmts, sqlStatement{SQL: sql})
+			}
+			b.Reset()
+			src = src[1:]
+			continue
+		default:
+			end = 1
+		}
+		b.WriteString(src[:end])
+		src = src[end:]
+	}
+	if sql := strings.TrimSpace(b.String()); sql != "" {
+		stmts = append(stmts, sqlStatement{SQL: sql})
+	}
+	return stmts, nil
+}
+
+// parseSQLArgs parses one comma-separated row of arguments, see parseSQL.
+func parseSQLArgs(line string) ([]any, error) {
+	var args []any
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '\'' {
+			// Find the closing quote, skipping doubled ones.
+			end := 1
+			for {
+				i := strings.IndexByte(line[end:], '\'')
+				if i < 0 {
+					return nil, fmt.Errorf("unterminated quote in arguments %q", line)
+				}
+				end += i + 1
+				if end == len(line) || line[end] != '\'' {
+					break
+				}
+				end++
+			}
+			args = append(args, strings.ReplaceAll(line[1:end-1], "''", "'"))
+			rest := strings.TrimSpace(line[end:])
+			if rest != "" && rest[0] != ',' {
+				return nil, fmt.Errorf("expected a comma after %s", line[:end])
+			}
+			line = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+			continue
+		}
+		field, rest, _ := strings.Cut(line, ",")
+		field, line = strings.TrimSpace(field), strings.TrimSpace(rest)
+		if strings.EqualFold(field, "null") {
+			args = append(args, nil)
+		} else if i, err := strconv.ParseInt(field, 10, 64); err == nil {
+			args = append(args, i)
+		} else if f, err := strconv.ParseFloat(field, 64); err == nil {
+			args = append(args, f)
+		} else {
+			args = append(args, field)
+		}
+	}
+	return args, nil
+}
+
+// runSQL runs stmts in order on one of db's connections, so a transaction the
+// statements begin spans the ones after it, and returns how many times
+// statements were executed, counting every row of arguments.
+func runSQL(ctx context.Context, db *sql.DB, stmts []sqlStatement, cfg *Config) (int, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	executed := 0
+	for _, s := range stmts {
+		if len(s.Args) == 0 {
+			if err := retry(ctx, cfg, func() error {
+				_, err := conn.ExecContext(ctx, s.SQL)
+				return err
+			}); err != nil {
+				return executed, fmt.Errorf("%s: %w", s.SQL, err)
+			}
+			executed++
+			continue
+		}
+		stmt, err := conn.PrepareContext(ctx, s.SQL)
+		if err != nil {
+			return executed, fmt.Errorf("%s: %w", s.SQL, err)
+		}
+		for _, args := range s.Args {
+			if err = retry(ctx, cfg, func() error {
+				_, err := stmt.ExecContext(ctx, args...)
+				return err
+			}); err != nil {
+				stmt.Close()
+				return executed, fmt.Errorf("%s: %v: %w", s.SQL, args, err)
+			}
+			executed++
+		}
+		if err = stmt.Close(); err != nil {
+			return executed, err
+		}
+	}
+	return executed, nil
+}
@@ -0,0 +1,274 @@
+// Package repro holds the SQLite memory accounting of the sqlite-repro
+// command and a flag-free form of its workload, so that the same measurements
+// can be taken from another program or a test.
+package repro
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// OpStat is the current value and the highwater of one db_status op, each
+// summed across connections. HighwaterSum is an upper bound of the aggregate's
+// peak, not a peak the connections reached together.
+type OpStat struct {
+	Op           int32
+	Name         string
+	Current      int64
+	HighwaterSum int64
+}
+
+// CollectDBStatus returns the aggregate of every DBStatusOps op across conns,
+// in DBStatusOps order so that output built from it is stable, and the reads
+// that failed. A failed read adds nothing to its op.
+func CollectDBStatus(tls *libc.TLS, conns []uintptr) ([]OpStat, []error) {
+	perConn, errs := CollectConnStatus(tls, conns, 0)
+	stats := make([]OpStat, len(DBStatusOps))
+	for i, op := range DBStatusOps {
+		stats[i] = OpStat{Op: op, Name: DBStatusOpName(op)}
+		for _, c := range perConn {
+			stats[i].Current += int64(c.Current[i])
+			stats[i].HighwaterSum += int64(c.Highwater[i])
+		}
+	}
+	return stats, errs
+}
+
+// CollectStatus is CollectDBStatus with the failed reads joined into one
+// error. The stats are returned either way.
+func CollectStatus(tls *libc.TLS, conns []uintptr) ([]OpStat, error) {
+	stats, errs := CollectDBStatus(tls, conns)
+	return stats, errors.Join(errs...)
+}
+
+// ConnStatus is one connection's db_status, Current and Highwater indexed
+// like DBStatusOps. Index is the connection's position in the conns it was
+// read from, the command passes them in the order they were opened in.
+type ConnStatus struct {
+	Index     int
+	Handle    uintptr
+	Current   []int32
+	Highwater []int32
+}
+
+// CollectConnStatus reads every DBStatusOps op of every connection in conns,
+// passing reset on to DBStatus. An op whose read fails, e.g. on a connection
+// closed underneath the caller, is left at zero and its error returned, the
+// other reads go on.
+func CollectConnStatus(tls *libc.TLS, conns []uintptr, reset int32) ([]ConnStatus, []error) {
+	result := make([]ConnStatus, 0, len(conns))
+	var errs []error
+	for i, db := range conns {
+		c := ConnStatus{
+			Index:     i,
+			Handle:    db,
+			Current:   make([]int32, len(DBStatusOps)),
+			Highwater: make([]int32, len(DBStatusOps)),
+		}
+		for j, op := range DBStatusOps {
+			current, highwater, err := DBStatus(tls, db, op, reset)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("connection %d: %w", i, err))
+				continue
+			}
+			c.Current[j], c.Highwater[j] = current, highwater
+		}
+		result = append(result, c)
+	}
+	return result, errs
+}
+
+// GlobalStatusOps are the process-wide sqlite3_status ops CollectGlobalStatus
+// reads. SCRATCH_USED is obsolete and always zero since SQLite 3.22, it stays
+// for comparison with older builds.
+var GlobalStatusOps = []int32{
+	sqlite3.SQLITE_STATUS_MEMORY_USED,
+	sqlite3.SQLITE_STATUS_MALLOC_COUNT,
+	sqlite3.SQLITE_STATUS_MALLOC_SIZE,
+	sqlite3.SQLITE_STATUS_PAGECACHE_USED,
+	sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW,
+	sqlite3.SQLITE_STATUS_SCRATCH_USED,
+}
+
+func GlobalStatusOpName(op int32) string {
+	switch op {
+	case sqlite3.SQLITE_STATUS_MEMORY_USED:
+		return "MEMORY_USED"
+	case sqlite3.SQLITE_STATUS_MALLOC_COUNT:
+		return "MALLOC_COUNT"
+	case sqlite3.SQLITE_STATUS_MALLOC_SIZE:
+		return "MALLOC_SIZE"
+	case sqlite3.SQLITE_STATUS_PAGECACHE_USED:
+		return "PAGECACHE_USED"
+	case sqlite3.SQLITE_STATUS_PAGECACHE_OVERFLOW:
+		return "PAGECACHE_OVERFLOW"
+	case sqlite3.SQLITE_STATUS_SCRATCH_USED:
+		return "SCRATCH_USED"
+	default:
+		return fmt.Sprintf("%v", op)
+	}
+}
+
+// GlobalStat is the current and highwater of one sqlite3_status op.
+type GlobalStat struct {
+	Op        int32
+	Name      string
+	Current   int64
+	Highwater int64
+}
+
+// CollectGlobalStatus reads every GlobalStatusOps op. An op whose read fails
+// is left at zero and its error returned, the other reads go on.
+func CollectGlobalStatus(tls *libc.TLS) ([]GlobalStat, []error) {
+	stats := make([]GlobalStat, 0, len(GlobalStatusOps))
+	var errs []error
+	for _, op := range GlobalStatusOps {
+		current, highwater, err := Status(tls, op)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		stats = append(stats, GlobalStat{Op: op, Name: GlobalStatusOpName(op), Current: current, Highwater: highwater})
+	}
+	return stats, errs
+}
+
+// DBStatusOps are the sqlite3_db_status ops collected for every connection.
+var DBStatusOps = []int32{
+	sqlite3.SQLITE_DBSTATUS_CACHE_USED,
+	sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED,
+	sqlite3.SQLITE_DBSTATUS_SCHEMA_USED,
+	sqlite3.SQLITE_DBSTATUS_STMT_USED,
+	sqlite3.SQLITE_DBSTATUS_CACHE_SPILL,
+}
+
+func DBStatusOpName(op int32) string {
+	switch op {
+	case sqlite3.SQLITE_DBSTATUS_CACHE_USED:
+		return "CACHE_USED"
+	case sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED:
+		return "LOOKASIDE_USED"
+	case sqlite3.SQLITE_DBSTATUS_SCHEMA_USED:
+		return "SCHEMA_USED"
+	case sqlite3.SQLITE_DBSTATUS_STMT_USED:
+		return "STMT_USED"
+	case sqlite3.SQLITE_DBSTATUS_CACHE_SPILL:
+		return "CACHE_SPILL"
+	default:
+		return fmt.Sprintf("%v", op)
+	}
+}
+
+// DBStatus returns the current and highwater values of a sqlite3_db_status op
+// for a single connection. A non-zero reset zeroes the highwater after it is
+// read. SQLite writes both values to libc memory, which DBStatus allocates and
+// frees around the call.
+func DBStatus(tls *libc.TLS, db uintptr, op, reset int32) (current, highwater int32, err error) {
+	mem := libc.Xmalloc(tls, 8)
+	if mem == 0 {
+		return 0, 0, fmt.Errorf("db_status op %s: cannot allocate memory", DBStatusOpName(op))
+	}
+	defer libc.Xfree(tls, mem)
+
+	if rc := sqlite3.Xsqlite3_db_status(tls, db, op, mem, mem+4, reset); rc != sqlite3.SQLITE_OK {
+		return 0, 0, fmt.Errorf("db_status op %s failed: %s", DBStatusOpName(op), libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return *(*int32)(unsafe.Pointer(mem)), *(*int32)(unsafe.Pointer(mem + 4)), nil
+}
+
+// Status returns the process-wide current and highwater values for a
+// sqlite3_status64 op.
+func Status(tls *libc.TLS, op int32) (current, highwater int64, err error) {
+	return status64(tls, op, 0)
+}
+
+// ResetStatusHighwater resets the process-wide highwater of a sqlite3_status64
+// op to its current value.
+func ResetStatusHighwater(tls *libc.TLS, op int32) error {
+	_, _, err := status64(tls, op, 1)
+	return err
+}
+
+// status64 calls sqlite3_status64 with reset, allocating the two values it
+// writes in libc memory the way DBStatus does.
+func status64(tls *libc.TLS, op, reset int32) (current, highwater int64, err error) {
+	mem := libc.Xmalloc(tls, 16)
+	if mem == 0 {
+		return 0, 0, fmt.Errorf("status op %s: cannot allocate memory", GlobalStatusOpName(op))
+	}
+	defer libc.Xfree(tls, mem)
+
+	if rc := sqlite3.Xsqlite3_status64(tls, op, mem, mem+8, reset); rc != sqlite3.SQLITE_OK {
+		return 0, 0, fmt.Errorf("status op %s failed: %s", GlobalStatusOpName(op), libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return *(*int64)(unsafe.Pointer(mem)), *(*int64)(unsafe.Pointer(mem + 8)), nil
+}
+
+// EnableMemStatus turns on SQLITE_CONFIG_MEMSTATUS, which modernc.org/sqlite
+// builds with disabled (SQLITE_DEFAULT_MEMSTATUS=0). Without it sqlite3_status
+// reports zero for MEMORY_USED and MALLOC_COUNT. It must run before SQLite is
+// initialized.
+func EnableMemStatus() error {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	list := libc.NewVaList(int32(1))
+	if list == 0 {
+		return fmt.Errorf("sqlite: enable memstatus: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_MEMSTATUS, list); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_MEMSTATUS: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
+
+// StmtStatusOps are the sqlite3_stmt_status counters StmtStatus reads.
+var StmtStatusOps = []int32{
+	sqlite3.SQLITE_STMTSTATUS_FULLSCAN_STEP,
+	sqlite3.SQLITE_STMTSTATUS_SORT,
+	sqlite3.SQLITE_STMTSTATUS_AUTOINDEX,
+	sqlite3.SQLITE_STMTSTATUS_VM_STEP,
+	sqlite3.SQLITE_STMTSTATUS_MEMUSED,
+}
+
+func StmtStatusOpName(op int32) string {
+	switch op {
+	case sqlite3.SQLITE_STMTSTATUS_FULLSCAN_STEP:
+		return "FULLSCAN_STEP"
+	case sqlite3.SQLITE_STMTSTATUS_SORT:
+		return "SORT"
+	case sqlite3.SQLITE_STMTSTATUS_AUTOINDEX:
+		return "AUTOINDEX"
+	case sqlite3.SQLITE_STMTSTATUS_VM_STEP:
+		return "VM_STEP"
+	case sqlite3.SQLITE_STMTSTATUS_MEMUSED:
+		return "MEMUSED"
+	default:
+		return fmt.Sprintf("%v", op)
+	}
+}
+
+// StmtStatus returns the value of a sqlite3_stmt_status counter of the
+// sqlite3_stmt stmt, a non-zero reset zeroing it after it is read. MEMUSED is
+// the heap memory the statement holds and isn't reset.
+func StmtStatus(tls *libc.TLS, stmt uintptr, op, reset int32) int32 {
+	return sqlite3.Xsqlite3_stmt_status(tls, stmt, op, reset)
+}
+
+// ConnStmts returns the sqlite3_stmt handles of the statements prepared on
+// the connection db and not yet finalized. modernc.org/sqlite prepares a
+// statement for every Exec and Query and finalizes it once the Exec returns
+// or the Rows close, so a query's statement is found here while its Rows are
+// open on the connection.
+func ConnStmts(tls *libc.TLS, db uintptr) []uintptr {
+	var stmts []uintptr
+	for stmt := sqlite3.Xsqlite3_next_stmt(tls, db, 0); stmt != 0; stmt = sqlite3.Xsqlite3_next_stmt(tls, db, stmt) {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
@@ -0,0 +1,146 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"testing"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// openTestConn opens an in-memory database and returns one of its
+// connections and the connection's sqlite3* handle.
+func openTestConn(t *testing.T) (*sql.Conn, uintptr) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, handle
+}
+
+func TestDBStatusReset(t *testing.T) {
+	conn, handle := openTestConn(t)
+	// Preparing statements takes lookaside slots and gives them back, which
+	// leaves the highwater above the current value.
+	for _, q := range []string{"create table t(i int, s text)", "insert into t values(1, 'a')", "select * from t"} {
+		if _, err := conn.ExecContext(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+	const op = sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED
+	current, highwater, err := DBStatus(tls, handle, op, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if highwater <= current {
+		t.Fatalf("before the reset: highwater=%d, want above current=%d", highwater, current)
+	}
+	afterCurrent, afterHighwater, err := DBStatus(tls, handle, op, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afterHighwater != afterCurrent {
+		t.Errorf("after the reset: highwater=%d, want current=%d", afterHighwater, afterCurrent)
+	}
+}
+
+func TestCollectConnStatusReset(t *testing.T) {
+	conn, handle := openTestConn(t)
+	if _, err := conn.ExecContext(context.Background(), "create table t(i int)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+	if _, errs := CollectConnStatus(tls, []uintptr{handle}, 1); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	stats, errs := CollectConnStatus(tls, []uintptr{handle}, 0)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	// LOOKASIDE_USED is one of the ops SQLite keeps a highwater for.
+	i := slices.Index(DBStatusOps, sqlite3.SQLITE_DBSTATUS_LOOKASIDE_USED)
+	if c := stats[0]; c.Highwater[i] != c.Current[i] {
+		t.Errorf("LOOKASIDE_USED: highwater=%d after a reset, want current=%d", c.Highwater[i], c.Current[i])
+	}
+}
+
+// TestStatusNoLeaks reads the status from several goroutines at once and
+// checks that every libc allocation the reads made was freed. libc only
+// audits its allocator when built with -tags libc.memgrind, without it the
+// test still exercises the reads, e.g. under -race.
+func TestStatusNoLeaks(t *testing.T) {
+	_, handle := openTestConn(t)
+	conns := []uintptr{handle}
+
+	read := func(tls *libc.TLS) (errs []error) {
+		if _, _, err := Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED); err != nil {
+			errs = append(errs, err)
+		}
+		if _, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0); err != nil {
+			errs = append(errs, err)
+		}
+		_, e := CollectDBStatus(tls, conns)
+		errs = append(errs, e...)
+		_, e = CollectConnStatus(tls, conns, 0)
+		errs = append(errs, e...)
+		_, e = CollectGlobalStatus(tls)
+		errs = append(errs, e...)
+		return errs
+	}
+
+	const goroutines, reads = 4, 100
+	// A TLS grows its stack on first use and keeps it until it is closed, so
+	// every one reads once before the audit starts.
+	tlss := make([]*libc.TLS, goroutines)
+	for i := range tlss {
+		tlss[i] = libc.NewTLS()
+		defer tlss[i].Close()
+		if errs := read(tlss[i]); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+
+	libc.MemAuditStart()
+	var wg sync.WaitGroup
+	errc := make(chan []error, goroutines)
+	for _, tls := range tlss {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var errs []error
+			for range reads {
+				errs = append(errs, read(tls)...)
+			}
+			errc <- errs
+		}()
+	}
+	wg.Wait()
+	if err := libc.MemAuditReport(); err != nil {
+		t.Error(err)
+	}
+	close(errc)
+	for errs := range errc {
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package repro
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"modernc.org/libc"
+)
+
+// statusSummary sums the readings of one db_status op taken at one point of
+// the workload, e.g. a writer's CACHE_USED after every commit. The reads that
+// failed are only counted.
+type statusSummary struct {
+	Count     int
+	Sum       int64
+	Max       int32
+	ReadFails int
+}
+
+func (s *statusSummary) add(v int32) {
+	s.Count++
+	s.Sum += int64(v)
+	s.Max = max(s.Max, v)
+}
+
+func (s statusSummary) mean() int64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / int64(s.Count)
+}
+
+// recordDBStatus reads the db_status op of the connection handle and adds it
+// to s with mu held. A failed read is warned about as label and counted in
+// s.ReadFails.
+func recordDBStatus(label string, mu *sync.Mutex, s *statusSummary, handle uintptr, op int32) {
+	var v int32
+	var err error
+	WithTLS(func(tls *libc.TLS) { v, _, err = DBStatus(tls, handle, op, 0) })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s: %v\n", label, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		s.ReadFails++
+		return
+	}
+	s.add(v)
+}
@@ -0,0 +1,69 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"modernc.org/libc"
+)
+
+// stmtCounters sums the StmtStatusOps counters of the select
+// statements -stmt-status read, in StmtStatusOps order.
+var stmtCounters struct {
+	mu       sync.Mutex
+	stmts    int
+	sum, max []int64
+}
+
+// readStmtStatus returns the counters of every statement open on the
+// connection handle, in StmtStatusOps order.
+func readStmtStatus(tls *libc.TLS, handle uintptr) [][]int64 {
+	var counters [][]int64
+	for _, stmt := range ConnStmts(tls, handle) {
+		c := make([]int64, len(StmtStatusOps))
+		for i, op := range StmtStatusOps {
+			c[i] = int64(StmtStatus(tls, stmt, op, 0))
+		}
+		counters = append(counters, c)
+	}
+	return counters
+}
+
+// recordStmtStatus adds counters, as readStmtStatus returned them for one
+// select, to stmtCounters.
+func recordStmtStatus(counters [][]int64) {
+	stmtCounters.mu.Lock()
+	defer stmtCounters.mu.Unlock()
+	if stmtCounters.sum == nil {
+		stmtCounters.sum = make([]int64, len(StmtStatusOps))
+		stmtCounters.max = make([]int64, len(StmtStatusOps))
+	}
+	for _, c := range counters {
+		stmtCounters.stmts++
+		for i, v := range c {
+			stmtCounters.sum[i] += v
+			stmtCounters.max[i] = max(stmtCounters.max[i], v)
+		}
+	}
+}
+
+// PrintStmtStatus writes the mean and max of every counter in stmtCounters.
+func PrintStmtStatus(w io.Writer) {
+	stmtCounters.mu.Lock()
+	defer stmtCounters.mu.Unlock()
+	var b strings.Builder
+	for i, op := range StmtStatusOps {
+		var sum, peak int64
+		if stmtCounters.sum != nil {
+			sum, peak = stmtCounters.sum[i], stmtCounters.max[i]
+		}
+		mean := int64(0)
+		if stmtCounters.stmts > 0 {
+			mean = sum / int64(stmtCounters.stmts)
+		}
+		fmt.Fprintf(&b, " %s mean=%d max=%d", StmtStatusOpName(op), mean, peak)
+	}
+	fmt.Fprintf(w, "stmt-status: selects=%d%s\n", stmtCounters.stmts, b.String())
+}
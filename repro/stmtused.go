@@ -0,0 +1,36 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// stmtUsed holds the STMT_USED of the writer connections read for
+// -report-stmt-used: at the end of every transaction with its statements
+// still open, and after every commit.
+var stmtUsed struct {
+	mu                 sync.Mutex
+	inTxn, afterCommit statusSummary
+}
+
+// recordStmtUsed reads the STMT_USED of the writer connection handle and adds
+// it to s, one of the stmtUsed totals.
+func recordStmtUsed(handle uintptr, s *statusSummary) {
+	recordDBStatus("report-stmt-used", &stmtUsed.mu, s, handle, sqlite3.SQLITE_DBSTATUS_STMT_USED)
+}
+
+// PrintStmtUsed writes the totals of stmtUsed.
+func PrintStmtUsed(w io.Writer, cfg *Config) {
+	stmtUsed.mu.Lock()
+	defer stmtUsed.mu.Unlock()
+	for _, t := range []struct {
+		point string
+		statusSummary
+	}{{"in transaction", stmtUsed.inTxn}, {"after commit", stmtUsed.afterCommit}} {
+		fmt.Fprintf(w, "report-stmt-used: %s: transactions=%d STMT_USED mean=%d max=%d (-reuse-stmt %t)\n",
+			t.point, t.Count, t.mean(), t.Max, cfg.ReuseStmt)
+	}
+}
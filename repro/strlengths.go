@@ -0,0 +1,57 @@
+package repro
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// strLengthBuckets is the number of equal-width buckets the string lengths of
+// [cfg.MinStrSize, cfg.MaxStrSize) are counted in.
+const strLengthBuckets = 10
+
+// strLengths counts the strings inserts generated, warmup and tolerated
+// failures included, by length bucket, with their total length and that of
+// the blobs next to them.
+var strLengths struct {
+	buckets   [strLengthBuckets]atomic.Int64
+	bytes     atomic.Int64
+	blobBytes atomic.Int64
+}
+
+// strLengthWidth returns the width of the strLengths buckets for cfg, at least
+// 1 so that a range narrower than strLengthBuckets leaves the last ones empty.
+func strLengthWidth(cfg *Config) int {
+	return max((cfg.MaxStrSize-cfg.MinStrSize+strLengthBuckets-1)/strLengthBuckets, 1)
+}
+
+// recordStrLength counts a string of l bytes and a blob of cfg.BlobSize.
+func recordStrLength(cfg *Config, l int) {
+	b := min((l-cfg.MinStrSize)/strLengthWidth(cfg), strLengthBuckets-1)
+	strLengths.buckets[max(b, 0)].Add(1)
+	strLengths.bytes.Add(int64(l))
+	strLengths.blobBytes.Add(int64(cfg.BlobSize))
+}
+
+// PrintStrLengths writes the strLengths histogram, each bucket as
+// [from,to)=rows, along with the mean length the rows came out at.
+func PrintStrLengths(w io.Writer, cfg *Config) {
+	width := strLengthWidth(cfg)
+	var rows int64
+	var b strings.Builder
+	for i := range strLengths.buckets {
+		n := strLengths.buckets[i].Load()
+		from := cfg.MinStrSize + i*width
+		if from >= cfg.MaxStrSize {
+			break
+		}
+		fmt.Fprintf(&b, " [%d,%d)=%d", from, min(from+width, cfg.MaxStrSize), n)
+		rows += n
+	}
+	var mean int64
+	if rows > 0 {
+		mean = strLengths.bytes.Load() / rows
+	}
+	fmt.Fprintf(w, "str-lengths: rows=%d bytes=%d mean=%d blob_bytes=%d%s\n", rows, strLengths.bytes.Load(), mean, strLengths.blobBytes.Load(), b.String())
+}
@@ -0,0 +1,68 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// TempStores are the values -temp-store accepts, the modes of pragma
+// temp_store.
+var TempStores = []string{"default", "file", "memory"}
+
+// probeTempStore copies table into a temp table sorted by str through one of
+// db's read-write connections, so that SQLite needs temp storage, and prints
+// the process-wide MEMORY_USED and the connection's CACHE_USED before, with
+// the temp table and once it is dropped. Under temp_store=memory the temp
+// database's pages are heap memory, under file they are spilled to a temp
+// file past the cache.
+func probeTempStore(ctx context.Context, w io.Writer, db *sql.DB, fn, table string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var memUsed [3]int64
+	var cacheUsed [3]int32
+	read := func(i int) error {
+		if memUsed[i], _, err = Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED); err != nil {
+			return err
+		}
+		cacheUsed[i], _, err = DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+		return err
+	}
+	if err = read(0); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "create temp table temp_store_probe as select * from "+table+" order by str"); err != nil {
+		return err
+	}
+	if err = read(1); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, "drop table temp_store_probe"); err != nil {
+		return err
+	}
+	if err = read(2); err != nil {
+		return err
+	}
+	var mode int
+	if err = conn.QueryRowContext(ctx, "pragma temp_store").Scan(&mode); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "temp-store: %s: temp_store=%s MEMORY_USED before=%d with_temp_table=%d dropped=%d CACHE_USED before=%d with_temp_table=%d dropped=%d\n",
+		fn, TempStores[mode], memUsed[0], memUsed[1], memUsed[2], cacheUsed[0], cacheUsed[1], cacheUsed[2])
+	return nil
+}
@@ -0,0 +1,32 @@
+package repro
+
+import (
+	"fmt"
+	"os"
+
+	"modernc.org/libc"
+)
+
+// WithTLS calls f with a TLS of its own and closes it once f returns.
+//
+// A libc.TLS holds the errno and the per-call scratch memory of the goroutine
+// running the C code, so it is used by one goroutine at a time: every
+// goroutine that calls into SQLite allocates its own, either through WithTLS
+// or with a NewTLS that the goroutine itself closes, and never hands it to
+// another goroutine. Sharing one would let two calls overwrite each other's
+// stack allocations, e.g. the values dbStatus reads back.
+func WithTLS(f func(tls *libc.TLS)) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+	f(tls)
+}
+
+// ReadStatus is Status for the reports, which warn about an op that
+// can't be read and go on with it at zero.
+func ReadStatus(tls *libc.TLS, op int32) (current, highwater int64) {
+	current, highwater, err := Status(tls, op)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: sqlite: %v\n", err)
+	}
+	return current, highwater
+}
@@ -0,0 +1,73 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	"modernc.org/libc"
+)
+
+// TestConcurrentStatusPerGoroutineTLS reads the status of idle connections
+// from many goroutines at once, each with its own TLS, and checks every read
+// matches the one taken alone beforehand.
+func TestConcurrentStatusPerGoroutineTLS(t *testing.T) {
+	const conns, goroutines, reads = 4, 8, 200
+	ctx := context.Background()
+	handles := make([]uintptr, conns)
+	for i := range handles {
+		db, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		// Tables of different sizes give each connection its own
+		// CACHE_USED and SCHEMA_USED.
+		for k := 0; k <= i; k++ {
+			if _, err = conn.ExecContext(ctx, fmt.Sprintf("create table t%d(i int, s text); insert into t%[1]d with recursive n(v) as (select 1 union all select v+1 from n where v < %d) select v, hex(randomblob(100)) from n", k, 100*(i+1))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if handles[i], err = SQLConnHandle(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []OpStat
+	var errs []error
+	WithTLS(func(tls *libc.TLS) { want, errs = CollectDBStatus(tls, handles) })
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan string, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			WithTLS(func(tls *libc.TLS) {
+				for r := 0; r < reads; r++ {
+					got, errs := CollectDBStatus(tls, handles)
+					if len(errs) > 0 || !slices.Equal(got, want) {
+						failures <- fmt.Sprintf("goroutine %d read %d: got %v %v, want %v", g, r, got, errs, want)
+						return
+					}
+				}
+			})
+		}()
+	}
+	wg.Wait()
+	close(failures)
+	for f := range failures {
+		t.Error(f)
+	}
+}
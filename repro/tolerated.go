@@ -0,0 +1,54 @@
+package repro
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	// busyErrors counts the SQLITE_BUSY and SQLITE_LOCKED errors countBusy
+	// absorbed.
+	busyErrors atomic.Int64
+	// nomemErrors counts the SQLITE_NOMEM errors countNoMem absorbed.
+	nomemErrors atomic.Int64
+)
+
+// BusyErrors returns how many SQLITE_BUSY and SQLITE_LOCKED errors the
+// workload tolerated.
+func BusyErrors() int64 { return busyErrors.Load() }
+
+// NomemErrors returns how many SQLITE_NOMEM errors the workload tolerated.
+func NomemErrors() int64 { return nomemErrors.Load() }
+
+// countBusy returns nil and counts err in busyErrors if it is SQLITE_BUSY or
+// SQLITE_LOCKED, lock contention between the writer and the readers rather
+// than a failure, and returns err unchanged otherwise.
+func countBusy(err error) error {
+	var e *sqlite.Error
+	if errors.As(err, &e) && (e.Code()&0xff == sqlite3.SQLITE_BUSY || e.Code()&0xff == sqlite3.SQLITE_LOCKED) {
+		busyErrors.Add(1)
+		return nil
+	}
+	return err
+}
+
+// countTolerated passes err through countNoMem and countBusy, for the
+// statements of the workload that may fail without failing the run.
+func countTolerated(err error) error {
+	return countBusy(countNoMem(err))
+}
+
+// countNoMem returns nil and counts err in nomemErrors if it is SQLITE_NOMEM,
+// which the hard heap limit makes an expected outcome, and returns err
+// unchanged otherwise.
+func countNoMem(err error) error {
+	var e *sqlite.Error
+	if errors.As(err, &e) && e.Code()&0xff == sqlite3.SQLITE_NOMEM {
+		nomemErrors.Add(1)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// vacuum runs VACUUM on fn through one of db's read-write connections and
+// prints that connection's CACHE_USED and the file size before and after.
+func vacuum(ctx context.Context, w io.Writer, db *sql.DB, fn string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	cacheBefore, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	sizeBefore := fileSize(fn)
+	if _, err = conn.ExecContext(ctx, "vacuum"); err != nil {
+		return err
+	}
+	cacheAfter, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "vacuum: %s: CACHE_USED before=%d after=%d file size before=%d after=%d\n",
+		fn, cacheBefore, cacheAfter, sizeBefore, fileSize(fn))
+	return nil
+}
+
+// AutoVacuumModes are the values -auto-vacuum accepts, in the order pragma
+// auto_vacuum numbers them.
+var AutoVacuumModes = []string{"none", "full", "incremental"}
+
+// incrementalVacuum runs pragma incremental_vacuum(pages) on fn through one of
+// db's read-write connections, pages 0 freeing the whole freelist, and prints
+// freelist_count and that connection's CACHE_USED before and after.
+func incrementalVacuum(ctx context.Context, w io.Writer, db *sql.DB, fn string, pages int) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	handle, err := SQLConnHandle(conn)
+	if err != nil {
+		return err
+	}
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var freelistBefore, freelistAfter int
+	if err = conn.QueryRowContext(ctx, "pragma freelist_count").Scan(&freelistBefore); err != nil {
+		return err
+	}
+	cacheBefore, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	// Every step of the pragma frees one page, which Exec stops after, so
+	// it goes through Query, drained to its last row.
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("pragma incremental_vacuum(%d)", pages))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	cacheAfter, _, err := DBStatus(tls, handle, sqlite3.SQLITE_DBSTATUS_CACHE_USED, 0)
+	if err != nil {
+		return err
+	}
+	if err = conn.QueryRowContext(ctx, "pragma freelist_count").Scan(&freelistAfter); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "incremental-vacuum: %s: pages=%d freelist_count before=%d after=%d CACHE_USED before=%d after=%d\n",
+		fn, pages, freelistBefore, freelistAfter, cacheBefore, cacheAfter)
+	return nil
+}
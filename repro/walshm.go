@@ -0,0 +1,40 @@
+package repro
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// reportWALShm prints, for db's read-write connection and every one of
+// roDbs, its CACHE_USED next to the bytes of fn's -shm file mapped, then the
+// -wal and -shm file sizes. SQLite maps the WAL index once per process and
+// file, every connection reads the same mapping, so unlike CACHE_USED the
+// mapped bytes are not per connection and don't add up across them. The
+// mapping grows by 32 KiB regions as the WAL grows, which -wal-shm bounds
+// through wal_autocheckpoint.
+func reportWALShm(w io.Writer, db *sql.DB, roDbs []*sql.DB, fn string, autocheckpoint int) error {
+	var mapped string
+	if n, err := MappedBytes(fn + "-shm"); err != nil {
+		mapped = fmt.Sprintf("unknown (%v)", err)
+	} else {
+		mapped = fmt.Sprint(n)
+	}
+	pools := append([]*sql.DB{db}, roDbs...)
+	var cacheSum int64
+	for i, pool := range pools {
+		cacheUsed, err := poolCacheUsed(pool)
+		if err != nil {
+			return err
+		}
+		cacheSum += int64(cacheUsed)
+		conn := "rw"
+		if i > 0 {
+			conn = fmt.Sprintf("ro=%d", i-1)
+		}
+		fmt.Fprintf(w, "wal-shm: %s: conn=%s CACHE_USED=%d shm_mapped=%s\n", fn, conn, cacheUsed, mapped)
+	}
+	fmt.Fprintf(w, "wal-shm: %s: wal_autocheckpoint=%d wal=%d shm=%d shm_mapped=%s connections=%d CACHE_USED sum=%d\n",
+		fn, autocheckpoint, fileSize(fn+"-wal"), fileSize(fn+"-shm"), mapped, len(pools), cacheSum)
+	return nil
+}
@@ -0,0 +1,870 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/libc"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// PhaseTiming is the wall-clock time of one database's inserts and of its
+// parallel selects, and the rows each read or wrote.
+type PhaseTiming struct {
+	Inserts    time.Duration
+	InsertRows int
+	Selects    time.Duration
+	SelectRows int
+}
+
+// RowsPerSecond returns rows over d, 0 for a d of 0.
+func RowsPerSecond(rows int, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(rows) / d.Seconds()
+}
+
+// Hooks are what the caller of CreateAndTestDb plugs into the workload. The
+// zero Hooks opens the connections with the modernc.org/sqlite driver and
+// traces nothing.
+type Hooks struct {
+	// Driver is the name of the database/sql driver every connection is
+	// opened with, "sqlite" if empty. A driver with a connection hook is
+	// how the caller gets at the connections' handles.
+	Driver string
+	// Trace records the inserts and selects of every database, if set.
+	Trace Trace
+}
+
+// Trace is a timeline of the workload's phases.
+type Trace interface {
+	// NewTrack returns a track of its own, so the phases of databases
+	// created concurrently don't overlap.
+	NewTrack() int64
+	// Phase starts the phase name on track and returns the function that
+	// ends it.
+	Phase(name string, track int64) (end func())
+}
+
+func (h Hooks) driver() string {
+	if h.Driver == "" {
+		return "sqlite"
+	}
+	return h.Driver
+}
+
+func (h Hooks) newTrack() int64 {
+	if h.Trace == nil {
+		return 0
+	}
+	return h.Trace.NewTrack()
+}
+
+func (h Hooks) phase(name string, track int64) func() {
+	if h.Trace == nil {
+		return func() {}
+	}
+	return h.Trace.Phase(name, track)
+}
+
+// Result is what RunWorkload measured.
+type Result struct {
+	Config Config
+	// Timings holds one PhaseTiming per database, in database order.
+	Timings []PhaseTiming
+	// Status is the db_status aggregate of every connection and Global the
+	// process-wide status, both read once every database's selects were
+	// done and before any of the connections closed.
+	Status []OpStat
+	Global []GlobalStat
+	// MemoryUsed is how much MEMORY_USED grew from the start of the run to
+	// the point Status was read. It stays zero unless EnableMemStatus ran
+	// before SQLite was initialized.
+	MemoryUsed int64
+	// Errors are the db_status and status reads that failed, which left
+	// their ops short in Status and Global.
+	Errors []error
+}
+
+// workloadDriver is the driver RunWorkload opens its connections with,
+// registered by the first run. Its connection hook records every connection
+// in workloadConns, which runMu keeps to one run at a time.
+const workloadDriver = "sqlite-repro"
+
+var (
+	registerWorkloadDriver sync.Once
+	runMu                  sync.Mutex
+	workloadConns          struct {
+		mu    sync.Mutex
+		conns []sqlite.ExecQuerierContext
+		// track is set while a run is recording its connections.
+		track bool
+	}
+)
+
+// RunWorkload runs CreateAndTestDb on cfg.DBCount databases, cfg.DBWorkers at
+// a time or all at once for 0, then reads the status of every connection
+// while they are all still open and closes them. The returned error joins the
+// databases that failed, the Result then covers the others. Runs are
+// serialized. The connections a pool has closed by then are left out of the
+// status.
+func RunWorkload(ctx context.Context, cfg Config) (Result, error) {
+	if err := cfg.validate(); err != nil {
+		return Result{}, err
+	}
+	registerWorkloadDriver.Do(func() {
+		d := &sqlite.Driver{}
+		d.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+			workloadConns.mu.Lock()
+			defer workloadConns.mu.Unlock()
+			if !workloadConns.track {
+				return nil
+			}
+			if _, err := ConnDBHandle(conn); err != nil {
+				return err
+			}
+			workloadConns.conns = append(workloadConns.conns, conn)
+			return nil
+		})
+		sql.Register(workloadDriver, d)
+	})
+	runMu.Lock()
+	defer runMu.Unlock()
+	workloadConns.mu.Lock()
+	workloadConns.conns, workloadConns.track = nil, true
+	workloadConns.mu.Unlock()
+
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	res := Result{Config: cfg, Timings: make([]PhaseTiming, cfg.DBCount)}
+	memUsedBefore, _, err := Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	if err != nil {
+		return Result{}, err
+	}
+	hooks := Hooks{Driver: workloadDriver}
+	closeFuncs := make([]func() error, cfg.DBCount)
+	errs := make([]error, cfg.DBCount)
+	jobs := make(chan int)
+	workers := cfg.DBCount
+	if cfg.DBWorkers > 0 {
+		workers = min(cfg.DBWorkers, cfg.DBCount)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			PinGoroutine(&cfg)
+			for i := range jobs {
+				rng := rand.New(rand.NewSource(cfg.DataSeed(i)))
+				closeFuncs[i], res.Timings[i], errs[i] = CreateAndTestDb(ctx, &cfg, hooks, "", rng)
+				if errs[i] != nil {
+					errs[i] = fmt.Errorf("database %d: %w", i, errs[i])
+				}
+			}
+		}()
+	}
+	for i := 0; i < cfg.DBCount; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	workloadConns.mu.Lock()
+	var handles []uintptr
+	var unlocks []func()
+	for _, conn := range workloadConns.conns {
+		if handle, unlock, ok := LockConn(conn); ok {
+			handles, unlocks = append(handles, handle), append(unlocks, unlock)
+		}
+	}
+	res.Status, res.Errors = CollectDBStatus(tls, handles)
+	for _, unlock := range unlocks {
+		unlock()
+	}
+	workloadConns.conns, workloadConns.track = nil, false
+	workloadConns.mu.Unlock()
+	var globalErrs []error
+	res.Global, globalErrs = CollectGlobalStatus(tls)
+	res.Errors = append(res.Errors, globalErrs...)
+	if memUsed, _, err := Status(tls, sqlite3.SQLITE_STATUS_MEMORY_USED); err != nil {
+		res.Errors = append(res.Errors, err)
+	} else {
+		res.MemoryUsed = memUsed - memUsedBefore
+	}
+
+	// A failed database's close function closes what it opened.
+	for i, closeFunc := range closeFuncs {
+		if err := closeFunc(); err != nil {
+			errs = append(errs, fmt.Errorf("database %d: %w", i, err))
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// CreateAndTestDb creates a database, fills it and reads it back from
+// cfg.ParallelSelects read-only connections. The database goes in its own temp
+// directory, removed on return, unless sharedDir is set, in which case it gets
+// a unique name in sharedDir and the caller removes it. With cfg.InMemory it
+// is a shared-cache in-memory database instead, gone with its last connection.
+// The returned close function closes the pools it opened, on an error too, a
+// cancelled ctx included, so a caller with a hook registering connections
+// drops them before calling it.
+func CreateAndTestDb(ctx context.Context, cfg *Config, hooks Hooks, sharedDir string, rng *rand.Rand) (close func() error, timing PhaseTiming, err error) {
+	out := cfg.out()
+	var fn string
+	var db *sql.DB
+	var roDbs []*sql.DB
+	defer func() {
+		switch {
+		case err == nil:
+		case db == nil:
+			close = func() error { return nil }
+		default:
+			// Every goroutine using the pools is done by now.
+			close = closeOnce(out, cfg, fn, db, roDbs)
+		}
+	}()
+	// Connections to an in-memory database share it through the shared
+	// cache, a read-only one can't say mode=ro and uses query_only instead.
+	rwParams, roParams := url.Values{}, url.Values{"mode": {"ro"}}
+	switch {
+	case cfg.InMemory:
+		fn = fmt.Sprintf("file:memdb-%d", memDBs.Add(1))
+		rwParams = url.Values{"mode": {"memory"}, "cache": {"shared"}}
+		roParams = url.Values{"mode": {"memory"}, "cache": {"shared"}, "_pragma": {"query_only(1)"}}
+	case sharedDir != "":
+		// CreateTemp picks a name no concurrent caller can also get. SQLite
+		// treats the empty file it leaves behind as an empty database.
+		f, err := os.CreateTemp(sharedDir, "db-*")
+		if err != nil {
+			return nil, timing, err
+		}
+		fn = f.Name()
+		if err = f.Close(); err != nil {
+			return nil, timing, err
+		}
+		if keepTempDB(cfg) {
+			fmt.Fprintf(out, "keep-temp: %s\n", fn)
+		}
+	default:
+		dir, err := os.MkdirTemp(cfg.TempDir, "test-*")
+		if err != nil {
+			return nil, timing, err
+		}
+
+		fn = filepath.Join(dir, "db")
+		if keepTempDB(cfg) {
+			fmt.Fprintf(out, "keep-temp: %s\n", fn)
+		} else {
+			defer os.RemoveAll(dir)
+		}
+	}
+
+	// The driver only passes the query string on to SQLite for a file: URI,
+	// it takes the parameters it knows, like _pragma, off any other name.
+	dsnName := fn
+	if cfg.SharedCache && !cfg.InMemory {
+		dsnName = "file:" + fn
+		rwParams.Set("cache", "shared")
+		roParams.Set("cache", "shared")
+	}
+
+	track := hooks.newTrack()
+	// _pragma runs on every connection the pool opens.
+	if cfg.BusyTimeout > 0 {
+		rwParams.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeout.Milliseconds()))
+	}
+	if cfg.CacheSize != 0 {
+		rwParams.Add("_pragma", fmt.Sprintf("cache_size(%d)", cfg.CacheSize))
+	}
+	if cfg.MaxPageCount > 0 {
+		rwParams.Add("_pragma", fmt.Sprintf("max_page_count(%d)", cfg.MaxPageCount))
+	}
+	if cfg.SecureDelete != "" {
+		rwParams.Add("_pragma", fmt.Sprintf("secure_delete(%s)", cfg.SecureDelete))
+		roParams.Add("_pragma", fmt.Sprintf("secure_delete(%s)", cfg.SecureDelete))
+	}
+	if cfg.TempStore != "" {
+		rwParams.Add("_pragma", fmt.Sprintf("temp_store(%s)", cfg.TempStore))
+		roParams.Add("_pragma", fmt.Sprintf("temp_store(%s)", cfg.TempStore))
+	}
+	if cfg.MmapSize > 0 {
+		rwParams.Add("_pragma", fmt.Sprintf("mmap_size(%d)", cfg.MmapSize))
+		roParams.Add("_pragma", fmt.Sprintf("mmap_size(%d)", cfg.MmapSize))
+	}
+	db, err = sql.Open(hooks.driver(), connDSN(dsnName, rwParams, cfg.DSNParams))
+	if err != nil {
+		return nil, timing, err
+	}
+	if cfg.RaceCheck {
+		// Readers share db in this mode, so the pool opens extra connections.
+		// Keep them all idle instead of closing them, so they all stay in the
+		// status read at the end.
+		db.SetMaxIdleConns(cfg.ParallelSelects + 1)
+	}
+	if cfg.Writers > 1 {
+		// Each writer holds a connection of db, and database/sql only keeps
+		// two idle, closing the others before the status is read. The +1 is the -auto-checkpoint-interval goroutine's, and
+		// the -race-check readers only start once the writers are done.
+		db.SetMaxIdleConns(max(cfg.Writers+1, cfg.ParallelSelects+1))
+	}
+	configurePool(db, cfg)
+	if cfg.SingleConn {
+		db.SetMaxOpenConns(1)
+	}
+
+	// The page size can only change while the file has no pages, so it goes
+	// first: switching to WAL already writes page 1.
+	var gotPageSize int
+	if _, err = db.Exec(fmt.Sprintf("pragma page_size=%d", cfg.PageSize)); err != nil {
+		return nil, timing, err
+	}
+	if err = db.QueryRow("pragma page_size").Scan(&gotPageSize); err != nil {
+		return nil, timing, err
+	}
+	if gotPageSize != cfg.PageSize {
+		return nil, timing, fmt.Errorf("sqlite: %s: page_size %d requested, got %d", fn, cfg.PageSize, gotPageSize)
+	}
+	if cfg.AutoVacuum != "" {
+		// Like the page size, auto_vacuum only changes on a database
+		// without tables.
+		var gotAutoVacuum int
+		if _, err = db.Exec("pragma auto_vacuum=" + cfg.AutoVacuum); err != nil {
+			return nil, timing, err
+		}
+		if err = db.QueryRow("pragma auto_vacuum").Scan(&gotAutoVacuum); err != nil {
+			return nil, timing, err
+		}
+		if want := slices.Index(AutoVacuumModes, cfg.AutoVacuum); gotAutoVacuum != want {
+			return nil, timing, fmt.Errorf("sqlite: %s: auto_vacuum %d (%s) requested, got %d", fn, want, cfg.AutoVacuum, gotAutoVacuum)
+		}
+	}
+
+	mode, autocheckpoint := cfg.JournalMode, cfg.WALAutocheckpoint
+	// WAL is recorded in the database file, the other modes only apply to
+	// the connection that set them, which is the pool's one idle writer
+	// connection unless -race-check shares db with the readers.
+	var gotMode string
+	if err = db.QueryRow("pragma journal_mode=" + mode).Scan(&gotMode); err != nil {
+		return nil, timing, err
+	}
+	if gotMode != mode {
+		// SQLite answers with the mode in effect when it refuses the change,
+		// e.g. WAL on a VFS without shared memory.
+		return nil, timing, fmt.Errorf("sqlite: %s: journal_mode %s requested, got %s", fn, mode, gotMode)
+	}
+	if mode == "wal" {
+		if _, err = db.Exec(fmt.Sprintf("pragma wal_autocheckpoint=%d", autocheckpoint)); err != nil {
+			return nil, timing, err
+		}
+	}
+
+	columns := "i int, str text"
+	if cfg.BlobSize > 0 {
+		columns += ", b blob"
+	}
+	// A -sql-file brings its own schema.
+	for k := 0; k < cfg.Tables && cfg.SQLFile == ""; k++ {
+		table := cfg.tableName(k)
+		if _, err = db.Exec(`
+drop table if exists ` + table + `;
+create table ` + table + `(` + columns + `);
+`); err != nil {
+			return nil, timing, err
+		}
+	}
+	if cfg.CreateIndex {
+		if err = createIndex(out, db, fn); err != nil {
+			return nil, timing, err
+		}
+	}
+	if cfg.PhaseSnapshots {
+		snapshotPhase(fn, "schema", cfg)
+	}
+	if cfg.AttachCount > 0 {
+		if err = attachDatabases(ctx, db, fn, rng, cfg); err != nil {
+			return nil, timing, err
+		}
+	}
+
+	// With -auto-checkpoint-interval a goroutine checkpoints the WAL from
+	// the inserts until the readers are done.
+	ckptCtx, stopCkpts := context.WithCancel(ctx)
+	ckpts := sync.WaitGroup{}
+	defer func() {
+		stopCkpts()
+		ckpts.Wait()
+	}()
+	var ckptCount int
+	var ckptErr error
+	if cfg.AutoCheckpointInterval > 0 && mode == "wal" {
+		ckpts.Add(1)
+		go func() {
+			defer ckpts.Done()
+			ckptCount, ckptErr = checkpointPeriodically(ckptCtx, out, db, fn, cfg.AutoCheckpointInterval)
+		}()
+	}
+
+	insertStart := time.Now()
+	endInserts := hooks.phase("inserts", track)
+	var stmts []sqlStatement
+	if cfg.SQLFile != "" {
+		if stmts, err = LoadSQLFile(cfg.SQLFile); err != nil {
+			return nil, timing, err
+		}
+		// Every execution counts as an inserted row.
+		timing.InsertRows, err = runSQL(ctx, db, stmts, cfg)
+	} else if cfg.Writers > 1 {
+		timing.InsertRows, err = concurrentInserts(ctx, db, fn, rng, cfg)
+	} else {
+		timing.InsertRows, err = inserts(ctx, db, rng, cfg)
+	}
+	endInserts()
+	timing.Inserts = time.Since(insertStart)
+	var full *fullError
+	if cfg.MaxPageCount > 0 && errors.As(err, &full) {
+		timing.InsertRows = full.Rows
+		if err = reportFull(db, fn, full, cfg); err != nil {
+			return nil, timing, err
+		}
+	}
+	if err != nil {
+		return nil, timing, err
+	}
+	if cfg.PhaseSnapshots {
+		snapshotPhase(fn, "inserts", cfg)
+	}
+	if cfg.SQLFile == "" {
+		if err = checkRowCount(db, fn, cfg, timing.InsertRows); err != nil {
+			return nil, timing, err
+		}
+	} else {
+		fmt.Fprintf(out, "sql-file: %s: %d statements, %d executions in %v\n", fn, len(stmts), timing.InsertRows, timing.Inserts.Round(time.Millisecond))
+	}
+	if cfg.InsertRate > 0 {
+		// Pacing only ever slows inserts down, so falling short of the
+		// target means the writer can't keep up at this rate. Only the rows
+		// committed count, a run cut short or rolled back wrote fewer.
+		achieved := RowsPerSecond(timing.InsertRows, timing.Inserts)
+		status := "achieved"
+		if achieved < 0.95*float64(cfg.InsertRate) {
+			status = "missed"
+		}
+		fmt.Fprintf(out, "rate: %s: target=%d rows/s actual=%.0f rows/s %s\n", fn, cfg.InsertRate, achieved, status)
+	}
+	//fmt.Println("inserts done")
+	if cfg.Analyze {
+		if err = analyze(ctx, out, db, fn, cfg.Inserts); err != nil {
+			return nil, timing, err
+		}
+	}
+	var files dbFileSizes
+	if !cfg.InMemory {
+		files = statDBFiles(fn, cfg.PageSize, mode == "wal")
+		fmt.Fprintf(out, "file-size: %s: after inserts %v\n", fn, files)
+	}
+	if cfg.DeleteRatio > 0 {
+		if err = deleteRows(ctx, db, fn, cfg.tableName(0), rng, cfg.DeleteRatio, cfg); err != nil {
+			return nil, timing, err
+		}
+		if !cfg.InMemory {
+			files = statDBFiles(fn, cfg.PageSize, mode == "wal")
+			fmt.Fprintf(out, "file-size: %s: after deletes %v\n", fn, files)
+		}
+	}
+	if cfg.AutoVacuum == "incremental" {
+		if err = incrementalVacuum(ctx, out, db, fn, cfg.IncrementalVacuumPages); err != nil {
+			return nil, timing, err
+		}
+		if !cfg.InMemory {
+			files = statDBFiles(fn, cfg.PageSize, mode == "wal")
+			fmt.Fprintf(out, "file-size: %s: after incremental vacuum %v\n", fn, files)
+		}
+	}
+	if cfg.TempStore != "" {
+		if err = probeTempStore(ctx, out, db, fn, cfg.tableName(0)); err != nil {
+			return nil, timing, err
+		}
+	}
+	if cfg.CheckpointMode != "" && mode == "wal" {
+		if err = checkpoint(ctx, out, db, fn, cfg.CheckpointMode); err != nil {
+			return nil, timing, err
+		}
+		files = statDBFiles(fn, cfg.PageSize, true)
+		fmt.Fprintf(out, "file-size: %s: after checkpoint %v\n", fn, files)
+	}
+	if cfg.VacuumAfter {
+		if err = vacuum(ctx, out, db, fn); err != nil {
+			return nil, timing, err
+		}
+		if !cfg.InMemory {
+			files = statDBFiles(fn, cfg.PageSize, mode == "wal")
+			fmt.Fprintf(out, "file-size: %s: after vacuum %v\n", fn, files)
+		}
+	}
+	if !cfg.InMemory {
+		recordDBFiles(files)
+	}
+
+	if cfg.BusyTimeout > 0 {
+		roParams.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", cfg.BusyTimeout.Milliseconds()))
+	}
+	if cfg.CacheSize != 0 {
+		roParams.Add("_pragma", fmt.Sprintf("cache_size(%d)", cfg.CacheSize))
+	}
+	if cfg.VerifyMmap {
+		roParams.Add("_pragma", fmt.Sprintf("mmap_size(%d)", verifyMmapSize))
+	}
+	roDSN := connDSN(dsnName, roParams, cfg.DSNParams)
+
+	// readersMu guards what the reader goroutines report back.
+	var readersMu sync.Mutex
+	var readerErrs []error
+	var cacheNoCursors, cacheOpenCursors int64
+	var blobBefore, blobScanned, blobClosed stmtMemory
+	// With a selectivity or iteration count set, every reader's rows and
+	// CACHE_USED are printed.
+	selectReport := cfg.SelectSelectivity < 1 || cfg.SelectIterations > 1
+	readerRows, readerCache := make([]int, cfg.ParallelSelects), make([]int32, cfg.ParallelSelects)
+	// With -warm-cache every reader runs the select once before the timed
+	// ones, and waits on measuring for the others to have done the same.
+	warmed, measuring := sync.WaitGroup{}, sync.WaitGroup{}
+	measuring.Add(1)
+	var coldSelects, warmSelects time.Duration
+	var warmCacheUsed int64
+	// The pools are all opened before any goroutine starts, so that a
+	// failure leaves nothing running.
+	for i := 0; i < cfg.ParallelSelects; i++ {
+		roDb, err := sql.Open(hooks.driver(), roDSN)
+		if err != nil {
+			return nil, timing, err
+		}
+		configurePool(roDb, cfg)
+		roDbs = append(roDbs, roDb)
+	}
+	selectStart := time.Now()
+	endSelects := hooks.phase("selects", track)
+	// Every reader queries one table picked at random from rng, before the
+	// writers below start using it.
+	readerTables := make([]string, cfg.ParallelSelects)
+	for i := range readerTables {
+		readerTables[i] = cfg.tableName(rng.Intn(cfg.Tables))
+	}
+	wg := sync.WaitGroup{}
+	// With -ro-hold the readers keep re-running the selects, and a writer
+	// keeps inserting, until holdCtx is done.
+	holdCtx := ctx
+	heldWrites := 0
+	if cfg.ROHold > 0 {
+		var cancelHold context.CancelFunc
+		holdCtx, cancelHold = context.WithTimeout(ctx, cfg.ROHold)
+		defer cancelHold()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := holdWrites(holdCtx, db, rng, cfg)
+			if err != nil && holdOver(holdCtx, ctx) {
+				err = nil
+			}
+			readersMu.Lock()
+			defer readersMu.Unlock()
+			heldWrites = rows
+			if err != nil {
+				readerErrs = append(readerErrs, err)
+			}
+		}()
+	}
+	// With -background-writes a writer keeps inserting until the readers are
+	// done.
+	writeCtx, stopWrites := context.WithCancel(ctx)
+	defer stopWrites()
+	writes := sync.WaitGroup{}
+	var bgRows int
+	var bgCacheUsedMax int32
+	if cfg.BackgroundWrites {
+		writes.Add(1)
+		go func() {
+			defer writes.Done()
+			rows, cacheUsedMax, err := writeInBackground(writeCtx, db, rng, cfg)
+			if err != nil && holdOver(writeCtx, ctx) {
+				err = nil
+			}
+			readersMu.Lock()
+			defer readersMu.Unlock()
+			bgRows, bgCacheUsedMax = rows, cacheUsedMax
+			if err != nil {
+				readerErrs = append(readerErrs, err)
+			}
+		}()
+	}
+	for i := 0; i < cfg.ParallelSelects; i++ {
+		wg.Add(1)
+		if cfg.WarmCache {
+			warmed.Add(1)
+		}
+		roDb := roDbs[i]
+		go func() {
+			defer wg.Done()
+			PinGoroutine(cfg)
+			bound := cfg.SelectBound()
+			table := readerTables[i]
+			var rows int
+			var err error
+			var cold, warm time.Duration
+			var cacheWarm int32
+			if cfg.WarmCache {
+				coldStart := time.Now()
+				if _, err = selects(ctx, roDb, table, bound, cfg); err == nil {
+					cold = time.Since(coldStart)
+					cacheWarm, err = poolCacheUsed(roDb)
+				}
+				warmed.Done()
+				measuring.Wait()
+			}
+			warmStart := time.Now()
+			for k := 0; k < cfg.SelectIterations && err == nil; k++ {
+				var more int
+				more, err = selects(ctx, roDb, table, bound, cfg)
+				rows += more
+			}
+			warm = time.Since(warmStart) / time.Duration(cfg.SelectIterations)
+			if err == nil && cfg.RaceCheck {
+				var more int
+				more, err = selects(ctx, db, table, bound, cfg)
+				rows += more
+			}
+			for err == nil && cfg.ROHold > 0 && holdCtx.Err() == nil {
+				var more int
+				more, err = selects(holdCtx, roDb, table, bound, cfg)
+				rows += more
+			}
+			if err != nil && cfg.ROHold > 0 && holdOver(holdCtx, ctx) {
+				err = nil
+			}
+			var none, open int32
+			if err == nil && cfg.OpenCursors > 0 {
+				none, open, err = holdCursors(roDb, cfg.OpenCursors, cfg.Inserts)
+			}
+			var before, scanned, closed stmtMemory
+			if err == nil && cfg.VerifyBlobFree {
+				before, scanned, closed, err = checkBlobFree(roDb, cfg.Inserts)
+			}
+			var cacheUsed int32
+			if err == nil && selectReport {
+				cacheUsed, err = poolCacheUsed(roDb)
+			}
+			readersMu.Lock()
+			defer readersMu.Unlock()
+			if err != nil {
+				readerErrs = append(readerErrs, err)
+				return
+			}
+			timing.SelectRows += rows
+			cacheNoCursors += int64(none)
+			cacheOpenCursors += int64(open)
+			blobBefore.add(before)
+			blobScanned.add(scanned)
+			blobClosed.add(closed)
+			readerRows[i], readerCache[i] = rows, cacheUsed
+			coldSelects += cold
+			warmSelects += warm
+			warmCacheUsed += int64(cacheWarm)
+		}()
+	}
+	if cfg.WarmCache {
+		// The timed selects start once every reader is warm.
+		warmed.Wait()
+		selectStart = time.Now()
+		measuring.Done()
+	}
+	if cfg.SingleConn {
+		// The selects take turns on db's one connection, on this goroutine.
+		bound := cfg.SelectBound()
+		for k := 0; k < cfg.SelectIterations; k++ {
+			rows, err := selects(ctx, db, cfg.tableName(k%cfg.Tables), bound, cfg)
+			if err != nil {
+				readerErrs = append(readerErrs, err)
+				break
+			}
+			timing.SelectRows += rows
+		}
+	}
+	wg.Wait()
+	stopWrites()
+	writes.Wait()
+	endSelects()
+	timing.Selects = time.Since(selectStart)
+	stopCkpts()
+	ckpts.Wait()
+	if ckptErr != nil {
+		readerErrs = append(readerErrs, ckptErr)
+	} else if cfg.AutoCheckpointInterval > 0 && mode == "wal" {
+		fmt.Fprintf(out, "auto-checkpoint: %s: %d checkpoints every %v, wal=%d\n", fn, ckptCount, cfg.AutoCheckpointInterval, fileSize(fn+"-wal"))
+	}
+	if len(readerErrs) > 0 {
+		return nil, timing, fmt.Errorf("%s: %w", fn, errors.Join(readerErrs...))
+	}
+	if cfg.PhaseSnapshots {
+		snapshotPhase(fn, "selects", cfg)
+	}
+
+	if selectReport {
+		for i := range readerRows {
+			fmt.Fprintf(out, "selects: %s: reader=%d selectivity=%g bound=%d iterations=%d rows=%d CACHE_USED=%d\n",
+				fn, i, cfg.SelectSelectivity, cfg.SelectBound(), cfg.SelectIterations, readerRows[i], readerCache[i])
+		}
+	}
+
+	if cfg.SingleConn {
+		fmt.Fprintf(out, "single-conn: %s: open_connections=%d rows_read=%d inserts=%v selects=%v\n",
+			fn, db.Stats().OpenConnections, timing.SelectRows, timing.Inserts.Round(time.Millisecond), timing.Selects.Round(time.Millisecond))
+	}
+
+	if cfg.WarmCache && cfg.ParallelSelects > 0 {
+		readers := time.Duration(cfg.ParallelSelects)
+		fmt.Fprintf(out, "warm-cache: %s: readers=%d select mean cold=%v warm=%v CACHE_USED after warming=%d\n",
+			fn, cfg.ParallelSelects, (coldSelects / readers).Round(time.Microsecond), (warmSelects / readers).Round(time.Microsecond), warmCacheUsed)
+	}
+
+	if cfg.BackgroundWrites {
+		cacheUsedAtRest, err := poolCacheUsed(db)
+		if err != nil {
+			return nil, timing, err
+		}
+		fmt.Fprintf(out, "background-writes: %s: rows=%d CACHE_USED writing_max=%d at_rest=%d\n", fn, bgRows, bgCacheUsedMax, cacheUsedAtRest)
+	}
+
+	if cfg.SharedCache {
+		if err = CheckSharedCache(ctx, out, db, fn, len(roDbs)); err != nil {
+			return nil, timing, err
+		}
+	}
+
+	if cfg.ROHold > 0 {
+		fmt.Fprintf(out, "ro-hold: %s: %v readers=%d rows_read=%d rows_inserted=%d\n", fn, cfg.ROHold, cfg.ParallelSelects, timing.SelectRows, heldWrites)
+	}
+
+	if cfg.OpenCursors > 0 {
+		fmt.Fprintf(out, "open-cursors: %s: readers=%d cursors=%d CACHE_USED none_open=%d all_open=%d\n",
+			fn, cfg.ParallelSelects, cfg.OpenCursors, cacheNoCursors, cacheOpenCursors)
+	}
+
+	if cfg.VerifyBlobFree {
+		fmt.Fprintf(out, "verify-blob-free: %s: readers=%d STMT_USED before=%d scanned=%d closed=%d CACHE_USED before=%d scanned=%d closed=%d\n",
+			fn, cfg.ParallelSelects, blobBefore.StmtUsed, blobScanned.StmtUsed, blobClosed.StmtUsed, blobBefore.CacheUsed, blobScanned.CacheUsed, blobClosed.CacheUsed)
+	}
+
+	if cfg.VerifyMmap && len(roDbs) > 0 {
+		// SQLite silently clamps mmap_size to SQLITE_MAX_MMAP_SIZE, so
+		// report what the connection actually uses.
+		var mmapSize int64
+		if err = roDbs[0].QueryRow("pragma mmap_size").Scan(&mmapSize); err != nil {
+			return nil, timing, err
+		}
+		fmt.Fprintf(out, "verify-mmap: %s: requested mmap_size=%d effective mmap_size=%d\n", fn, verifyMmapSize, mmapSize)
+	}
+
+	if cfg.MmapSize > 0 {
+		if err = reportMmap(db, roDbs, fn, cfg); err != nil {
+			return nil, timing, err
+		}
+	}
+
+	if cfg.WALShm > 0 {
+		if err = reportWALShm(out, db, roDbs, fn, cfg.WALAutocheckpoint); err != nil {
+			return nil, timing, err
+		}
+	}
+	if cfg.IntegrityCheck || cfg.FaultInjectRate > 0 {
+		if err = integrityCheck(ctx, db, fn, cfg); err != nil {
+			return nil, timing, err
+		}
+	}
+	if cfg.StatusSource == "pragma" {
+		if err = recordPragmaStatus(ctx, out, db, fn, 1+len(roDbs)); err != nil {
+			return nil, timing, err
+		}
+	}
+	recordPoolStats(db, roDbs)
+
+	closeFunc := closeOnce(out, cfg, fn, db, roDbs)
+	if cfg.PhaseSnapshots {
+		// Only the first call closes anything, and only it takes the snapshot.
+		return sync.OnceValue(func() error {
+			err := closeFunc()
+			snapshotPhase(fn, "close", cfg)
+			return err
+		}), timing, nil
+	}
+	return closeFunc, timing, nil
+}
+
+// keepTempMax caps how many databases -keep-temp keeps, a -duration run would
+// otherwise fill the disk.
+const keepTempMax = 100
+
+// keptTempDBs counts the databases keepTempDB said to keep.
+var keptTempDBs atomic.Int64
+
+// keepTempDB reports whether the database being created is to be left on disk
+// after the run: under -keep-temp, until keepTempMax are kept.
+func keepTempDB(cfg *Config) bool {
+	if !cfg.KeepTemp {
+		return false
+	}
+	n := keptTempDBs.Add(1)
+	if n == keepTempMax+1 {
+		fmt.Fprintf(os.Stderr, "warning: keep-temp: kept %d databases, removing the others\n", keepTempMax)
+	}
+	return n <= keepTempMax
+}
+
+// fileSize returns the size of the named file, or 0 if it does not exist.
+func fileSize(name string) int64 {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// connDSN returns the DSN of fn with params and then extra, each a key=value
+// pair, as its query string.
+func connDSN(fn string, params url.Values, extra []string) string {
+	q := url.Values{}
+	for k, vs := range params {
+		q[k] = append(q[k], vs...)
+	}
+	for _, p := range extra {
+		k, v, _ := strings.Cut(p, "=")
+		q.Add(k, v)
+	}
+	if len(q) == 0 {
+		return fn
+	}
+	return fn + "?" + q.Encode()
+}
+
+// memDBs numbers the -in-memory databases, whose names must differ for them
+// to stay apart in the shared cache.
+var memDBs atomic.Int64
+
+// verifyMmapSize is the mmap_size requested under -verify-mmap. It is well
+// above any database the repro creates, so every read can be served from the
+// mapping.
+const verifyMmapSize = 1 << 30
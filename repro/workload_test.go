@@ -0,0 +1,281 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// openTestDB creates a database with the single table t in a temporary
+// directory and returns it and its file name.
+func openTestDB(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "db")
+	db, err := sql.Open("sqlite", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec("create table t(i int, str text)"); err != nil {
+		t.Fatal(err)
+	}
+	return db, fn
+}
+
+// testConfig is the Config the tests insert with, n rows in transactions of
+// commitEvery.
+func testConfig(n, commitEvery int) *Config {
+	return &Config{
+		Inserts:           n,
+		CommitEvery:       commitEvery,
+		MinStrSize:        5,
+		MaxStrSize:        20,
+		IntDistribution:   "sequential",
+		Tables:            1,
+		SelectSelectivity: 1,
+		Writers:           1,
+		MaxIdleConns:      -1,
+	}
+}
+
+func TestInserts(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		n, commitEvery int
+	}{
+		{"one row", 1, 100},
+		{"multiple of commit-every", 300, 100},
+		{"partial last transaction", 250, 100},
+		{"commit-every above n", 42, 100},
+		{"a transaction per row", 10, 1},
+		{"single transaction", 73, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fn := openTestDB(t)
+			cfg := testConfig(tt.n, tt.commitEvery)
+			inserted, err := inserts(context.Background(), db, rand.New(rand.NewSource(1)), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inserted != tt.n {
+				t.Errorf("inserts reported %d rows, want %d", inserted, tt.n)
+			}
+			if err = checkRowCount(db, "db", cfg, inserted); err != nil {
+				t.Error(err)
+			}
+
+			// Count from a connection of its own, which only sees the
+			// rows that were committed.
+			other, err := sql.Open("sqlite", fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer other.Close()
+			var rows, minLen, maxLen, minI, maxI int
+			if err = other.QueryRow("select count(*), min(length(str)), max(length(str)), min(i), max(i) from t").Scan(&rows, &minLen, &maxLen, &minI, &maxI); err != nil {
+				t.Fatal(err)
+			}
+			if rows != tt.n {
+				t.Errorf("%d rows committed, want %d", rows, tt.n)
+			}
+			if minLen < cfg.MinStrSize || maxLen >= cfg.MaxStrSize {
+				t.Errorf("string lengths [%d, %d], want within [%d, %d)", minLen, maxLen, cfg.MinStrSize, cfg.MaxStrSize)
+			}
+			if minI != 0 || maxI != tt.n-1 {
+				t.Errorf("i from %d to %d, want 0 to %d", minI, maxI, tt.n-1)
+			}
+		})
+	}
+}
+
+func TestSelects(t *testing.T) {
+	const n = 100
+	db, _ := openTestDB(t)
+	if _, err := inserts(context.Background(), db, rand.New(rand.NewSource(1)), testConfig(n, 30)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		maxValue, want int
+	}{
+		{0, 0},
+		{1, 1},
+		{37, 37},
+		{n, n},
+		{n + 10, n},
+	} {
+		got, err := selects(context.Background(), db, "t", tt.maxValue, testConfig(n, 30))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("selects(i < %d) = %d rows, want %d", tt.maxValue, got, tt.want)
+		}
+	}
+}
+
+func TestRunWorkload(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.DBCount, cfg.ParallelSelects, cfg.Seed, cfg.TempDir = 200, 2, 3, 1, t.TempDir()
+	res, err := RunWorkload(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+	for i, timing := range res.Timings {
+		if timing.InsertRows != cfg.Inserts || timing.SelectRows != cfg.Inserts*cfg.ParallelSelects {
+			t.Errorf("database %d: %d rows inserted and %d selected, want %d and %d", i, timing.InsertRows, timing.SelectRows, cfg.Inserts, cfg.Inserts*cfg.ParallelSelects)
+		}
+	}
+	for _, stat := range res.Status {
+		if stat.Op == sqlite3.SQLITE_DBSTATUS_CACHE_USED && stat.Current <= 0 {
+			t.Errorf("CACHE_USED=%d with every connection open, want it above zero", stat.Current)
+		}
+	}
+}
+
+// TestRunWorkloadWriters runs several writers on one database and checks the
+// read-write pool kept every connection they opened, so the handles read for
+// Status were all still open.
+func TestRunWorkloadWriters(t *testing.T) {
+	pools.mu.Lock()
+	before := pools.rw
+	pools.mu.Unlock()
+
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.DBCount, cfg.Writers, cfg.Seed, cfg.TempDir = 400, 1, 4, 1, t.TempDir()
+	// The writers take turns at the write lock instead of failing with
+	// SQLITE_BUSY.
+	cfg.BusyTimeout = 10 * time.Second
+	res, err := RunWorkload(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	pools.mu.Lock()
+	after := pools.rw
+	pools.mu.Unlock()
+	if closed := after.IdleClosed - before.IdleClosed; closed != 0 {
+		t.Errorf("the rw pool closed %d idle connections of the %d writers, their handles were read freed", closed, cfg.Writers)
+	}
+	if res.Timings[0].InsertRows != cfg.Inserts {
+		t.Errorf("%d rows inserted, want %d", res.Timings[0].InsertRows, cfg.Inserts)
+	}
+	for _, stat := range res.Status {
+		if stat.Op == sqlite3.SQLITE_DBSTATUS_CACHE_USED && stat.Current <= 0 {
+			t.Errorf("CACHE_USED=%d with every connection open, want it above zero", stat.Current)
+		}
+	}
+}
+
+// TestRunWorkloadPoolCloses runs several writers on a pool keeping one idle
+// connection, closing the others the writers return, and checks Status has
+// the db_status of the connections it kept.
+func TestRunWorkloadPoolCloses(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.DBCount, cfg.Writers, cfg.Seed, cfg.TempDir = 400, 1, 4, 1, t.TempDir()
+	cfg.MaxIdleConns, cfg.MaxOpenConns, cfg.BusyTimeout = 1, 4, 10*time.Second
+	res, err := RunWorkload(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Errors) > 0 {
+		t.Fatal(res.Errors)
+	}
+
+	if len(res.Status) == 0 {
+		t.Fatal("no db_status")
+	}
+	for _, stat := range res.Status {
+		if stat.Op == sqlite3.SQLITE_DBSTATUS_CACHE_USED && stat.Current <= 0 {
+			t.Errorf("CACHE_USED=%d, want the cache of the idle connection", stat.Current)
+		}
+	}
+}
+
+// TestRunWorkloadForgetsConns runs with the per-connection alloc phases and
+// fault exemptions and checks none of them is left behind by the connections.
+func TestRunWorkloadForgetsConns(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.DBCount, cfg.Seed, cfg.TempDir = 100, 2, 1, t.TempDir()
+	// No fault injector is installed, the rate only exempts the integrity
+	// check.
+	cfg.AllocPhases, cfg.FaultInjectRate = true, 1
+	if _, err := RunWorkload(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for name, m := range map[string]*sync.Map{"connPhases": &connPhases, "faultExempt": &faultExempt} {
+		m.Range(func(tls, _ any) bool {
+			t.Errorf("%s still holds %p", name, tls)
+			return true
+		})
+	}
+}
+
+// hookedConns are the connections opened through the hookedDriver driver.
+var hookedConns struct {
+	sync.Mutex
+	conns []sqlite.ExecQuerierContext
+}
+
+// hookedDriver is the name of a driver recording every connection it opens
+// in hookedConns, registered by registerHookedDriver.
+const hookedDriver = "sqlite-repro-test"
+
+var registerHookedDriver = sync.OnceFunc(func() {
+	d := &sqlite.Driver{}
+	d.RegisterConnectionHook(func(conn sqlite.ExecQuerierContext, dsn string) error {
+		hookedConns.Lock()
+		defer hookedConns.Unlock()
+		hookedConns.conns = append(hookedConns.conns, conn)
+		return nil
+	})
+	sql.Register(hookedDriver, d)
+})
+
+// TestCreateAndTestDbCancelled cancels a database before its inserts and
+// checks its close function still closes every connection it opened.
+func TestCreateAndTestDbCancelled(t *testing.T) {
+	registerHookedDriver()
+	hookedConns.Lock()
+	hookedConns.conns = nil
+	hookedConns.Unlock()
+
+	cfg := DefaultConfig()
+	cfg.Inserts, cfg.ParallelSelects, cfg.Seed, cfg.TempDir = 100, 2, 1, t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	closeFunc, _, err := CreateAndTestDb(ctx, &cfg, Hooks{Driver: hookedDriver}, "", rand.New(rand.NewSource(1)))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CreateAndTestDb: %v, want context.Canceled", err)
+	}
+	if closeFunc == nil {
+		t.Fatal("no close function for the cancelled database")
+	}
+	if err = closeFunc(); err != nil {
+		t.Fatal(err)
+	}
+
+	hookedConns.Lock()
+	defer hookedConns.Unlock()
+	if len(hookedConns.conns) == 0 {
+		t.Fatal("no connection opened before the inserts")
+	}
+	for i, conn := range hookedConns.conns {
+		if _, err := ConnDBHandle(conn); err == nil {
+			t.Errorf("connection %d still open after close", i)
+		}
+	}
+}
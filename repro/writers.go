@@ -0,0 +1,55 @@
+package repro
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// concurrentInserts splits cfg.Inserts, and cfg.InsertRate so that together
+// they insert at the database's rate, between -writers goroutines that each
+// run inserts on db at the same time, so on connections of their own, prints
+// every writer's rows and throughput and returns the rows they committed
+// together. Each writer's data comes from a seed drawn from rng.
+func concurrentInserts(ctx context.Context, db *sql.DB, fn string, rng *rand.Rand, cfg *Config) (int, error) {
+	n := cfg.Writers
+	rows := make([]int, n)
+	elapsed := make([]time.Duration, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		writerCfg := *cfg
+		writerCfg.Inserts = cfg.Inserts / n
+		if w < cfg.Inserts%n {
+			writerCfg.Inserts++
+		}
+		writerCfg.InsertRate = cfg.InsertRate / n
+		if w < cfg.InsertRate%n {
+			writerCfg.InsertRate++
+		}
+		writerRng := rand.New(rand.NewSource(rng.Int63()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			rows[w], errs[w] = inserts(ctx, db, writerRng, &writerCfg)
+			elapsed[w] = time.Since(start)
+			if errs[w] != nil {
+				errs[w] = fmt.Errorf("writer %d: %w", w, errs[w])
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for w := range rows {
+		total += rows[w]
+		fmt.Fprintf(cfg.out(), "writers: %s: writer=%d rows=%d elapsed=%v rate=%.0f rows/s\n",
+			fn, w, rows[w], elapsed[w].Round(time.Millisecond), RowsPerSecond(rows[w], elapsed[w]))
+	}
+	return total, errors.Join(errs...)
+}
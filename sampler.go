@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// runSampler reads db_status for every connection readConns passes to its
+// callback each interval until stop is closed. The handles are read within
+// the callback, so that none of them is closed while it is being read. At every
+// resetInterval boundary it reads with reset=1, so each printed peak is the
+// highest value reached within that window, and with reset=0 in between. A
+// zero resetInterval never resets and prints nothing, the samples then only
+// feed statsd.
+//
+// SQLite only tracks a highwater for some ops (CACHE_USED, SCHEMA_USED and
+// STMT_USED always report zero), so the printed peak is the larger of the sum
+// of per-connection highwaters and the largest sum of currents observed by the
+// samples taken within the window.
+//
+// Every printed window also carries the CACHE_USED of the read-only and of the
+// read-write connections and the Go heap, all read at its end, and with
+// -mmap-size the process RSS.
+//
+// If statsd is not nil every sample is also sent to it, and with -chrome-trace
+// recorded as a counter event.
+//
+// With align set the samples fall on multiples of interval in wall-clock time,
+// so they line up with an external scraper polling at the same interval, and
+// window bounds are printed as wall-clock times instead of offsets from the
+// start.
+func runSampler(stop <-chan struct{}, interval, resetInterval time.Duration, readConns func(read func([]registeredConn)), statsd *statsdSink, align bool, cfg *Config) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	if align {
+		// A ticker keeps the phase it was created with, so create it on a
+		// boundary. Ticks then only drift by scheduling latency, which
+		// rounding each one to the interval absorbs.
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(time.Now().Truncate(interval).Add(interval))):
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	if align {
+		start = start.Round(interval)
+	}
+	formatTime := func(t time.Time) string {
+		if align {
+			return t.Format("2006-01-02T15:04:05.000Z07:00")
+		}
+		return t.Sub(start).Round(time.Millisecond).String()
+	}
+	windowStart := start
+	window := 0
+	sampled := make(map[int32]int64)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if align {
+				now = now.Round(interval)
+			}
+			var reset int32
+			if resetInterval > 0 && now.Sub(windowStart) >= resetInterval {
+				reset = 1
+			}
+
+			current := make(map[int32]int64)
+			peak := make(map[int32]int64)
+			var roConns, rwConns int
+			var roCache, rwCache int64
+			readConns(func(conns []registeredConn) {
+				for _, c := range conns {
+					readOnly := isReadOnlyDSN(c.dsn)
+					if readOnly {
+						roConns++
+					} else {
+						rwConns++
+					}
+					for _, op := range repro.DBStatusOps {
+						cur, highwater, err := repro.DBStatus(tls, c.handle, op, reset)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "warning: sampler: %s: %v\n", c.dsn, err)
+							continue
+						}
+						current[op] += int64(cur)
+						peak[op] += int64(highwater)
+						if op != sqlite3.SQLITE_DBSTATUS_CACHE_USED {
+							continue
+						}
+						if readOnly {
+							roCache += int64(cur)
+						} else {
+							rwCache += int64(cur)
+						}
+					}
+				}
+			})
+			for op, v := range current {
+				sampled[op] = max(sampled[op], v, peak[op])
+			}
+			if statsd != nil {
+				statsd.send(tls, current)
+			}
+			if timeline != nil {
+				values := make(map[string]int64)
+				for op, v := range current {
+					values[repro.DBStatusOpName(op)] = v
+				}
+				timeline.counter("db_status", values)
+			}
+
+			if reset == 0 {
+				continue
+			}
+
+			var b strings.Builder
+			fmt.Fprintf(&b, "sampler: window=%d start=%s end=%s", window, formatTime(windowStart), formatTime(now))
+			for _, op := range repro.DBStatusOps {
+				fmt.Fprintf(&b, " %s=%d", repro.DBStatusOpName(op), sampled[op])
+			}
+			fmt.Fprintf(&b, " ro_conns=%d ro_CACHE_USED=%d rw_conns=%d rw_CACHE_USED=%d", roConns, roCache, rwConns, rwCache)
+			fmt.Fprintf(&b, " %s", repro.ReadGoHeap(cfg.GCBeforeSample))
+			if cfg.MmapSize > 0 {
+				fmt.Fprintf(&b, " %s", repro.RSSField())
+			}
+			fmt.Println(b.String())
+
+			window++
+			windowStart = now
+			sampled = make(map[int32]int64)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"modernc.org/libc"
+	"modernc.org/libc/sys/types"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// scratchSlotSize is the size of one -scratch-bytes slot.
+const scratchSlotSize = 4096
+
+// configureScratch hands SQLite a sizeBytes buffer of scratchSlotSize slots
+// with SQLITE_CONFIG_SCRATCH, the pool SQLite used to serve sorting and other
+// temporary allocations from. SQLite dropped scratch memory in 3.22.0 and
+// rejects the option since, its sorter allocating from the heap instead, so
+// on such a build configureScratch only returns an error saying so. It must
+// run before SQLite is initialized.
+func configureScratch(tls *libc.TLS, sizeBytes int32) error {
+	buf := libc.Xmalloc(tls, types.Size_t(sizeBytes))
+	if buf == 0 {
+		return fmt.Errorf("sqlite: configure scratch: cannot allocate %d bytes", sizeBytes)
+	}
+	list := libc.NewVaList(buf, int32(scratchSlotSize), sizeBytes/scratchSlotSize)
+	if list == 0 {
+		libc.Xfree(tls, buf)
+		return fmt.Errorf("sqlite: configure scratch: cannot allocate memory")
+	}
+	defer libc.Xfree(tls, list)
+
+	if rc := sqlite3.Xsqlite3_config(tls, sqlite3.SQLITE_CONFIG_SCRATCH, list); rc != sqlite3.SQLITE_OK {
+		libc.Xfree(tls, buf)
+		if sqlite3.Xsqlite3_libversion_number(tls) >= 3022000 {
+			return fmt.Errorf("sqlite: SQLITE_CONFIG_SCRATCH is not supported by SQLite %s, scratch memory was removed in 3.22.0 and sorts allocate from the heap, see MEMORY_USED", libc.GoString(sqlite3.Xsqlite3_libversion(tls)))
+		}
+		return fmt.Errorf("sqlite: failed to configure SQLITE_CONFIG_SCRATCH: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
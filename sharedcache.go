@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// sumCacheUsedShared sums the CACHE_USED_SHARED of conns, which counts every
+// shared page cache once. The reads that fail add nothing and are returned.
+func sumCacheUsedShared(tls *libc.TLS, conns []uintptr) (int64, []error) {
+	var sum int64
+	var errs []error
+	for i, c := range conns {
+		shared, _, err := repro.DBStatus(tls, c, sqlite3.SQLITE_DBSTATUS_CACHE_USED_SHARED, 0)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connection %d: %w", i, err))
+			continue
+		}
+		sum += int64(shared)
+	}
+	return sum, errs
+}
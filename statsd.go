@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// statsdSink sends the sampler's gauges to a StatsD server over UDP. Send
+// errors are logged and otherwise ignored, a soak run must not die because
+// the metrics pipeline is down.
+type statsdSink struct {
+	conn net.Conn
+	// failing suppresses repeated logging while the server keeps rejecting
+	// packets.
+	failing bool
+}
+
+func newStatsdSink(addr string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{conn: conn}, nil
+}
+
+// send writes MEMORY_USED and the summed db_status currents of one sample as
+// gauges in a single packet, e.g. sqlite.memused:1234|g.
+func (s *statsdSink) send(tls *libc.TLS, current map[int32]int64) {
+	memUsed, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	var b strings.Builder
+	fmt.Fprintf(&b, "sqlite.memused:%d|g", memUsed)
+	for _, op := range repro.DBStatusOps {
+		fmt.Fprintf(&b, "\nsqlite.%s:%d|g", strings.ToLower(repro.DBStatusOpName(op)), current[op])
+	}
+
+	_, err := s.conn.Write([]byte(b.String()))
+	switch {
+	case err != nil && !s.failing:
+		fmt.Fprintf(os.Stderr, "statsd: %v\n", err)
+		s.failing = true
+	case err == nil && s.failing:
+		fmt.Fprintln(os.Stderr, "statsd: sending again")
+		s.failing = false
+	}
+}
+
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
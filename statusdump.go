@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"modernc.org/libc"
+	"sqlite-repro/repro"
+)
+
+// handleStatusDumps writes a status dump to stderr on every SIGUSR1 until the
+// returned function is called, without otherwise touching the run.
+func handleStatusDumps() func() {
+	ch := make(chan os.Signal, 1)
+	if !notifyStatusDump(ch) {
+		return func() {}
+	}
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ch:
+				dumpStatus(os.Stderr)
+			case <-stopped:
+				return
+			}
+		}
+	}()
+	return func() {
+		stopStatusDump(ch)
+		close(stopped)
+		<-done
+	}
+}
+
+// dumpStatus writes the process-wide status and the db_status aggregate of
+// each database's registered connections, in the order the databases' first
+// connections were opened. Connections a pool has since closed are skipped,
+// their handles are freed.
+func dumpStatus(w io.Writer) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var b strings.Builder
+	b.WriteString("status-dump: global")
+	for _, stat := range collectGlobalStatus(tls, "status-dump") {
+		fmt.Fprintf(&b, " %s=%d/%d", stat.Name, stat.Current, stat.Highwater)
+	}
+	fmt.Fprintln(w, b.String())
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	live, unlock := liveConns()
+	defer unlock()
+	var dbs []string
+	byDB := make(map[string][]uintptr)
+	for _, c := range live {
+		fn, _, _ := strings.Cut(strings.TrimPrefix(c.dsn, "file:"), "?")
+		if _, ok := byDB[fn]; !ok {
+			dbs = append(dbs, fn)
+		}
+		byDB[fn] = append(byDB[fn], c.handle)
+	}
+	for _, fn := range dbs {
+		stats, errs := repro.CollectDBStatus(tls, byDB[fn])
+		warnStatusErrors("status-dump", errs)
+		b.Reset()
+		fmt.Fprintf(&b, "status-dump: %s: conns=%d", fn, len(byDB[fn]))
+		for _, stat := range stats {
+			fmt.Fprintf(&b, " %s=%d", stat.Name, stat.Current)
+		}
+		fmt.Fprintln(w, b.String())
+	}
+	fmt.Fprintf(w, "status-dump: %d databases, %d connections (%d closed, skipped)\n", len(dbs), len(live), len(registry.conns)-len(live))
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// notifyStatusDump reports that there is no SIGUSR1 to relay to ch here.
+func notifyStatusDump(ch chan<- os.Signal) bool {
+	fmt.Fprintf(os.Stderr, "status-dump: %s has no SIGUSR1, status dumps are disabled\n", runtime.GOOS)
+	return false
+}
+
+func stopStatusDump(ch chan<- os.Signal) {}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyStatusDump relays SIGUSR1 to ch and reports whether it could.
+func notifyStatusDump(ch chan<- os.Signal) bool {
+	signal.Notify(ch, syscall.SIGUSR1)
+	return true
+}
+
+func stopStatusDump(ch chan<- os.Signal) {
+	signal.Stop(ch)
+}
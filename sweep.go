@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// sweepRow is the outcome of one -cache-size-sweep run.
+type sweepRow struct {
+	CacheSize int
+	// CacheUsedPeak is the largest aggregated CACHE_USED seen during the run.
+	// SQLite keeps no highwater for CACHE_USED, so it comes from the samples
+	// and a read at the end of the run, see repro.SweepStep.
+	CacheUsedPeak int64
+	// MemUsedHighwater is the run's own, the highwaters are reset before it.
+	MemUsedHighwater int64
+	Selects          rateSummary
+}
+
+// peakCacheUsed returns the largest aggregated CACHE_USED of samples.
+func peakCacheUsed(samples []durationSample) int64 {
+	var peak int64
+	for _, s := range samples {
+		peak = max(peak, s.DB.CacheUsed)
+	}
+	return peak
+}
+
+// printSweepTable writes rows as a table, one row per cache_size in the order
+// they ran.
+func printSweepTable(w io.Writer, rows []sweepRow) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "cache_size\tCACHE_USED peak\tMEMORY_USED highwater\tselects min rows/s\tmean rows/s\tmax rows/s\t")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.0f\t%.0f\t%.0f\t\n", r.CacheSize, r.CacheUsedPeak, r.MemUsedHighwater, r.Selects.Min, r.Selects.Mean, r.Selects.Max)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// The -target values.
+const (
+	// targetFile is the database file of the default workload.
+	targetFile = "file"
+	// targetMemory is the -in-memory database.
+	targetMemory = "memory"
+	// targetSharedCache is the database file of -shared-cache.
+	targetSharedCache = "shared-cache"
+)
+
+// targetNames are the values -target takes.
+var targetNames = []string{targetFile, targetMemory, targetSharedCache}
+
+// targetConfig returns cfg run against target.
+func targetConfig(cfg *Config, target string) repro.Config {
+	wcfg := cfg.Config
+	// Every target gets the same rows.
+	wcfg.Seed = cmp.Or(cfg.Seed, minimalSeed)
+	switch target {
+	case targetMemory:
+		wcfg.InMemory = true
+		if wcfg.JournalMode == "delete" {
+			wcfg.JournalMode = "memory"
+		}
+	case targetSharedCache:
+		wcfg.SharedCache = true
+	}
+	return wcfg
+}
+
+// targetRow is the outcome of one -target run.
+type targetRow struct {
+	Target string
+	Timing timingSummary
+	// Status is the db_status aggregate with every connection still open.
+	Status []repro.OpStat
+	// MemoryUsed is how much MEMORY_USED grew by then, Residual how much of
+	// it was left once the run closed everything.
+	MemoryUsed int64
+	Residual   int64
+}
+
+// compareTargets runs the workload of cfg through repro.RunWorkload once per
+// -target, one after the other, and prints a table comparing them.
+// RunWorkload closes every connection and database before it returns, so a
+// target starts with nothing of the previous one open, its residual shows
+// what the previous one left behind anyway.
+func compareTargets(ctx context.Context, tls *libc.TLS, cfg *Config) error {
+	rows := make([]targetRow, 0, len(cfg.Targets))
+	for _, target := range cfg.Targets {
+		wcfg := targetConfig(cfg, target)
+		before, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		res, err := repro.RunWorkload(ctx, wcfg)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", target, err)
+		}
+		warnStatusErrors("target "+target, res.Errors)
+		after, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+		row := targetRow{Target: target, Timing: summarizeTimings(res.Timings), Status: res.Status, MemoryUsed: res.MemoryUsed, Residual: after - before}
+		fmt.Printf("target: %s: MEMORY_USED=%d residual=%d %v\n", target, row.MemoryUsed, row.Residual, row.Timing)
+		rows = append(rows, row)
+	}
+	return printTargetTable(os.Stdout, rows)
+}
+
+// printTargetTable writes rows as a table, one row per target in the order
+// they ran.
+func printTargetTable(w io.Writer, rows []targetRow) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "target\t")
+	for _, op := range repro.DBStatusOps {
+		fmt.Fprintf(tw, "%s\t", repro.DBStatusOpName(op))
+	}
+	fmt.Fprintln(tw, "MEMORY_USED\tresidual\tinserts mean rows/s\tselects mean rows/s\t")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t", r.Target)
+		for _, stat := range r.Status {
+			fmt.Fprintf(tw, "%d\t", stat.Current)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%.0f\t%.0f\t\n", r.MemoryUsed, r.Residual, r.Timing.Inserts.Mean, r.Timing.Selects.Mean)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"sqlite-repro/repro"
+)
+
+// rateSummary is the min, mean and max of per-database rows per second.
+type rateSummary struct {
+	Min  float64 `json:"min_rows_per_sec"`
+	Mean float64 `json:"mean_rows_per_sec"`
+	Max  float64 `json:"max_rows_per_sec"`
+}
+
+func summarizeRates(rates []float64) rateSummary {
+	if len(rates) == 0 {
+		return rateSummary{}
+	}
+	s := rateSummary{Min: rates[0], Max: rates[0]}
+	var sum float64
+	for _, r := range rates {
+		s.Min, s.Max = min(s.Min, r), max(s.Max, r)
+		sum += r
+	}
+	s.Mean = sum / float64(len(rates))
+	return s
+}
+
+// timingSummary aggregates the repro.PhaseTiming of every database.
+type timingSummary struct {
+	Databases int         `json:"databases"`
+	Inserts   rateSummary `json:"inserts"`
+	Selects   rateSummary `json:"selects"`
+}
+
+func summarizeTimings(timings []repro.PhaseTiming) timingSummary {
+	inserts := make([]float64, 0, len(timings))
+	selects := make([]float64, 0, len(timings))
+	for _, t := range timings {
+		inserts = append(inserts, repro.RowsPerSecond(t.InsertRows, t.Inserts))
+		selects = append(selects, repro.RowsPerSecond(t.SelectRows, t.Selects))
+	}
+	return timingSummary{Databases: len(timings), Inserts: summarizeRates(inserts), Selects: summarizeRates(selects)}
+}
+
+func (s timingSummary) String() string {
+	return fmt.Sprintf("timing: dbs=%d inserts min=%.0f mean=%.0f max=%.0f rows/s selects min=%.0f mean=%.0f max=%.0f rows/s",
+		s.Databases, s.Inserts.Min, s.Inserts.Mean, s.Inserts.Max, s.Selects.Min, s.Selects.Mean, s.Selects.Max)
+}
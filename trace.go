@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// traceStmt logs the SQL text of every statement as it starts running. The db
+// handle is passed as the context so lines can be attributed to connections.
+//
+// It logs the unexpanded text X rather than sqlite3_expanded_sql(P): the
+// latter allocates from SQLite's heap on every statement and would show up in
+// the MEMORY_USED figures this tool measures. The unexpanded text is enough
+// to reveal the implicit statements database/sql issues.
+func traceStmt(tls *libc.TLS, mask uint32, db, p, x uintptr) int32 {
+	fmt.Fprintf(os.Stderr, "trace: db=%#x: %s\n", db, libc.GoString(x))
+	return 0
+}
+
+// installSQLTrace makes db log every statement it executes via traceStmt.
+func installSQLTrace(tls *libc.TLS, db uintptr) error {
+	if rc := sqlite3.Xsqlite3_trace_v2(tls, db, sqlite3.SQLITE_TRACE_STMT, cFuncPointer(traceStmt), db); rc != sqlite3.SQLITE_OK {
+		return fmt.Errorf("sqlite: trace_v2: %v", libc.GoString(sqlite3.Xsqlite3_errstr(tls, rc)))
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// printSQLiteVersion writes the version and source id of the SQLite the binary
+// is built with and its compile-time options, so a run's output identifies it
+// in a bug report.
+func printSQLiteVersion(w io.Writer) {
+	tls := libc.NewTLS()
+	defer tls.Close()
+
+	var options []string
+	for i := int32(0); ; i++ {
+		p := sqlite3.Xsqlite3_compileoption_get(tls, i)
+		if p == 0 {
+			break
+		}
+		options = append(options, libc.GoString(p))
+	}
+	fmt.Fprintf(w, "sqlite: version %s (%d), source id %s\n",
+		libc.GoString(sqlite3.Xsqlite3_libversion(tls)), sqlite3.Xsqlite3_libversion_number(tls), libc.GoString(sqlite3.Xsqlite3_sourceid(tls)))
+	fmt.Fprintf(w, "sqlite: compile options: %s\n", strings.Join(options, " "))
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// vmSteps counts VDBE instructions executed by every connection that has the
+// counting progress handler installed.
+var vmSteps atomic.Int64
+
+func countVMStep(tls *libc.TLS, arg uintptr) int32 {
+	vmSteps.Add(1)
+	return 0
+}
+
+// installVMStepCounter makes db invoke countVMStep after every VDBE
+// instruction, so vmSteps is exact rather than sampled.
+func installVMStepCounter(tls *libc.TLS, db uintptr) {
+	sqlite3.Xsqlite3_progress_handler(tls, db, 1, cFuncPointer(countVMStep), 0)
+}
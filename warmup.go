@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// warmup creates, fills, reads and closes n throwaway databases of warmupRows
+// rows one after another, so that the one-time costs of the first databases,
+// the allocator growing its arenas and SQLite its caches, are paid before the
+// measurement. It then resets the global highwaters so they only cover what
+// comes after.
+func warmup(ctx context.Context, tls *libc.TLS, cfg *Config, n int) error {
+	warmupCfg := *cfg
+	warmupCfg.Inserts, warmupCfg.ROHold, warmupCfg.AttachCount = warmupRows, 0, 0
+	rng := rand.New(rand.NewSource(cfg.DataSeed(0)))
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		registered := registeredConns()
+		closeFunc, _, err := repro.CreateAndTestDb(ctx, &warmupCfg.Config, workloadHooks(), "", rng)
+		dropConns(registered)
+		if cerr := closeFunc(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("warmup: %w", err)
+		}
+	}
+	repro.ResetHighwaters(tls, nil)
+	memUsed, _ := repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED)
+	fmt.Printf("warmup: dbs=%d rows_per_db=%d elapsed=%v MEMORY_USED=%d, highwaters reset\n",
+		n, warmupRows, time.Since(start).Round(time.Millisecond), memUsed)
+	return nil
+}
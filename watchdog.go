@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"modernc.org/libc"
+	sqlite3 "modernc.org/sqlite/lib"
+	"sqlite-repro/repro"
+)
+
+// rssWatchInterval is how often the -max-rss watchdog reads the RSS.
+const rssWatchInterval = 100 * time.Millisecond
+
+// watchedRSS returns the process RSS and where it was read from. Where
+// repro.ProcessRSS can't read it, it is approximated by the memory the Go
+// runtime got from the OS plus SQLite's MEMORY_USED, which libc allocates
+// outside the Go heap.
+func watchedRSS() (int64, string) {
+	if rss, err := repro.ProcessRSS(); err == nil {
+		return rss, "rss"
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var memUsed int64
+	repro.WithTLS(func(tls *libc.TLS) { memUsed, _ = repro.ReadStatus(tls, sqlite3.SQLITE_STATUS_MEMORY_USED) })
+	return int64(m.Sys) + memUsed, "go_sys+MEMORY_USED"
+}
+
+// watchRSS returns a context canceled, with the error as its cause, once the
+// RSS goes above limit bytes, and the function that stops watching and
+// returns that error, nil if the limit was never reached. Before canceling
+// the watchdog writes a status dump to stderr, the last status collected
+// before an OOM kill would have lost it. A limit of 0 watches nothing.
+func watchRSS(ctx context.Context, limit int64) (context.Context, func() error) {
+	if limit <= 0 {
+		return ctx, func() error { return nil }
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	var exceeded error
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(rssWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopped:
+				return
+			}
+			rss, source := watchedRSS()
+			if rss <= limit {
+				continue
+			}
+			exceeded = fmt.Errorf("max-rss: %s %d bytes above -max-rss %d", source, rss, limit)
+			fmt.Fprintf(os.Stderr, "%v, stopping the run\n", exceeded)
+			dumpStatus(os.Stderr)
+			cancel(exceeded)
+			return
+		}
+	}()
+	return ctx, sync.OnceValue(func() error {
+		close(stopped)
+		<-done
+		cancel(nil)
+		return exceeded
+	})
+}